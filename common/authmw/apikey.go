@@ -0,0 +1,405 @@
+package authmw
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"github.com/trustlink/common/cache"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// APIKeyHeader carries an API key issued to a partner integration
+	APIKeyHeader = "X-API-Key"
+	// APIKeyCollection stores API keys, keyed by key ID
+	APIKeyCollection = "apiKeys"
+	// DefaultAPIKeyRateLimit is the requests per minute allowed for a key without its own limit
+	DefaultAPIKeyRateLimit = 60
+
+	apiKeyPrefix = "tlk_"
+	// apiKeyCacheTTL bounds how long a revoked key keeps working in other processes
+	apiKeyCacheTTL = time.Minute
+	// apiKeyLastUsedInterval is how often lastUsedAt is written for a busy key
+	apiKeyLastUsedInterval = time.Minute
+	apiKeyRateWindow       = time.Minute
+)
+
+// API key scopes
+const (
+	ScopeProfileRead      = "profile:read"
+	ScopePostsRead        = "posts:read"
+	ScopePostsWrite       = "posts:write"
+	ScopeConnectionsRead  = "connections:read"
+	ScopeConnectionsWrite = "connections:write"
+)
+
+// Scopes lists every scope that can be granted to an API key
+var Scopes = []string{ScopeProfileRead, ScopePostsRead, ScopePostsWrite, ScopeConnectionsRead, ScopeConnectionsWrite}
+
+// APIKey is stored at apiKeys/{id}. The key itself is never stored, only the
+// SHA-256 of its secret part.
+type APIKey struct {
+	ID                string     `firestore:"-" json:"id"`
+	Name              string     `firestore:"name" json:"name"`
+	UID               string     `firestore:"uid" json:"uid"`
+	Scopes            []string   `firestore:"scopes" json:"scopes"`
+	RateLimit         int        `firestore:"rateLimit" json:"rateLimit"`
+	Hash              string     `firestore:"hash" json:"-"`
+	PreviousHash      string     `firestore:"previousHash,omitempty" json:"-"`
+	PreviousExpiresAt *time.Time `firestore:"previousExpiresAt,omitempty" json:"previousExpiresAt,omitempty"`
+	CreatedBy         string     `firestore:"createdBy" json:"createdBy"`
+	CreatedAt         time.Time  `firestore:"createdAt" json:"createdAt"`
+	RotatedAt         *time.Time `firestore:"rotatedAt,omitempty" json:"rotatedAt,omitempty"`
+	ExpiresAt         *time.Time `firestore:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	RevokedAt         *time.Time `firestore:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	LastUsedAt        *time.Time `firestore:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+}
+
+// HasScope reports whether the key grants scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsScope reports whether name is a known scope
+func IsScope(name string) bool {
+	for _, scope := range Scopes {
+		if scope == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKeyID returns a random ID for a new key
+func NewAPIKeyID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewAPIKeySecret returns a new key "tlk_<id>_<secret>" for id and the hash to store
+func NewAPIKeySecret(id string) (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return apiKeyPrefix + id + "_" + secret, hashAPIKeySecret(secret), nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits a key into its ID and secret
+func parseAPIKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+var apiKeyCache = cache.NewTTL[string, APIKey](apiKeyCacheTTL)
+
+// InvalidateAPIKey forgets the cached key id so changes apply immediately in this process
+func InvalidateAPIKey(id string) {
+	apiKeyCache.Delete(id)
+}
+
+// VerifyAPIKey checks key against its stored hash, expiry and revocation
+func VerifyAPIKey(ctx context.Context, key string, now time.Time) (APIKey, *AuthError) {
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return APIKey{}, unauthorized("Invalid API key", nil)
+	}
+
+	apiKey, err := loadAPIKey(ctx, id)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return APIKey{}, unauthorized("Invalid API key", nil)
+		}
+		return APIKey{}, &AuthError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    "unavailable",
+			Message: "Unable to verify API key",
+			Err:     err,
+		}
+	}
+
+	hash := []byte(hashAPIKeySecret(secret))
+	current := subtle.ConstantTimeCompare(hash, []byte(apiKey.Hash)) == 1
+	previous := apiKey.PreviousHash != "" && apiKey.PreviousExpiresAt != nil && now.Before(*apiKey.PreviousExpiresAt) &&
+		subtle.ConstantTimeCompare(hash, []byte(apiKey.PreviousHash)) == 1
+	if !current && !previous {
+		return APIKey{}, unauthorized("Invalid API key", nil)
+	}
+
+	if apiKey.RevokedAt != nil {
+		return APIKey{}, unauthorized("API key has been revoked", nil)
+	}
+	if apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt) {
+		return APIKey{}, unauthorized("API key has expired", nil)
+	}
+
+	// A secret from before the last rotation was issued no later than the key
+	issuedAt := apiKey.CreatedAt
+	if current && apiKey.RotatedAt != nil {
+		issuedAt = *apiKey.RotatedAt
+	}
+	if authErr := checkAPIKeyOwner(ctx, apiKey.UID, issuedAt); authErr != nil {
+		return APIKey{}, authErr
+	}
+	return apiKey, nil
+}
+
+// checkAPIKeyOwner rejects keys whose owner is disabled, signed out
+// everywhere after the key was issued, or waiting for account deletion.
+// The owner's Auth state shares revocationCache with checkToken.
+func checkAPIKeyOwner(ctx context.Context, uid string, issuedAt time.Time) *AuthError {
+	state, err := authUserStateOf(ctx, authClient(), uid)
+	if err != nil {
+		if auth.IsUserNotFound(err) {
+			return unauthorized("API key owner no longer exists", err)
+		}
+		return &AuthError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    "unavailable",
+			Message: "Unable to verify API key",
+			Err:     err,
+		}
+	}
+
+	if state.disabled {
+		return &AuthError{
+			Status:  http.StatusForbidden,
+			Code:    httpx.CodeAccountDisabled,
+			Message: "Account is disabled",
+		}
+	}
+	if issuedAt.UnixMilli() < state.validAfterMillis {
+		return &AuthError{
+			Status:  http.StatusUnauthorized,
+			Code:    httpx.CodeTokenRevoked,
+			Message: "API key was revoked by signing out everywhere",
+		}
+	}
+
+	pending, err := deletionPendingOf(ctx, uid)
+	if err != nil {
+		return &AuthError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    "unavailable",
+			Message: "Unable to verify API key",
+			Err:     err,
+		}
+	}
+	if pending {
+		return &AuthError{
+			Status:  http.StatusForbidden,
+			Code:    httpx.CodeAccountDisabled,
+			Message: "Account is scheduled for deletion",
+		}
+	}
+	return nil
+}
+
+// AccountDeletionCollection holds pending and finished deletion requests, keyed by uid
+const AccountDeletionCollection = "accountDeletions"
+
+// deletionCache maps uid -> whether a deletion is scheduled or processing
+var deletionCache = cache.NewTTL[string, bool](revocationCacheTTL)
+
+func deletionPendingOf(ctx context.Context, uid string) (bool, error) {
+	if pending, ok := deletionCache.Get(uid); ok {
+		return pending, nil
+	}
+
+	doc, err := firestoredb.GetClient().Collection(AccountDeletionCollection).Doc(uid).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return false, err
+	}
+	pending := false
+	if err == nil {
+		s, _ := doc.Data()["status"].(string)
+		// Matches the profile service's scheduled and processing statuses
+		pending = s == "scheduled" || s == "processing"
+	}
+	deletionCache.Set(uid, pending)
+	return pending, nil
+}
+
+// InvalidateAccountDeletion forgets whether uid has a pending deletion so
+// API keys of the account are re-checked. Other processes notice within
+// revocationCacheTTL.
+func InvalidateAccountDeletion(uid string) {
+	deletionCache.Delete(uid)
+}
+
+func loadAPIKey(ctx context.Context, id string) (APIKey, error) {
+	if apiKey, ok := apiKeyCache.Get(id); ok {
+		return apiKey, nil
+	}
+
+	doc, err := firestoredb.GetClient().Collection(APIKeyCollection).Doc(id).Get(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	var apiKey APIKey
+	if err := doc.DataTo(&apiKey); err != nil {
+		return APIKey{}, fmt.Errorf("failed to parse API key %s: %w", id, err)
+	}
+	apiKey.ID = id
+
+	apiKeyCache.Set(id, apiKey)
+	return apiKey, nil
+}
+
+// AllowAPIKeys lets requests carrying an APIKeyHeader through a route group
+// that also uses AuthMiddleware. Safe methods need readScope and all others
+// need writeScope; an empty scope rejects API keys for those methods. Routes
+// without AllowAPIKeys never accept API keys.
+func AllowAPIKeys(readScope, writeScope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			apiKey, authErr := VerifyAPIKey(r.Context(), key, now)
+			if authErr != nil {
+				log.Warn("API key authentication failed", zap.Error(authErr), zap.String("path", r.URL.Path))
+				WriteAuthError(w, authErr)
+				return
+			}
+
+			scope := writeScope
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = readScope
+			}
+			if scope == "" || !apiKey.HasScope(scope) {
+				log.Warn("API key missing scope",
+					zap.String("keyId", apiKey.ID),
+					zap.String("scope", scope),
+					zap.String("path", r.URL.Path))
+				httpx.Forbidden(w, "API key does not allow this request")
+				return
+			}
+
+			if ok, retryAfter := apiKeyLimiter.Allow(apiKey.ID, apiKey.RateLimit, now); !ok {
+				httpx.TooManyRequests(w, "API key rate limit exceeded", retryAfter)
+				return
+			}
+			touchAPIKey(apiKey.ID, now)
+
+			id := Identity{
+				UID:      apiKey.UID,
+				APIKeyID: apiKey.ID,
+				Scopes:   apiKey.Scopes,
+				IssuedAt: now.Unix(),
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// RejectAPIKeys keeps API keys off a route inside a group that uses AllowAPIKeys
+func RejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, _ := GetIdentity(r.Context()); id.APIKeyID != "" {
+			httpx.Forbidden(w, "API keys cannot be used for this request")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyWindow counts requests made with one key in the current window
+type apiKeyWindow struct {
+	start time.Time
+	count int
+}
+
+// fixedWindowLimiter is an in-memory per-key limiter for single-node deployments
+type fixedWindowLimiter struct {
+	mu      sync.Mutex
+	windows map[string]apiKeyWindow
+}
+
+var apiKeyLimiter = &fixedWindowLimiter{windows: make(map[string]apiKeyWindow)}
+
+// Allow records a request for key if fewer than limit were made this window.
+// Otherwise it returns false and how long until the window resets.
+func (l *fixedWindowLimiter) Allow(key string, limit int, now time.Time) (bool, time.Duration) {
+	if limit <= 0 {
+		limit = DefaultAPIKeyRateLimit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.windows[key]
+	if now.Sub(window.start) >= apiKeyRateWindow {
+		window = apiKeyWindow{start: now}
+	}
+	if window.count >= limit {
+		l.windows[key] = window
+		return false, window.start.Add(apiKeyRateWindow).Sub(now)
+	}
+
+	window.count++
+	l.windows[key] = window
+	return true, 0
+}
+
+var apiKeyLastUsed = cache.NewTTL[string, struct{}](apiKeyLastUsedInterval)
+
+// touchAPIKey records that id was used, at most once per apiKeyLastUsedInterval
+func touchAPIKey(id string, now time.Time) {
+	if _, ok := apiKeyLastUsed.Get(id); ok {
+		return
+	}
+	apiKeyLastUsed.Set(id, struct{}{})
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ref := firestoredb.GetClient().Collection(APIKeyCollection).Doc(id)
+		if _, err := ref.Update(ctx, []firestore.Update{{Path: "lastUsedAt", Value: now}}); err != nil {
+			log.Warn("Failed to record API key use", zap.String("keyId", id), zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,267 @@
+package authmw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/firestoredb/firestoretest"
+)
+
+// storeAPIKey saves apiKey with a fresh secret and returns the key
+func storeAPIKey(t *testing.T, server *firestoretest.Server, apiKey APIKey) string {
+	t.Helper()
+	key, hash, err := NewAPIKeySecret(apiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey.Hash = hash
+	if apiKey.CreatedAt.IsZero() {
+		apiKey.CreatedAt = time.Now()
+	}
+	if _, err := server.Client().Collection(APIKeyCollection).Doc(apiKey.ID).Set(context.Background(), apiKey); err != nil {
+		t.Fatal(err)
+	}
+	// Keep lastUsedAt writes from outliving the test's Firestore
+	apiKeyLastUsed.Set(apiKey.ID, struct{}{})
+	// Owners are active unless a test says otherwise
+	if _, ok := revocationCache.Get(apiKey.UID); !ok {
+		revocationCache.Set(apiKey.UID, authUserState{})
+	}
+	return key
+}
+
+func useAPIKeyFirestore(t *testing.T) *firestoretest.Server {
+	t.Helper()
+	server, err := firestoretest.Install()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeyCache.Clear()
+	t.Cleanup(func() {
+		server.Close()
+		apiKeyCache.Clear()
+		apiKeyLastUsed.Clear()
+		revocationCache.Clear()
+		deletionCache.Clear()
+	})
+	return server
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		key            string
+		wantID, secret string
+		wantOK         bool
+	}{
+		{"tlk_abc_s3cr3t", "abc", "s3cr3t", true},
+		{"tlk_abc_s3_cr3t", "abc", "s3_cr3t", true},
+		{"abc_s3cr3t", "", "", false},
+		{"tlk_abc", "", "", false},
+		{"tlk__s3cr3t", "", "", false},
+		{"tlk_abc_", "", "", false},
+	}
+	for _, tt := range tests {
+		id, secret, ok := parseAPIKey(tt.key)
+		if id != tt.wantID || secret != tt.secret || ok != tt.wantOK {
+			t.Errorf("parseAPIKey(%q) = %q, %q, %v", tt.key, id, secret, ok)
+		}
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	server := useAPIKeyFirestore(t)
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	valid := storeAPIKey(t, server, APIKey{ID: "valid", UID: "alice", Scopes: []string{ScopePostsRead}})
+	revoked := storeAPIKey(t, server, APIKey{ID: "revoked", UID: "alice", RevokedAt: &past})
+	expired := storeAPIKey(t, server, APIKey{ID: "expired", UID: "alice", ExpiresAt: &past})
+	expiring := storeAPIKey(t, server, APIKey{ID: "expiring", UID: "alice", ExpiresAt: &future})
+
+	// Rotated keys accept the previous secret until it expires
+	previous := storeAPIKey(t, server, APIKey{ID: "rotated"})
+	current := storeAPIKey(t, server, APIKey{ID: "rotated", PreviousHash: hashAPIKeySecret(previous[len("tlk_rotated_"):]), PreviousExpiresAt: &future})
+	oldPrevious := storeAPIKey(t, server, APIKey{ID: "stale"})
+	storeAPIKey(t, server, APIKey{ID: "stale", PreviousHash: hashAPIKeySecret(oldPrevious[len("tlk_stale_"):]), PreviousExpiresAt: &past})
+
+	tests := []struct {
+		name   string
+		key    string
+		wantID string
+	}{
+		{"valid", valid, "valid"},
+		{"wrong secret", "tlk_valid_wrong", ""},
+		{"unknown id", "tlk_unknown_secret", ""},
+		{"malformed", "not-a-key", ""},
+		{"revoked", revoked, ""},
+		{"expired", expired, ""},
+		{"not yet expired", expiring, "expiring"},
+		{"rotated, current secret", current, "rotated"},
+		{"rotated, previous secret in grace", previous, "rotated"},
+		{"rotated, previous secret after grace", oldPrevious, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiKey, authErr := VerifyAPIKey(context.Background(), tt.key, now)
+			if tt.wantID == "" {
+				if authErr == nil || authErr.Status != http.StatusUnauthorized {
+					t.Errorf("VerifyAPIKey = %+v, %+v, want 401", apiKey, authErr)
+				}
+				return
+			}
+			if authErr != nil || apiKey.ID != tt.wantID {
+				t.Errorf("VerifyAPIKey = %+v, %+v, want key %s", apiKey, authErr, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestVerifyAPIKeyOwner(t *testing.T) {
+	server := useAPIKeyFirestore(t)
+	now := time.Now()
+	issued := now.Add(-time.Hour)
+
+	revocationCache.Set("disabled", authUserState{disabled: true})
+	revocationCache.Set("signed-out", authUserState{validAfterMillis: now.Add(-time.Minute).UnixMilli()})
+	revocationCache.Set("signed-out-before", authUserState{validAfterMillis: issued.Add(-time.Minute).UnixMilli()})
+	if _, err := server.Client().Collection(AccountDeletionCollection).Doc("leaving").Set(context.Background(), map[string]interface{}{"status": "scheduled"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		owner      string
+		wantStatus int
+	}{
+		{"active", 0},
+		{"disabled", http.StatusForbidden},
+		{"signed-out", http.StatusUnauthorized},
+		{"signed-out-before", 0},
+		{"leaving", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			key := storeAPIKey(t, server, APIKey{ID: tt.owner, UID: tt.owner, CreatedAt: issued})
+			_, authErr := VerifyAPIKey(context.Background(), key, now)
+			if tt.wantStatus == 0 {
+				if authErr != nil {
+					t.Errorf("VerifyAPIKey = %+v, want the key accepted", authErr)
+				}
+				return
+			}
+			if authErr == nil || authErr.Status != tt.wantStatus {
+				t.Errorf("VerifyAPIKey = %+v, want %d", authErr, tt.wantStatus)
+			}
+		})
+	}
+
+	// A rotated secret counts as issued at the rotation
+	rotatedAt := now.Add(-time.Second)
+	key := storeAPIKey(t, server, APIKey{ID: "rotated", UID: "signed-out", CreatedAt: issued, RotatedAt: &rotatedAt})
+	if _, authErr := VerifyAPIKey(context.Background(), key, now); authErr != nil {
+		t.Errorf("secret rotated after signing out rejected: %+v", authErr)
+	}
+}
+
+func TestAllowAPIKeys(t *testing.T) {
+	server := useAPIKeyFirestore(t)
+	reader := storeAPIKey(t, server, APIKey{ID: "reader", UID: "alice", Scopes: []string{ScopePostsRead}, RateLimit: 2})
+	writer := storeAPIKey(t, server, APIKey{ID: "writer", UID: "bob", Scopes: []string{ScopePostsWrite}})
+	old := apiKeyLimiter
+	apiKeyLimiter = &fixedWindowLimiter{windows: make(map[string]apiKeyWindow)}
+	t.Cleanup(func() { apiKeyLimiter = old })
+
+	var got Identity
+	handler := AllowAPIKeys(ScopePostsRead, ScopePostsWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = GetIdentity(r.Context())
+	}))
+	request := func(method, key string) int {
+		got = Identity{}
+		r := httptest.NewRequest(method, "/", nil)
+		if key != "" {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request(http.MethodGet, reader); code != http.StatusOK {
+		t.Fatalf("read with read scope = %d, want 200", code)
+	}
+	want := Identity{UID: "alice", APIKeyID: "reader", Scopes: []string{ScopePostsRead}, IssuedAt: got.IssuedAt}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("identity = %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		key        string
+		wantStatus int
+	}{
+		{"no key passes through", http.MethodPost, "", http.StatusOK},
+		{"write with read scope", http.MethodPost, reader, http.StatusForbidden},
+		{"write with write scope", http.MethodDelete, writer, http.StatusOK},
+		{"read with write scope", http.MethodGet, writer, http.StatusForbidden},
+		{"invalid key", http.MethodGet, "tlk_reader_wrong", http.StatusUnauthorized},
+		{"second read", http.MethodGet, reader, http.StatusOK},
+		{"over the rate limit", http.MethodGet, reader, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if code := request(tt.method, tt.key); code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.wantStatus)
+		}
+	}
+}
+
+func TestRejectAPIKeys(t *testing.T) {
+	handler := RejectAPIKeys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range []struct {
+		id         Identity
+		wantStatus int
+	}{
+		{Identity{UID: "alice"}, http.StatusOK},
+		{Identity{UID: "alice", APIKeyID: "key"}, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(WithIdentity(r.Context(), tt.id))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("identity %+v: status = %d, want %d", tt.id, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestFixedWindowLimiter(t *testing.T) {
+	l := &fixedWindowLimiter{windows: make(map[string]apiKeyWindow)}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("key", 2, now); !ok {
+			t.Fatalf("request %d denied", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("key", 2, now.Add(10*time.Second))
+	if ok || retryAfter != apiKeyRateWindow-10*time.Second {
+		t.Errorf("over the limit = %v, %v, want denied for %v", ok, retryAfter, apiKeyRateWindow-10*time.Second)
+	}
+	if ok, _ := l.Allow("other", 2, now); !ok {
+		t.Error("other key limited")
+	}
+	if ok, _ := l.Allow("key", 2, now.Add(apiKeyRateWindow)); !ok {
+		t.Error("denied in the next window")
+	}
+
+	// A key without its own limit gets the default
+	for i := 0; i < DefaultAPIKeyRateLimit; i++ {
+		l.Allow("default", 0, now)
+	}
+	if ok, _ := l.Allow("default", 0, now); ok {
+		t.Errorf("allowed more than %d requests", DefaultAPIKeyRateLimit)
+	}
+}
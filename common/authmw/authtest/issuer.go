@@ -0,0 +1,185 @@
+// Package authtest issues and verifies RS256 ID tokens locally so services
+// can be exercised end to end without Firebase credentials.
+package authtest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/trustlink/common/authmw"
+)
+
+const (
+	// ProjectID is the audience of tokens signed by an Issuer
+	ProjectID = "trustlink-test"
+	// TokenTTL is how long tokens signed by Issuer.Token stay valid
+	TokenTTL = time.Hour
+
+	keyID = "authtest"
+)
+
+// Issuer signs ID tokens shaped like Firebase's with a key generated when it
+// is created, and verifies them as an authmw.TokenVerifier. It also serves
+// user records as an authmw.AuthClient; every uid exists and is enabled
+// unless disabled or revoked here.
+type Issuer struct {
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	users map[string]auth.UserRecord
+}
+
+// NewIssuer generates a fresh RSA key pair
+func NewIssuer() (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+	return &Issuer{key: key, users: make(map[string]auth.UserRecord)}, nil
+}
+
+// Install makes authmw verify tokens with a new Issuer and returns it
+func Install() (*Issuer, error) {
+	issuer, err := NewIssuer()
+	if err != nil {
+		return nil, err
+	}
+	authmw.SetTokenVerifier(issuer)
+	return issuer, nil
+}
+
+// Token signs a token for uid carrying claims, e.g. {"admin": true}.
+// Claims override the standard ones, so {"aud": "other"} signs a token for
+// another project.
+func (i *Issuer) Token(uid string, claims map[string]interface{}) (string, error) {
+	return i.TokenAt(uid, claims, time.Now(), TokenTTL)
+}
+
+// TokenAt signs a token for uid issued at issuedAt and valid for ttl.
+// A negative ttl produces an expired token.
+func (i *Issuer) TokenAt(uid string, claims map[string]interface{}, issuedAt time.Time, ttl time.Duration) (string, error) {
+	mapClaims := jwt.MapClaims{
+		"iss":       issuerURL(),
+		"aud":       ProjectID,
+		"sub":       uid,
+		"iat":       issuedAt.Unix(),
+		"auth_time": issuedAt.Unix(),
+		"exp":       issuedAt.Add(ttl).Unix(),
+		"firebase":  map[string]interface{}{"sign_in_provider": "custom"},
+	}
+	for k, v := range claims {
+		mapClaims[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, mapClaims)
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(i.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// VerifyIDToken implements authmw.TokenVerifier with the checks Firebase applies
+func (i *Issuer) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	mapClaims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, mapClaims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		if t.Header["kid"] != keyID {
+			return nil, fmt.Errorf("unknown key ID %v", t.Header["kid"])
+		}
+		return &i.key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !mapClaims.VerifyIssuer(issuerURL(), true) {
+		return nil, fmt.Errorf("token has incorrect issuer")
+	}
+	if !mapClaims.VerifyAudience(ProjectID, true) {
+		return nil, fmt.Errorf("token has incorrect audience")
+	}
+	sub, _ := mapClaims["sub"].(string)
+	if sub == "" || len(sub) > 128 {
+		return nil, fmt.Errorf("token has an invalid subject")
+	}
+
+	token := &auth.Token{
+		AuthTime: int64Claim(mapClaims, "auth_time"),
+		Issuer:   issuerURL(),
+		Audience: ProjectID,
+		Expires:  int64Claim(mapClaims, "exp"),
+		IssuedAt: int64Claim(mapClaims, "iat"),
+		Subject:  sub,
+		UID:      sub,
+		Firebase: auth.FirebaseInfo{SignInProvider: "custom"},
+		Claims:   map[string]interface{}{},
+	}
+	for k, v := range mapClaims {
+		token.Claims[k] = v
+	}
+	for _, standardClaim := range []string{"iss", "aud", "exp", "iat", "sub", "uid"} {
+		delete(token.Claims, standardClaim)
+	}
+	return token, nil
+}
+
+// Authorize sets the Authorization header of r to a token for uid
+func (i *Issuer) Authorize(r *http.Request, uid string, claims map[string]interface{}) error {
+	token, err := i.Token(uid, claims)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// GetUser implements authmw.AuthClient
+func (i *Issuer) GetUser(ctx context.Context, uid string) (*auth.UserRecord, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	record := i.users[uid]
+	record.UserInfo = &auth.UserInfo{UID: uid, ProviderID: "custom"}
+	return &record, nil
+}
+
+// DisableUser marks uid as disabled, as an admin disabling the account would
+func (i *Issuer) DisableUser(uid string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	record := i.users[uid]
+	record.Disabled = true
+	i.users[uid] = record
+}
+
+// RevokeTokens invalidates tokens issued to uid before at, as signing out
+// everywhere does. Firebase keeps second precision.
+func (i *Issuer) RevokeTokens(uid string, at time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	record := i.users[uid]
+	record.TokensValidAfterMillis = at.Unix() * 1000
+	i.users[uid] = record
+}
+
+func issuerURL() string {
+	return "https://securetoken.google.com/" + ProjectID
+}
+
+func int64Claim(claims jwt.MapClaims, name string) int64 {
+	v, _ := claims[name].(float64)
+	return int64(v)
+}
@@ -0,0 +1,91 @@
+package authmw
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// Roles are boolean Firebase custom claims, e.g. {"admin": true}
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleVerified  = "verified"
+)
+
+// Roles lists every role that can be granted
+var Roles = []string{RoleAdmin, RoleModerator, RoleVerified}
+
+// IsRole reports whether name is a known role
+func IsRole(name string) bool {
+	for _, role := range Roles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClaims extracts the caller's verified token claims from request context
+func GetClaims(ctx context.Context) (map[string]interface{}, bool) {
+	id, ok := GetIdentity(ctx)
+	if !ok {
+		return nil, false
+	}
+	return id.Claims, true
+}
+
+// HasRole reports whether the caller's token grants role
+func HasRole(ctx context.Context, role string) bool {
+	claims, _ := GetClaims(ctx)
+	granted, _ := claims[role].(bool)
+	return granted
+}
+
+// RequireRole allows only callers holding at least one of roles.
+// It must run after AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, role := range roles {
+				if HasRole(r.Context(), role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			uid, _ := GetUserID(r.Context())
+			log.Warn("Missing required role",
+				zap.String("uid", uid),
+				zap.Strings("roles", roles),
+				zap.String("path", r.URL.Path))
+			httpx.Forbidden(w, "Insufficient permissions")
+		})
+	}
+}
+
+// RequireClaim allows only callers whose token has claim set to value.
+// Claims are decoded from JSON, so numbers must be given as float64, arrays
+// as []interface{} and objects as map[string]interface{}.
+// It must run after AuthMiddleware.
+func RequireClaim(claim string, value interface{}) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := GetClaims(r.Context())
+			if got, ok := claims[claim]; !ok || !reflect.DeepEqual(got, value) {
+				uid, _ := GetUserID(r.Context())
+				log.Warn("Missing required claim",
+					zap.String("uid", uid),
+					zap.String("claim", claim),
+					zap.String("path", r.URL.Path))
+				httpx.Forbidden(w, "Insufficient permissions")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
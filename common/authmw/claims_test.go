@@ -0,0 +1,92 @@
+package authmw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveWithClaims runs a request from alice with claims through middleware
+func serveWithClaims(middleware func(http.Handler) http.Handler, claims map[string]interface{}) int {
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(WithIdentity(r.Context(), Identity{UID: "alice", Claims: claims}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestIsRole(t *testing.T) {
+	for _, role := range Roles {
+		if !IsRole(role) {
+			t.Errorf("IsRole(%q) = false", role)
+		}
+	}
+	if IsRole("superuser") {
+		t.Error(`IsRole("superuser") = true`)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     map[string]interface{}
+		roles      []string
+		wantStatus int
+	}{
+		{"has role", map[string]interface{}{RoleAdmin: true}, []string{RoleAdmin}, http.StatusOK},
+		{"has one of roles", map[string]interface{}{RoleModerator: true}, []string{RoleAdmin, RoleModerator}, http.StatusOK},
+		{"no claims", nil, []string{RoleAdmin}, http.StatusForbidden},
+		{"other role", map[string]interface{}{RoleVerified: true}, []string{RoleAdmin}, http.StatusForbidden},
+		{"role set false", map[string]interface{}{RoleAdmin: false}, []string{RoleAdmin}, http.StatusForbidden},
+		{"role not boolean", map[string]interface{}{RoleAdmin: "true"}, []string{RoleAdmin}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveWithClaims(RequireRole(tt.roles...), tt.claims); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireRoleWithoutIdentity(t *testing.T) {
+	handler := RequireRole(RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestRequireClaim(t *testing.T) {
+	tests := []struct {
+		name       string
+		claim      string
+		value      interface{}
+		claims     map[string]interface{}
+		wantStatus int
+	}{
+		{"matching string", "tier", "pro", map[string]interface{}{"tier": "pro"}, http.StatusOK},
+		{"matching number", "level", float64(3), map[string]interface{}{"level": float64(3)}, http.StatusOK},
+		{"different value", "tier", "pro", map[string]interface{}{"tier": "free"}, http.StatusForbidden},
+		{"different type", "level", 3, map[string]interface{}{"level": float64(3)}, http.StatusForbidden},
+		{"missing", "tier", "pro", nil, http.StatusForbidden},
+		{"matching list", "groups", []interface{}{"a", "b"}, map[string]interface{}{"groups": []interface{}{"a", "b"}}, http.StatusOK},
+		{"different list", "groups", []interface{}{"a"}, map[string]interface{}{"groups": []interface{}{"a", "b"}}, http.StatusForbidden},
+		{"list of another type", "groups", []string{"a", "b"}, map[string]interface{}{"groups": []interface{}{"a", "b"}}, http.StatusForbidden},
+		{"list claim, string value", "groups", "a", map[string]interface{}{"groups": []interface{}{"a"}}, http.StatusForbidden},
+		{"matching object", "org", map[string]interface{}{"id": "acme"}, map[string]interface{}{"org": map[string]interface{}{"id": "acme"}}, http.StatusOK},
+		{"different object", "org", map[string]interface{}{"id": "acme"}, map[string]interface{}{"org": map[string]interface{}{"id": "other"}}, http.StatusForbidden},
+		{"object claim, list value", "org", []interface{}{"acme"}, map[string]interface{}{"org": map[string]interface{}{"id": "acme"}}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveWithClaims(RequireClaim(tt.claim, tt.value), tt.claims); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+}
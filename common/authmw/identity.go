@@ -0,0 +1,90 @@
+package authmw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// IdentityHeader carries the gateway-signed identity of the caller
+	IdentityHeader = "X-TrustLink-Identity"
+
+	// identityMaxAge bounds how long a signed identity can be replayed
+	identityMaxAge = time.Minute
+	// identityClockSkew tolerates small clock differences between gateway and services
+	identityClockSkew = 5 * time.Second
+)
+
+var (
+	errIdentityMalformed = errors.New("malformed identity header")
+	errIdentitySignature = errors.New("invalid identity signature")
+	errIdentityExpired   = errors.New("identity header expired")
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	UID       string                 `json:"uid"`
+	Claims    map[string]interface{} `json:"claims,omitempty"`
+	RequestID string                 `json:"requestId,omitempty"`
+	IssuedAt  int64                  `json:"iat"`
+
+	// APIKeyID and Scopes are set when the caller used an API key
+	APIKeyID string   `json:"apiKeyId,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// SignIdentity encodes id as "<base64 payload>.<base64 HMAC-SHA256>" for IdentityHeader
+func SignIdentity(id Identity, secret []byte) (string, error) {
+	payload, err := json.Marshal(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal identity: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signIdentityPayload(encoded, secret)), nil
+}
+
+// VerifyIdentity checks the signature and age of a signed identity header
+func VerifyIdentity(header string, secret []byte, now time.Time) (Identity, error) {
+	encoded, sig, ok := strings.Cut(header, ".")
+	if !ok {
+		return Identity{}, errIdentityMalformed
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return Identity{}, errIdentityMalformed
+	}
+	if !hmac.Equal(got, signIdentityPayload(encoded, secret)) {
+		return Identity{}, errIdentitySignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Identity{}, errIdentityMalformed
+	}
+
+	var id Identity
+	if err := json.Unmarshal(payload, &id); err != nil || id.UID == "" {
+		return Identity{}, errIdentityMalformed
+	}
+
+	issuedAt := time.Unix(id.IssuedAt, 0)
+	if now.Sub(issuedAt) > identityMaxAge || issuedAt.Sub(now) > identityClockSkew {
+		return Identity{}, errIdentityExpired
+	}
+
+	return id, nil
+}
+
+func signIdentityPayload(encoded string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
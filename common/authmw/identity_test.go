@@ -0,0 +1,128 @@
+package authmw
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-identity-secret")
+
+func signTestIdentity(t *testing.T, id Identity, secret []byte) string {
+	t.Helper()
+	header, err := SignIdentity(id, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func TestSignIdentityRoundTrip(t *testing.T) {
+	now := time.Now()
+	id := Identity{
+		UID:       "alice",
+		Claims:    map[string]interface{}{"admin": true},
+		RequestID: "req-1",
+		IssuedAt:  now.Unix(),
+		APIKeyID:  "key-1",
+		Scopes:    []string{ScopePostsRead},
+	}
+
+	got, err := VerifyIdentity(signTestIdentity(t, id, testSecret), testSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, id) {
+		t.Errorf("VerifyIdentity = %+v, want %+v", got, id)
+	}
+}
+
+func TestVerifyIdentityRejects(t *testing.T) {
+	now := time.Now()
+	valid := signTestIdentity(t, Identity{UID: "alice", IssuedAt: now.Unix()}, testSecret)
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"uid":"mallory","iat":%d}`, now.Unix())))
+
+	tests := []struct {
+		name    string
+		header  string
+		secret  []byte
+		wantErr error
+	}{
+		{"wrong secret", valid, []byte("other-secret"), errIdentitySignature},
+		{"payload swapped", forged + "." + sig, testSecret, errIdentitySignature},
+		{"signature truncated", payload + "." + sig[:len(sig)-4], testSecret, errIdentitySignature},
+		{"no signature", payload, testSecret, errIdentityMalformed},
+		{"signature not base64", payload + ".!!!", testSecret, errIdentityMalformed},
+		{"no uid", signTestIdentity(t, Identity{IssuedAt: now.Unix()}, testSecret), testSecret, errIdentityMalformed},
+		{"too old", signTestIdentity(t, Identity{UID: "alice", IssuedAt: now.Add(-identityMaxAge - time.Second).Unix()}, testSecret), testSecret, errIdentityExpired},
+		{"from the future", signTestIdentity(t, Identity{UID: "alice", IssuedAt: now.Add(identityClockSkew + 2*time.Second).Unix()}, testSecret), testSecret, errIdentityExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifyIdentity(tt.header, tt.secret, now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIdentityToleratesClockSkew(t *testing.T) {
+	now := time.Now()
+	header := signTestIdentity(t, Identity{UID: "alice", IssuedAt: now.Add(identityClockSkew / 2).Unix()}, testSecret)
+	if _, err := VerifyIdentity(header, testSecret, now); err != nil {
+		t.Errorf("identity within clock skew rejected: %v", err)
+	}
+}
+
+func TestVerifyGatewayRequest(t *testing.T) {
+	oldMode, oldSecret := mode, identitySecret
+	mode, identitySecret = ModeGateway, testSecret
+	t.Cleanup(func() { mode, identitySecret = oldMode, oldSecret })
+
+	tests := []struct {
+		name    string
+		header  string
+		wantUID string
+	}{
+		{"valid", signTestIdentity(t, Identity{UID: "alice", IssuedAt: time.Now().Unix()}, testSecret), "alice"},
+		{"missing", "", ""},
+		{"signed with another secret", signTestIdentity(t, Identity{UID: "alice", IssuedAt: time.Now().Unix()}, []byte("other")), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUID string
+			handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUID, _ = GetUserID(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(IdentityHeader, tt.header)
+			}
+			// A bearer token is ignored in gateway mode
+			r.Header.Set("Authorization", "Bearer ignored")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			wantStatus := http.StatusOK
+			if tt.wantUID == "" {
+				wantStatus = http.StatusUnauthorized
+			}
+			if w.Code != wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, wantStatus)
+			}
+			if gotUID != tt.wantUID {
+				t.Errorf("uid = %q, want %q", gotUID, tt.wantUID)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package authmw
+
+import (
+	"os"
+	"testing"
+
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
@@ -2,8 +2,12 @@ package authmw
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/trustlink/common/firebaseapp"
 	"github.com/trustlink/common/httpx"
@@ -17,57 +21,191 @@ type contextKey string
 const (
 	// UserIDKey is the context key for storing user ID
 	UserIDKey contextKey = "userID"
+	// IdentityKey is the context key for storing the caller's Identity
+	IdentityKey contextKey = "identity"
 )
 
-// AuthMiddleware validates Firebase ID tokens and injects uid into context
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			log.Warn("Missing Authorization header", zap.String("path", r.URL.Path))
-			httpx.Unauthorized(w, "Missing Authorization header")
-			return
-		}
+// Auth modes, selected with AUTH_MODE
+const (
+	// ModeFirebase verifies the Firebase ID token on every request
+	ModeFirebase = "firebase"
+	// ModeGateway trusts only identities signed by the gateway
+	ModeGateway = "gateway"
+)
 
-		// Check for Bearer token
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			log.Warn("Invalid Authorization header format", zap.String("path", r.URL.Path))
-			httpx.Unauthorized(w, "Invalid Authorization header format")
-			return
+var (
+	mode           = ModeFirebase
+	identitySecret []byte
+)
+
+// Initialize configures the middleware from AUTH_MODE (firebase or gateway),
+// GATEWAY_IDENTITY_SECRET, which gateway mode requires, and AUTH_CHECK_REVOKED.
+// It installs a FirebaseVerifier unless SetTokenVerifier was called.
+func Initialize() error {
+	mode = os.Getenv("AUTH_MODE")
+	if mode == "" {
+		mode = ModeFirebase
+	}
+	identitySecret = []byte(os.Getenv("GATEWAY_IDENTITY_SECRET"))
+	checkRevoked := os.Getenv("AUTH_CHECK_REVOKED") == "true"
+
+	switch mode {
+	case ModeFirebase:
+	case ModeGateway:
+		if len(identitySecret) == 0 {
+			return fmt.Errorf("GATEWAY_IDENTITY_SECRET environment variable not set")
 		}
+	default:
+		return fmt.Errorf("unknown AUTH_MODE %q", mode)
+	}
+
+	if verifier == nil {
+		verifier = &FirebaseVerifier{Client: firebaseapp.GetAuthClient(), CheckRevoked: checkRevoked}
+	}
+
+	log.Info("Auth middleware initialized", zap.String("mode", mode), zap.Bool("checkRevoked", checkRevoked))
+	return nil
+}
+
+// AuthError is an authentication failure and the response it should produce
+type AuthError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *AuthError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func unauthorized(message string, err error) *AuthError {
+	return &AuthError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: message, Err: err}
+}
+
+// WriteAuthError writes the response for an error returned by this package
+func WriteAuthError(w http.ResponseWriter, err *AuthError) {
+	httpx.WriteError(w, err.Status, err.Code, err.Message)
+}
 
-		// Extract token
-		idToken := strings.TrimPrefix(authHeader, "Bearer ")
-		if idToken == "" {
-			log.Warn("Empty token", zap.String("path", r.URL.Path))
-			httpx.Unauthorized(w, "Empty token")
+// AuthMiddleware authenticates the caller according to the configured mode
+// and injects their uid and Identity into context, unless AllowAPIKeys has
+// already authenticated an API key
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Already authenticated by AllowAPIKeys
+		if id, ok := GetIdentity(r.Context()); ok && id.APIKeyID != "" {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Verify token with Firebase
-		authClient := firebaseapp.GetAuthClient()
-		token, err := authClient.VerifyIDToken(r.Context(), idToken)
-		if err != nil {
-			log.Warn("Invalid token", zap.Error(err), zap.String("path", r.URL.Path))
-			httpx.Unauthorized(w, "Invalid or expired token")
+		var id Identity
+		var authErr *AuthError
+		if mode == ModeGateway {
+			id, authErr = VerifyGatewayRequest(r)
+		} else {
+			id, authErr = VerifyTokenRequest(r)
+		}
+		if authErr != nil {
+			log.Warn("Authentication failed", zap.Error(authErr), zap.String("path", r.URL.Path))
+			WriteAuthError(w, authErr)
 			return
 		}
 
-		// Inject UID into context
-		ctx := context.WithValue(r.Context(), UserIDKey, token.UID)
-
 		log.Debug("Authenticated request",
-			zap.String("uid", token.UID),
+			zap.String("uid", id.UID),
 			zap.String("path", r.URL.Path))
 
 		// Continue to next handler
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
 	})
 }
 
+// VerifyTokenRequest verifies the ID token in r's Authorization header with
+// the configured TokenVerifier
+func VerifyTokenRequest(r *http.Request) (Identity, *AuthError) {
+	idToken, authErr := bearerToken(r)
+	if authErr != nil {
+		return Identity{}, authErr
+	}
+
+	if verifier == nil {
+		return Identity{}, &AuthError{
+			Status:  http.StatusInternalServerError,
+			Code:    "internal_server_error",
+			Message: "Authentication is not configured",
+		}
+	}
+
+	token, err := verifier.VerifyIDToken(r.Context(), idToken)
+	if err != nil {
+		if errors.As(err, &authErr) {
+			return Identity{}, authErr
+		}
+		return Identity{}, unauthorized("Invalid or expired token", err)
+	}
+
+	return Identity{
+		UID:      token.UID,
+		Claims:   token.Claims,
+		IssuedAt: time.Now().Unix(),
+	}, nil
+}
+
+// VerifyGatewayRequest verifies the gateway-signed identity in r's IdentityHeader
+func VerifyGatewayRequest(r *http.Request) (Identity, *AuthError) {
+	header := r.Header.Get(IdentityHeader)
+	if header == "" {
+		return Identity{}, unauthorized("Missing identity header", nil)
+	}
+
+	id, err := VerifyIdentity(header, identitySecret, time.Now())
+	if err != nil {
+		return Identity{}, unauthorized("Invalid identity header", err)
+	}
+	return id, nil
+}
+
+// bearerToken extracts the token from a "Bearer" Authorization header
+func bearerToken(r *http.Request) (string, *AuthError) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", unauthorized("Missing Authorization header", nil)
+	}
+
+	// Check for Bearer token
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", unauthorized("Invalid Authorization header format", nil)
+	}
+
+	idToken := strings.TrimPrefix(authHeader, "Bearer ")
+	if idToken == "" {
+		return "", unauthorized("Empty token", nil)
+	}
+	return idToken, nil
+}
+
+// WithIdentity returns a copy of ctx carrying id
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	ctx = context.WithValue(ctx, IdentityKey, id)
+	return context.WithValue(ctx, UserIDKey, id.UID)
+}
+
 // GetUserID extracts the user ID from request context
 func GetUserID(ctx context.Context) (string, bool) {
 	uid, ok := ctx.Value(UserIDKey).(string)
 	return uid, ok
 }
+
+// GetIdentity extracts the caller's Identity from request context
+func GetIdentity(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(IdentityKey).(Identity)
+	return id, ok
+}
@@ -0,0 +1,154 @@
+package authmw_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/authmw/authtest"
+	"github.com/trustlink/common/httpx"
+)
+
+// installIssuer makes authmw verify tokens with a new issuer, checking
+// revocation against the issuer's users
+func installIssuer(t *testing.T) *authtest.Issuer {
+	t.Helper()
+	issuer, err := authtest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authmw.SetTokenVerifier(&authmw.FirebaseVerifier{Client: issuer, CheckRevoked: true})
+	t.Cleanup(func() { authmw.SetTokenVerifier(nil) })
+	return issuer
+}
+
+// serve runs r through handler and returns the response and its error code
+func serve(t *testing.T, handler http.Handler, r *http.Request) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var body httpx.ErrorResponse
+	if w.Code >= 400 {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("error response is not JSON: %v", err)
+		}
+	}
+	return w, body.Error.Code
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	issuer := installIssuer(t)
+	other, err := authtest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	issuer.RevokeTokens("revoked-user", now)
+	issuer.DisableUser("disabled-user")
+
+	tests := []struct {
+		name       string
+		uid        string
+		token      func() (string, error)
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "valid",
+			uid:        "alice",
+			token:      func() (string, error) { return issuer.Token("alice", nil) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing",
+			token:      func() (string, error) { return "", nil },
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "expired",
+			token: func() (string, error) {
+				return issuer.TokenAt("alice", nil, now.Add(-2*time.Hour), time.Hour)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "wrong issuer",
+			token: func() (string, error) {
+				return issuer.Token("alice", map[string]interface{}{"iss": "https://securetoken.google.com/other"})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "wrong audience",
+			token: func() (string, error) {
+				return issuer.Token("alice", map[string]interface{}{"aud": "other"})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name:       "wrong key",
+			token:      func() (string, error) { return other.Token("alice", nil) },
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "revoked",
+			token: func() (string, error) {
+				return issuer.TokenAt("revoked-user", nil, now.Add(-time.Minute), time.Hour)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   httpx.CodeTokenRevoked,
+		},
+		{
+			name:       "issued after revocation",
+			uid:        "revoked-user",
+			token:      func() (string, error) { return issuer.Token("revoked-user", nil) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "disabled",
+			token:      func() (string, error) { return issuer.Token("disabled-user", nil) },
+			wantStatus: http.StatusForbidden,
+			wantCode:   httpx.CodeAccountDisabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotUID string
+			handler := authmw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUID, _ = authmw.GetUserID(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w, code := serve(t, handler, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if gotUID != tt.uid {
+				t.Errorf("uid = %q, want %q", gotUID, tt.uid)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package authmw
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/trustlink/common/cache"
+	"github.com/trustlink/common/firebaseapp"
+	"github.com/trustlink/common/httpx"
+)
+
+// revocationCacheTTL bounds how long a revocation or disable can go unnoticed
+const revocationCacheTTL = 30 * time.Second
+
+// authUserState is the part of a Firebase Auth user needed to check a token
+type authUserState struct {
+	validAfterMillis int64
+	disabled         bool
+}
+
+var revocationCache = cache.NewTTL[string, authUserState](revocationCacheTTL)
+
+// checkToken rejects tokens of disabled users and tokens issued before the
+// user's refresh tokens were revoked, like VerifyIDTokenAndCheckRevoked but
+// with the user record cached for revocationCacheTTL
+func checkToken(ctx context.Context, client AuthClient, token *auth.Token) *AuthError {
+	state, err := authUserStateOf(ctx, client, token.UID)
+	if err != nil {
+		if auth.IsUserNotFound(err) {
+			return unauthorized("Account no longer exists", err)
+		}
+		return &AuthError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    "unavailable",
+			Message: "Unable to verify session",
+			Err:     err,
+		}
+	}
+
+	if state.disabled {
+		return &AuthError{
+			Status:  http.StatusForbidden,
+			Code:    httpx.CodeAccountDisabled,
+			Message: "Account is disabled",
+		}
+	}
+	if token.IssuedAt*1000 < state.validAfterMillis {
+		return &AuthError{
+			Status:  http.StatusUnauthorized,
+			Code:    httpx.CodeTokenRevoked,
+			Message: "Token has been revoked",
+		}
+	}
+	return nil
+}
+
+// authClient returns the client of the installed FirebaseVerifier, which
+// also looks up users outside of token checks
+func authClient() AuthClient {
+	if v, ok := verifier.(*FirebaseVerifier); ok && v.Client != nil {
+		return v.Client
+	}
+	return firebaseapp.GetAuthClient()
+}
+
+func authUserStateOf(ctx context.Context, client AuthClient, uid string) (authUserState, error) {
+	if state, ok := revocationCache.Get(uid); ok {
+		return state, nil
+	}
+
+	record, err := client.GetUser(ctx, uid)
+	if err != nil {
+		return authUserState{}, err
+	}
+	state := authUserState{
+		validAfterMillis: record.TokensValidAfterMillis,
+		disabled:         record.Disabled,
+	}
+	revocationCache.Set(uid, state)
+	return state, nil
+}
+
+// InvalidateRevocationCache forgets the cached Auth state of uid so the next
+// request re-checks it. Other processes notice within revocationCacheTTL.
+func InvalidateRevocationCache(uid string) {
+	revocationCache.Delete(uid)
+}
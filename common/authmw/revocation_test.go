@@ -0,0 +1,56 @@
+package authmw
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/trustlink/common/httpx"
+)
+
+func TestCheckToken(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour)
+	revocationCache.Set("active", authUserState{})
+	revocationCache.Set("disabled", authUserState{disabled: true})
+	revocationCache.Set("revoked", authUserState{validAfterMillis: revokedAt.UnixMilli()})
+	t.Cleanup(revocationCache.Clear)
+
+	tests := []struct {
+		name       string
+		uid        string
+		issuedAt   time.Time
+		wantStatus int
+		wantCode   string
+	}{
+		{"active", "active", time.Now(), 0, ""},
+		{"disabled", "disabled", time.Now(), http.StatusForbidden, httpx.CodeAccountDisabled},
+		{"issued before revocation", "revoked", revokedAt.Add(-time.Minute), http.StatusUnauthorized, httpx.CodeTokenRevoked},
+		{"issued after revocation", "revoked", revokedAt.Add(time.Minute), 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authErr := checkToken(context.Background(), nil, &auth.Token{UID: tt.uid, IssuedAt: tt.issuedAt.Unix()})
+			if tt.wantStatus == 0 {
+				if authErr != nil {
+					t.Errorf("checkToken = %+v, want nil", authErr)
+				}
+				return
+			}
+			if authErr == nil || authErr.Status != tt.wantStatus || authErr.Code != tt.wantCode {
+				t.Errorf("checkToken = %+v, want %d %s", authErr, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestInvalidateRevocationCache(t *testing.T) {
+	revocationCache.Set("alice", authUserState{disabled: true})
+	t.Cleanup(revocationCache.Clear)
+
+	InvalidateRevocationCache("alice")
+	if _, ok := revocationCache.Get("alice"); ok {
+		t.Error("alice still cached after invalidation")
+	}
+}
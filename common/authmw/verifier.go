@@ -0,0 +1,51 @@
+package authmw
+
+import (
+	"context"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// TokenVerifier verifies the bearer ID token of a request. *auth.Client
+// satisfies it; authtest.Issuer verifies locally signed tokens offline.
+// Returning an *AuthError controls the response, other errors become 401.
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
+
+// verifier is the TokenVerifier used by AuthMiddleware and VerifyTokenRequest
+var verifier TokenVerifier
+
+// SetTokenVerifier replaces the verifier used to check ID tokens.
+// Call it before Initialize to keep Initialize from installing Firebase.
+func SetTokenVerifier(v TokenVerifier) {
+	verifier = v
+}
+
+// AuthClient verifies ID tokens and looks up users. *auth.Client satisfies
+// it; authtest.Issuer keeps users in memory.
+type AuthClient interface {
+	TokenVerifier
+	GetUser(ctx context.Context, uid string) (*auth.UserRecord, error)
+}
+
+// FirebaseVerifier verifies Firebase ID tokens and, with CheckRevoked,
+// rejects revoked tokens and disabled users
+type FirebaseVerifier struct {
+	Client       AuthClient
+	CheckRevoked bool
+}
+
+// VerifyIDToken implements TokenVerifier
+func (v *FirebaseVerifier) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	token, err := v.Client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	if v.CheckRevoked {
+		if authErr := checkToken(ctx, v.Client, token); authErr != nil {
+			return nil, authErr
+		}
+	}
+	return token, nil
+}
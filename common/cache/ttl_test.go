@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTTLGetSet(t *testing.T) {
+	c := NewTTL[string, int](time.Minute)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Get on an empty cache succeeded")
+	}
+
+	c.Set("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get = %v, %v, want 1, true", v, ok)
+	}
+
+	c.Set("a", 2)
+	if v, _ := c.Get("a"); v != 2 {
+		t.Fatalf("Get after overwrite = %v, want 2", v)
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	c := NewTTL[string, int](20 * time.Millisecond)
+	c.Set("short", 1)
+	c.SetWithTTL("long", 2, time.Minute)
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, ok := c.Get("short"); ok {
+		t.Error("entry outlived the default TTL")
+	}
+	if v, ok := c.Get("long"); !ok || v != 2 {
+		t.Errorf("entry with a custom TTL expired early: %v, %v", v, ok)
+	}
+}
+
+func TestTTLDeleteAndClear(t *testing.T) {
+	c := NewTTL[string, int](time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("deleted entry still present")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("Delete removed another entry")
+	}
+
+	c.Clear()
+	if _, ok := c.Get("b"); ok {
+		t.Error("entry present after Clear")
+	}
+}
+
+func TestTTLEvictsExpiredEntriesAsItGrows(t *testing.T) {
+	c := NewTTL[int, int](time.Millisecond)
+	for i := 0; i < 100; i++ {
+		c.Set(i, i)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Growing the map past the next sweep point drops the expired entries
+	for i := 100; i < 300; i++ {
+		c.SetWithTTL(i, i, time.Minute)
+	}
+
+	c.mu.RLock()
+	n := len(c.entries)
+	c.mu.RUnlock()
+	if n != 200 {
+		t.Fatalf("%d entries left, want the 200 live ones", n)
+	}
+}
+
+func TestTTLConcurrentAccess(t *testing.T) {
+	c := NewTTL[string, int](time.Minute)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := strconv.Itoa(i % 50)
+				c.Set(key, g)
+				c.Get(key)
+				if i%10 == 0 {
+					c.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package firestoredb
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// DeleteDocuments deletes every document matched by q. It returns the IDs
+// that were deleted, even when some deletes fail.
+func DeleteDocuments(ctx context.Context, q firestore.Query) ([]string, error) {
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+
+	bw := Client.BulkWriter(ctx)
+	var ids []string
+	var jobs []*firestore.BulkWriterJob
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			return nil, fmt.Errorf("failed to iterate documents: %w", err)
+		}
+
+		job, err := bw.Delete(doc.Ref)
+		if err != nil {
+			bw.End()
+			return nil, fmt.Errorf("failed to queue delete of %s: %w", doc.Ref.Path, err)
+		}
+		ids = append(ids, doc.Ref.ID)
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	var deleted []string
+	var firstErr error
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to delete document %s: %w", ids[i], err)
+			}
+			continue
+		}
+		deleted = append(deleted, ids[i])
+	}
+
+	return deleted, firstErr
+}
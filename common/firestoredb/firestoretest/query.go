@@ -0,0 +1,320 @@
+package firestoretest
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// documentID is the field path queries use for document names
+const documentID = "__name__"
+
+// runQuery returns the documents under parent matching query, in order
+func (s *Server) runQuery(parent string, query *pb.StructuredQuery) ([]*pb.Document, error) {
+	if len(query.From) != 1 {
+		return nil, status.Error(codes.InvalidArgument, "queries must select exactly one collection")
+	}
+	from := query.From[0]
+
+	orders, err := queryOrders(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []*pb.Document
+	for name, doc := range s.docs {
+		if !inCollection(parent, name, from) {
+			continue
+		}
+		ok, err := matches(doc, query.Where)
+		if err != nil {
+			return nil, err
+		}
+		if ok && hasFields(doc, orders) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return compareDocs(docs[i], docs[j], orders) < 0
+	})
+
+	var result []*pb.Document
+	for _, doc := range docs {
+		if query.StartAt != nil && !afterStart(doc, orders, query.StartAt) {
+			continue
+		}
+		if query.EndAt != nil && !beforeEnd(doc, orders, query.EndAt) {
+			continue
+		}
+		result = append(result, doc)
+	}
+
+	offset := int(query.Offset)
+	if offset > len(result) {
+		offset = len(result)
+	}
+	result = result[offset:]
+	if query.Limit != nil && int(query.Limit.Value) < len(result) {
+		result = result[:query.Limit.Value]
+	}
+	return result, nil
+}
+
+type order struct {
+	path       []string
+	descending bool
+}
+
+// queryOrders returns the explicit orders of query followed by the implicit
+// ones Firestore adds: the first inequality field when nothing is ordered,
+// then the document name
+func queryOrders(query *pb.StructuredQuery) ([]order, error) {
+	var orders []order
+	for _, o := range query.OrderBy {
+		path, err := parseFieldPath(o.Field.FieldPath)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order{path: path, descending: o.Direction == pb.StructuredQuery_DESCENDING})
+	}
+
+	if len(orders) == 0 {
+		if field := inequalityField(query.Where); field != "" {
+			path, err := parseFieldPath(field)
+			if err != nil {
+				return nil, err
+			}
+			orders = append(orders, order{path: path})
+		}
+	}
+
+	if len(orders) == 0 || !isDocumentID(orders[len(orders)-1].path) {
+		descending := len(orders) > 0 && orders[len(orders)-1].descending
+		orders = append(orders, order{path: []string{documentID}, descending: descending})
+	}
+	return orders, nil
+}
+
+func inequalityField(filter *pb.StructuredQuery_Filter) string {
+	switch f := filter.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		switch f.FieldFilter.Op {
+		case pb.StructuredQuery_FieldFilter_LESS_THAN, pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL,
+			pb.StructuredQuery_FieldFilter_GREATER_THAN, pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL,
+			pb.StructuredQuery_FieldFilter_NOT_EQUAL, pb.StructuredQuery_FieldFilter_NOT_IN:
+			return f.FieldFilter.Field.FieldPath
+		}
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, sub := range f.CompositeFilter.Filters {
+			if field := inequalityField(sub); field != "" {
+				return field
+			}
+		}
+	}
+	return ""
+}
+
+func isDocumentID(path []string) bool {
+	return len(path) == 1 && path[0] == documentID
+}
+
+// inCollection reports whether the document called name belongs to the
+// collection selected under parent
+func inCollection(parent, name string, from *pb.StructuredQuery_CollectionSelector) bool {
+	if !strings.HasPrefix(name, parent+"/") {
+		return false
+	}
+	segments := strings.Split(strings.TrimPrefix(name, parent+"/"), "/")
+	if from.AllDescendants {
+		return len(segments)%2 == 0 && segments[len(segments)-2] == from.CollectionId
+	}
+	return len(segments) == 2 && segments[0] == from.CollectionId
+}
+
+// fieldValue returns the value at path in doc; the document name is
+// available as a reference
+func fieldValue(doc *pb.Document, path []string) (*pb.Value, bool) {
+	if isDocumentID(path) {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: doc.Name}}, true
+	}
+	return getField(doc.Fields, path)
+}
+
+func hasFields(doc *pb.Document, orders []order) bool {
+	for _, o := range orders {
+		if _, ok := fieldValue(doc, o.path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func compareDocs(a, b *pb.Document, orders []order) int {
+	for _, o := range orders {
+		va, _ := fieldValue(a, o.path)
+		vb, _ := fieldValue(b, o.path)
+		c := compareValues(va, vb)
+		if o.descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareToCursor orders doc against the position the cursor values describe
+func compareToCursor(doc *pb.Document, orders []order, cursor *pb.Cursor) int {
+	for i, v := range cursor.Values {
+		if i >= len(orders) {
+			break
+		}
+		dv, _ := fieldValue(doc, orders[i].path)
+		c := compareValues(dv, v)
+		if orders[i].descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func afterStart(doc *pb.Document, orders []order, cursor *pb.Cursor) bool {
+	c := compareToCursor(doc, orders, cursor)
+	if cursor.Before {
+		return c >= 0
+	}
+	return c > 0
+}
+
+func beforeEnd(doc *pb.Document, orders []order, cursor *pb.Cursor) bool {
+	c := compareToCursor(doc, orders, cursor)
+	if cursor.Before {
+		return c < 0
+	}
+	return c <= 0
+}
+
+func matches(doc *pb.Document, filter *pb.StructuredQuery_Filter) (bool, error) {
+	switch f := filter.GetFilterType().(type) {
+	case nil:
+		return true, nil
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		or := f.CompositeFilter.Op == pb.StructuredQuery_CompositeFilter_OR
+		for _, sub := range f.CompositeFilter.Filters {
+			ok, err := matches(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			if ok == or {
+				return or, nil
+			}
+		}
+		return !or, nil
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		return matchesField(doc, f.FieldFilter)
+	case *pb.StructuredQuery_Filter_UnaryFilter:
+		return matchesUnary(doc, f.UnaryFilter)
+	}
+	return false, status.Error(codes.InvalidArgument, "unsupported filter")
+}
+
+func matchesField(doc *pb.Document, f *pb.StructuredQuery_FieldFilter) (bool, error) {
+	path, err := parseFieldPath(f.Field.FieldPath)
+	if err != nil {
+		return false, err
+	}
+	v, ok := fieldValue(doc, path)
+	if !ok {
+		return false, nil
+	}
+
+	// Range filters only match values of the same type
+	sameType := typeOrder(v) == typeOrder(f.Value)
+	switch f.Op {
+	case pb.StructuredQuery_FieldFilter_EQUAL:
+		return compareValues(v, f.Value) == 0, nil
+	case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+		return !isNull(v) && compareValues(v, f.Value) != 0, nil
+	case pb.StructuredQuery_FieldFilter_LESS_THAN:
+		return sameType && compareValues(v, f.Value) < 0, nil
+	case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+		return sameType && compareValues(v, f.Value) <= 0, nil
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+		return sameType && compareValues(v, f.Value) > 0, nil
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+		return sameType && compareValues(v, f.Value) >= 0, nil
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:
+		return containsValue(v.GetArrayValue().GetValues(), f.Value), nil
+	case pb.StructuredQuery_FieldFilter_IN:
+		return containsValue(f.Value.GetArrayValue().GetValues(), v), nil
+	case pb.StructuredQuery_FieldFilter_NOT_IN:
+		return !isNull(v) && !containsValue(f.Value.GetArrayValue().GetValues(), v), nil
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+		for _, want := range f.Value.GetArrayValue().GetValues() {
+			if containsValue(v.GetArrayValue().GetValues(), want) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument, "unsupported operator %v", f.Op)
+}
+
+func matchesUnary(doc *pb.Document, f *pb.StructuredQuery_UnaryFilter) (bool, error) {
+	path, err := parseFieldPath(f.GetField().GetFieldPath())
+	if err != nil {
+		return false, err
+	}
+	v, ok := fieldValue(doc, path)
+	if !ok {
+		return false, nil
+	}
+
+	isNaN := isNumber(v) && math.IsNaN(toFloat(v))
+	switch f.Op {
+	case pb.StructuredQuery_UnaryFilter_IS_NULL:
+		return isNull(v), nil
+	case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+		return !isNull(v), nil
+	case pb.StructuredQuery_UnaryFilter_IS_NAN:
+		return isNaN, nil
+	case pb.StructuredQuery_UnaryFilter_IS_NOT_NAN:
+		return !isNaN, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument, "unsupported operator %v", f.Op)
+}
+
+func isNull(v *pb.Value) bool {
+	_, ok := v.GetValueType().(*pb.Value_NullValue)
+	return ok
+}
+
+// project keeps only the selected fields of doc
+func project(doc *pb.Document, selection *pb.StructuredQuery_Projection) *pb.Document {
+	clone := &pb.Document{Name: doc.Name, CreateTime: doc.CreateTime, UpdateTime: doc.UpdateTime}
+	if selection == nil {
+		clone.Fields = cloneFields(doc.Fields)
+		return clone
+	}
+
+	clone.Fields = map[string]*pb.Value{}
+	for _, field := range selection.Fields {
+		path, err := parseFieldPath(field.FieldPath)
+		if err != nil || isDocumentID(path) {
+			continue
+		}
+		if v, ok := getField(doc.Fields, path); ok {
+			setField(clone.Fields, path, v)
+		}
+	}
+	return clone
+}
@@ -0,0 +1,443 @@
+// Package firestoretest serves an in-memory Firestore over an in-process gRPC
+// connection so services can be exercised end to end without the emulator
+// or credentials.
+package firestoretest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/trustlink/common/firestoredb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ProjectID is the project of clients connected to a Server
+const ProjectID = "trustlink-test"
+
+// Server keeps documents in memory and implements the Firestore RPCs the
+// Go client uses for reads, queries, writes, transactions and bulk writes.
+// Transactions are optimistic: a commit whose reads have changed since they
+// were made is aborted, and the client retries it.
+type Server struct {
+	pb.UnimplementedFirestoreServer
+
+	grpcServer *grpc.Server
+	conn       *grpc.ClientConn
+	client     *firestore.Client
+	previous   *firestore.Client
+
+	mu       sync.Mutex
+	docs     map[string]*pb.Document
+	versions map[string]int64
+	txs      map[string]*transaction
+	nextTx   int
+	lastTime time.Time
+}
+
+// transaction records what a transaction read so a commit can detect
+// concurrent writes
+type transaction struct {
+	docs    map[string]int64
+	queries []queryRead
+}
+
+type queryRead struct {
+	parent string
+	query  *pb.StructuredQuery
+	result string
+}
+
+// NewServer starts a Server and connects a client to it
+func NewServer() (*Server, error) {
+	s := &Server{
+		docs:     make(map[string]*pb.Document),
+		versions: make(map[string]int64),
+		txs:      make(map[string]*transaction),
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	s.grpcServer = grpc.NewServer()
+	pb.RegisterFirestoreServer(s.grpcServer, s)
+	go s.grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial("firestoretest",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		s.grpcServer.Stop()
+		return nil, fmt.Errorf("failed to dial in-memory Firestore: %w", err)
+	}
+	s.conn = conn
+
+	s.client, err = firestore.NewClient(context.Background(), ProjectID, option.WithGRPCConn(conn))
+	if err != nil {
+		conn.Close()
+		s.grpcServer.Stop()
+		return nil, fmt.Errorf("failed to create Firestore client: %w", err)
+	}
+	return s, nil
+}
+
+// Install makes firestoredb use a new Server until it is closed
+func Install() (*Server, error) {
+	s, err := NewServer()
+	if err != nil {
+		return nil, err
+	}
+	s.previous = firestoredb.Client
+	firestoredb.Client = s.client
+	return s, nil
+}
+
+// Client returns a client connected to s
+func (s *Server) Client() *firestore.Client {
+	return s.client
+}
+
+// Close stops s, restoring the client firestoredb used before Install
+func (s *Server) Close() error {
+	if firestoredb.Client == s.client {
+		firestoredb.Client = s.previous
+	}
+	err := s.client.Close()
+	s.grpcServer.Stop()
+	return err
+}
+
+// BatchGetDocuments implements pb.FirestoreServer
+func (s *Server) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	s.mu.Lock()
+	tx, err := s.readTransaction(req.GetTransaction())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	readTime := timestamppb.New(s.now())
+	var responses []*pb.BatchGetDocumentsResponse
+	for _, name := range req.Documents {
+		if tx != nil {
+			tx.docs[name] = s.versions[name]
+		}
+		if doc, ok := s.docs[name]; ok {
+			responses = append(responses, &pb.BatchGetDocumentsResponse{
+				Result:   &pb.BatchGetDocumentsResponse_Found{Found: proto.Clone(doc).(*pb.Document)},
+				ReadTime: readTime,
+			})
+		} else {
+			responses = append(responses, &pb.BatchGetDocumentsResponse{
+				Result:   &pb.BatchGetDocumentsResponse_Missing{Missing: name},
+				ReadTime: readTime,
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, resp := range responses {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunQuery implements pb.FirestoreServer
+func (s *Server) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	query := req.GetStructuredQuery()
+	if query == nil {
+		return status.Error(codes.InvalidArgument, "only structured queries are supported")
+	}
+
+	s.mu.Lock()
+	tx, err := s.readTransaction(req.GetTransaction())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	docs, err := s.runQuery(req.Parent, query)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if tx != nil {
+		tx.queries = append(tx.queries, queryRead{parent: req.Parent, query: query, result: s.fingerprint(docs)})
+	}
+	readTime := timestamppb.New(s.now())
+	s.mu.Unlock()
+
+	if len(docs) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: readTime})
+	}
+	for _, doc := range docs {
+		if err := stream.Send(&pb.RunQueryResponse{Document: project(doc, query.Select), ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeginTransaction implements pb.FirestoreServer
+func (s *Server) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextTx++
+	id := fmt.Sprintf("tx-%d", s.nextTx)
+	s.txs[id] = &transaction{docs: make(map[string]int64)}
+	return &pb.BeginTransactionResponse{Transaction: []byte(id)}, nil
+}
+
+// Rollback implements pb.FirestoreServer
+func (s *Server) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.txs, string(req.Transaction))
+	return &emptypb.Empty{}, nil
+}
+
+// Commit implements pb.FirestoreServer. Writes are applied atomically.
+func (s *Server) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(req.Transaction) > 0 {
+		tx, err := s.readTransaction(req.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.txs, string(req.Transaction))
+		if err := s.validate(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	now := s.now()
+	staged := make(map[string]*pb.Document)
+	deleted := make(map[string]bool)
+	results := make([]*pb.WriteResult, 0, len(req.Writes))
+	for _, w := range req.Writes {
+		result, err := s.apply(w, staged, deleted, now)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	s.save(staged, deleted)
+
+	return &pb.CommitResponse{WriteResults: results, CommitTime: timestamppb.New(now)}, nil
+}
+
+// BatchWrite implements pb.FirestoreServer. Each write succeeds or fails on its own.
+func (s *Server) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest) (*pb.BatchWriteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &pb.BatchWriteResponse{}
+	for _, w := range req.Writes {
+		now := s.now()
+		staged := make(map[string]*pb.Document)
+		deleted := make(map[string]bool)
+		result, err := s.apply(w, staged, deleted, now)
+		if err != nil {
+			resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{})
+			resp.Status = append(resp.Status, status.Convert(err).Proto())
+			continue
+		}
+		s.save(staged, deleted)
+		resp.WriteResults = append(resp.WriteResults, result)
+		resp.Status = append(resp.Status, status.New(codes.OK, "").Proto())
+	}
+	return resp, nil
+}
+
+// readTransaction returns the transaction with id, or nil for reads outside one
+func (s *Server) readTransaction(id []byte) (*transaction, error) {
+	if len(id) == 0 {
+		return nil, nil
+	}
+	tx, ok := s.txs[string(id)]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "transaction %q is not active", id)
+	}
+	return tx, nil
+}
+
+// validate aborts tx if anything it read has been written since
+func (s *Server) validate(tx *transaction) error {
+	for name, version := range tx.docs {
+		if s.versions[name] != version {
+			return status.Errorf(codes.Aborted, "%s changed during the transaction", name)
+		}
+	}
+	for _, read := range tx.queries {
+		docs, err := s.runQuery(read.parent, read.query)
+		if err != nil {
+			return err
+		}
+		if s.fingerprint(docs) != read.result {
+			return status.Error(codes.Aborted, "query results changed during the transaction")
+		}
+	}
+	return nil
+}
+
+// fingerprint identifies query results and the versions of their documents
+func (s *Server) fingerprint(docs []*pb.Document) string {
+	var b strings.Builder
+	for _, doc := range docs {
+		fmt.Fprintf(&b, "%s@%d;", doc.Name, s.versions[doc.Name])
+	}
+	return b.String()
+}
+
+// current returns the document called name as staged by earlier writes in
+// the same commit, or as stored
+func (s *Server) current(name string, staged map[string]*pb.Document, deleted map[string]bool) *pb.Document {
+	if deleted[name] {
+		return nil
+	}
+	if doc, ok := staged[name]; ok {
+		return doc
+	}
+	return s.docs[name]
+}
+
+// apply stages one write
+func (s *Server) apply(w *pb.Write, staged map[string]*pb.Document, deleted map[string]bool, now time.Time) (*pb.WriteResult, error) {
+	var name string
+	switch op := w.Operation.(type) {
+	case *pb.Write_Update:
+		name = op.Update.Name
+	case *pb.Write_Delete:
+		name = op.Delete
+	case *pb.Write_Transform:
+		name = op.Transform.Document
+	default:
+		return nil, status.Error(codes.InvalidArgument, "write has no operation")
+	}
+
+	existing := s.current(name, staged, deleted)
+	if err := checkPrecondition(name, existing, w.CurrentDocument); err != nil {
+		return nil, err
+	}
+
+	if op, ok := w.Operation.(*pb.Write_Delete); ok {
+		deleted[op.Delete] = true
+		delete(staged, op.Delete)
+		return &pb.WriteResult{UpdateTime: timestamppb.New(now)}, nil
+	}
+
+	fields := map[string]*pb.Value{}
+	createTime := timestamppb.New(now)
+	if existing != nil {
+		createTime = existing.CreateTime
+	}
+
+	transforms := w.UpdateTransforms
+	switch op := w.Operation.(type) {
+	case *pb.Write_Update:
+		if w.UpdateMask != nil {
+			if existing != nil {
+				fields = cloneFields(existing.Fields)
+			}
+			for _, path := range w.UpdateMask.FieldPaths {
+				segments, err := parseFieldPath(path)
+				if err != nil {
+					return nil, err
+				}
+				if v, ok := getField(op.Update.Fields, segments); ok {
+					setField(fields, segments, proto.Clone(v).(*pb.Value))
+				} else {
+					deleteField(fields, segments)
+				}
+			}
+		} else {
+			fields = cloneFields(op.Update.Fields)
+		}
+	case *pb.Write_Transform:
+		if existing != nil {
+			fields = cloneFields(existing.Fields)
+		}
+		transforms = op.Transform.FieldTransforms
+	}
+
+	result := &pb.WriteResult{UpdateTime: timestamppb.New(now)}
+	for _, t := range transforms {
+		v, err := applyTransform(fields, t, now)
+		if err != nil {
+			return nil, err
+		}
+		result.TransformResults = append(result.TransformResults, v)
+	}
+
+	staged[name] = &pb.Document{
+		Name:       name,
+		Fields:     fields,
+		CreateTime: createTime,
+		UpdateTime: timestamppb.New(now),
+	}
+	delete(deleted, name)
+	return result, nil
+}
+
+// save stores staged documents and removes deleted ones
+func (s *Server) save(staged map[string]*pb.Document, deleted map[string]bool) {
+	for name := range deleted {
+		if _, ok := s.docs[name]; ok {
+			delete(s.docs, name)
+			s.versions[name]++
+		}
+	}
+	for name, doc := range staged {
+		s.docs[name] = doc
+		s.versions[name]++
+	}
+}
+
+func checkPrecondition(name string, existing *pb.Document, pre *pb.Precondition) error {
+	if pre == nil {
+		return nil
+	}
+	switch c := pre.ConditionType.(type) {
+	case *pb.Precondition_Exists:
+		if c.Exists && existing == nil {
+			return status.Errorf(codes.NotFound, "no document to update: %s", name)
+		}
+		if !c.Exists && existing != nil {
+			return status.Errorf(codes.AlreadyExists, "document already exists: %s", name)
+		}
+	case *pb.Precondition_UpdateTime:
+		if existing == nil || !existing.UpdateTime.AsTime().Equal(c.UpdateTime.AsTime()) {
+			return status.Errorf(codes.FailedPrecondition, "%s was updated since the given time", name)
+		}
+	}
+	return nil
+}
+
+// now returns a microsecond timestamp later than any returned before, so
+// every write has a distinct update time
+func (s *Server) now() time.Time {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if !now.After(s.lastTime) {
+		now = s.lastTime.Add(time.Microsecond)
+	}
+	s.lastTime = now
+	return now
+}
@@ -0,0 +1,260 @@
+package firestoretest
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestClient(t *testing.T) *firestore.Client {
+	t.Helper()
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s.Client()
+}
+
+func ids(t *testing.T, q firestore.Query) []string {
+	t.Helper()
+	docs, err := q.Documents(context.Background()).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []string{}
+	for _, doc := range docs {
+		result = append(result, doc.Ref.ID)
+	}
+	return result
+}
+
+func TestDocuments(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	ref := client.Collection("users").Doc("a")
+
+	if _, err := ref.Get(ctx); status.Code(err) != codes.NotFound {
+		t.Fatalf("Get missing = %v, want NotFound", err)
+	}
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "name", Value: "A"}}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Update missing = %v, want NotFound", err)
+	}
+
+	if _, err := ref.Create(ctx, map[string]interface{}{"name": "A", "counts": map[string]interface{}{"go": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Create(ctx, map[string]interface{}{"name": "B"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Create existing = %v, want AlreadyExists", err)
+	}
+
+	_, err := ref.Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"counts", "go"}, Value: firestore.Increment(2)},
+		{FieldPath: firestore.FieldPath{"counts", "c.d"}, Value: firestore.Increment(1)},
+		{Path: "seen", Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Set(ctx, map[string]interface{}{"bio": "hi"}, firestore.MergeAll); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := doc.Data()
+	counts := data["counts"].(map[string]interface{})
+	if data["name"] != "A" || data["bio"] != "hi" || counts["go"] != int64(3) || counts["c.d"] != int64(1) {
+		t.Errorf("data = %v", data)
+	}
+	if seen, ok := data["seen"].(time.Time); !ok || seen.IsZero() {
+		t.Errorf("seen = %v, want the server time", data["seen"])
+	}
+
+	if _, err := ref.Set(ctx, map[string]interface{}{"name": "C"}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.Data(), map[string]interface{}{"name": "C"}) {
+		t.Errorf("data after Set = %v, want it replaced", doc.Data())
+	}
+
+	if _, err := ref.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Get(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("Get deleted = %v, want NotFound", err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	posts := client.Collection("posts")
+	base := time.Now()
+	for i, p := range []struct {
+		id     string
+		author string
+		tags   []string
+	}{
+		{"p1", "a", []string{"go"}},
+		{"p2", "b", []string{"rust"}},
+		{"p3", "a", []string{"go", "db"}},
+		{"p4", "c", nil},
+	} {
+		_, err := posts.Doc(p.id).Set(ctx, map[string]interface{}{
+			"author": p.author, "tags": p.tags, "rank": i, "createdAt": base.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Collection("users").Doc("a").Collection("posts").Doc("p5").Set(ctx, map[string]interface{}{"author": "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query firestore.Query
+		want  []string
+	}{
+		{"equal", posts.Where("author", "==", "a"), []string{"p1", "p3"}},
+		{"in", posts.Where("author", "in", []string{"b", "c"}), []string{"p2", "p4"}},
+		{"array contains", posts.Where("tags", "array-contains", "go"), []string{"p1", "p3"}},
+		{"range orders by the field", posts.Where("rank", ">=", 1), []string{"p2", "p3", "p4"}},
+		{"range of another type", posts.Where("rank", ">", "0"), []string{}},
+		{"order and limit", posts.OrderBy("createdAt", firestore.Desc).Limit(2), []string{"p4", "p3"}},
+		{"start after", posts.OrderBy("createdAt", firestore.Desc).StartAfter(base.Add(2 * time.Minute)), []string{"p2", "p1"}},
+		{"document ID", posts.Where(firestore.DocumentID, "in", []*firestore.DocumentRef{posts.Doc("p2"), posts.Doc("p9")}), []string{"p2"}},
+		{"combined", posts.Where("author", "==", "a").Where("rank", "<", 2), []string{"p1"}},
+		{"collection group", client.CollectionGroup("posts").Where("author", "==", "a"), []string{"p1", "p3", "p5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(t, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsRetryOnConflict(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	ref := client.Collection("counters").Doc("c")
+	if _, err := ref.Set(ctx, map[string]interface{}{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+				doc, err := tx.Get(ref)
+				if err != nil {
+					return err
+				}
+				n, _ := doc.Data()["n"].(int64)
+				return tx.Set(ref, map[string]interface{}{"n": n + 1})
+			}, firestore.MaxAttempts(workers+1))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := doc.Data()["n"]; n != int64(workers) {
+		t.Errorf("n = %v, want %d", n, workers)
+	}
+}
+
+func TestTransactionSeesQueryConflicts(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	users := client.Collection("users")
+
+	attempts := 0
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		attempts++
+		docs, err := tx.Documents(users.Where("username", "==", "alice")).GetAll()
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// Another writer claims the username after the query
+			if _, err := users.Doc("b").Set(ctx, map[string]interface{}{"username": "alice"}); err != nil {
+				return err
+			}
+		}
+		if len(docs) > 0 {
+			return nil
+		}
+		return tx.Set(users.Doc("a"), map[string]interface{}{"username": "alice"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want a retry after the conflicting write", attempts)
+	}
+	if _, err := users.Doc("a").Get(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("user a written despite the conflict: %v", err)
+	}
+}
+
+func TestBulkWriter(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	bw := client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, id := range []string{"a", "b"} {
+		job, err := bw.Create(client.Collection("items").Doc(id), map[string]interface{}{"id": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iter := client.Collection("items").Documents(ctx)
+	defer iter.Stop()
+	n := 0
+	for {
+		_, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("%d items, want 2", n)
+	}
+}
@@ -0,0 +1,343 @@
+package firestoretest
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// parseFieldPath splits a field path such as a.`b.c`.d into its segments
+func parseFieldPath(path string) ([]string, error) {
+	var segments []string
+	for len(path) > 0 {
+		var segment string
+		if path[0] == '`' {
+			var b strings.Builder
+			i := 1
+			for ; i < len(path) && path[i] != '`'; i++ {
+				if path[i] == '\\' && i+1 < len(path) {
+					i++
+				}
+				b.WriteByte(path[i])
+			}
+			if i >= len(path) {
+				return nil, status.Errorf(codes.InvalidArgument, "unterminated field path %q", path)
+			}
+			segment, path = b.String(), path[i+1:]
+		} else {
+			end := strings.IndexByte(path, '.')
+			if end < 0 {
+				end = len(path)
+			}
+			segment, path = path[:end], path[end:]
+		}
+		segments = append(segments, segment)
+
+		if len(path) > 0 {
+			if path[0] != '.' {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid field path %q", path)
+			}
+			path = path[1:]
+		}
+	}
+	if len(segments) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty field path")
+	}
+	return segments, nil
+}
+
+func getField(fields map[string]*pb.Value, segments []string) (*pb.Value, bool) {
+	v, ok := fields[segments[0]]
+	if !ok || len(segments) == 1 {
+		return v, ok
+	}
+	m := v.GetMapValue()
+	if m == nil {
+		return nil, false
+	}
+	return getField(m.Fields, segments[1:])
+}
+
+// setField sets the value at segments, replacing anything in the way with maps
+func setField(fields map[string]*pb.Value, segments []string, v *pb.Value) {
+	if len(segments) == 1 {
+		fields[segments[0]] = v
+		return
+	}
+	m := fields[segments[0]].GetMapValue()
+	if m == nil {
+		m = &pb.MapValue{}
+		fields[segments[0]] = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: m}}
+	}
+	if m.Fields == nil {
+		m.Fields = map[string]*pb.Value{}
+	}
+	setField(m.Fields, segments[1:], v)
+}
+
+func deleteField(fields map[string]*pb.Value, segments []string) {
+	if len(segments) == 1 {
+		delete(fields, segments[0])
+		return
+	}
+	if m := fields[segments[0]].GetMapValue(); m != nil {
+		deleteField(m.Fields, segments[1:])
+	}
+}
+
+func cloneFields(fields map[string]*pb.Value) map[string]*pb.Value {
+	clone := make(map[string]*pb.Value, len(fields))
+	for k, v := range fields {
+		clone[k] = proto.Clone(v).(*pb.Value)
+	}
+	return clone
+}
+
+// applyTransform updates fields with t and returns the transformed value
+func applyTransform(fields map[string]*pb.Value, t *pb.DocumentTransform_FieldTransform, now time.Time) (*pb.Value, error) {
+	segments, err := parseFieldPath(t.FieldPath)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := getField(fields, segments)
+
+	var result *pb.Value
+	switch tt := t.TransformType.(type) {
+	case *pb.DocumentTransform_FieldTransform_SetToServerValue:
+		result = &pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: timestamppb.New(now)}}
+	case *pb.DocumentTransform_FieldTransform_Increment:
+		result = numericTransform(current, tt.Increment, func(a, b float64) float64 { return a + b }, func(a, b int64) int64 { return a + b })
+	case *pb.DocumentTransform_FieldTransform_Maximum:
+		result = numericTransform(current, tt.Maximum, math.Max, func(a, b int64) int64 {
+			if a > b {
+				return a
+			}
+			return b
+		})
+	case *pb.DocumentTransform_FieldTransform_Minimum:
+		result = numericTransform(current, tt.Minimum, math.Min, func(a, b int64) int64 {
+			if a < b {
+				return a
+			}
+			return b
+		})
+	case *pb.DocumentTransform_FieldTransform_AppendMissingElements:
+		var values []*pb.Value
+		if arr := current.GetArrayValue(); arr != nil {
+			values = append(values, arr.Values...)
+		}
+		for _, v := range tt.AppendMissingElements.Values {
+			if !containsValue(values, v) {
+				values = append(values, v)
+			}
+		}
+		result = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}
+	case *pb.DocumentTransform_FieldTransform_RemoveAllFromArray:
+		var values []*pb.Value
+		if arr := current.GetArrayValue(); arr != nil {
+			for _, v := range arr.Values {
+				if !containsValue(tt.RemoveAllFromArray.Values, v) {
+					values = append(values, v)
+				}
+			}
+		}
+		result = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}
+	default:
+		return nil, status.Error(codes.Unimplemented, "unsupported field transform")
+	}
+
+	setField(fields, segments, result)
+	return result, nil
+}
+
+// numericTransform combines current and operand, treating a current value
+// that is not a number as zero. Integers stay integers unless either side
+// is a double.
+func numericTransform(current, operand *pb.Value, double func(a, b float64) float64, integer func(a, b int64) int64) *pb.Value {
+	if !isNumber(current) {
+		current = &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: 0}}
+	}
+	_, currentInt := current.ValueType.(*pb.Value_IntegerValue)
+	_, operandInt := operand.ValueType.(*pb.Value_IntegerValue)
+	if currentInt && operandInt {
+		return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: integer(current.GetIntegerValue(), operand.GetIntegerValue())}}
+	}
+	return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: double(toFloat(current), toFloat(operand))}}
+}
+
+func isNumber(v *pb.Value) bool {
+	switch v.GetValueType().(type) {
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return true
+	}
+	return false
+}
+
+func toFloat(v *pb.Value) float64 {
+	if i, ok := v.ValueType.(*pb.Value_IntegerValue); ok {
+		return float64(i.IntegerValue)
+	}
+	return v.GetDoubleValue()
+}
+
+func containsValue(values []*pb.Value, v *pb.Value) bool {
+	for _, candidate := range values {
+		if compareValues(candidate, v) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOrder ranks value types the way Firestore orders mixed types
+func typeOrder(v *pb.Value) int {
+	switch v.GetValueType().(type) {
+	case *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BytesValue:
+		return 5
+	case *pb.Value_ReferenceValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	case *pb.Value_MapValue:
+		return 9
+	}
+	return 0
+}
+
+// compareValues orders a and b as Firestore does
+func compareValues(a, b *pb.Value) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return compareInts(int64(ta), int64(tb))
+	}
+
+	switch av := a.GetValueType().(type) {
+	case *pb.Value_BooleanValue:
+		x, y := av.BooleanValue, b.GetBooleanValue()
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case *pb.Value_IntegerValue:
+		if bv, ok := b.ValueType.(*pb.Value_IntegerValue); ok {
+			return compareInts(av.IntegerValue, bv.IntegerValue)
+		}
+		return compareFloats(float64(av.IntegerValue), toFloat(b))
+	case *pb.Value_DoubleValue:
+		return compareFloats(av.DoubleValue, toFloat(b))
+	case *pb.Value_TimestampValue:
+		x, y := av.TimestampValue.AsTime(), b.GetTimestampValue().AsTime()
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	case *pb.Value_StringValue:
+		return strings.Compare(av.StringValue, b.GetStringValue())
+	case *pb.Value_BytesValue:
+		return bytes.Compare(av.BytesValue, b.GetBytesValue())
+	case *pb.Value_ReferenceValue:
+		return compareReferences(av.ReferenceValue, b.GetReferenceValue())
+	case *pb.Value_GeoPointValue:
+		x, y := av.GeoPointValue, b.GetGeoPointValue()
+		if c := compareFloats(x.GetLatitude(), y.GetLatitude()); c != 0 {
+			return c
+		}
+		return compareFloats(x.GetLongitude(), y.GetLongitude())
+	case *pb.Value_ArrayValue:
+		x, y := av.ArrayValue.GetValues(), b.GetArrayValue().GetValues()
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case *pb.Value_MapValue:
+		return compareMaps(av.MapValue.GetFields(), b.GetMapValue().GetFields())
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFloats orders NaN before every other number
+func compareFloats(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareReferences orders document names segment by segment
+func compareReferences(a, b string) int {
+	x, y := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := strings.Compare(x[i], y[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(int64(len(x)), int64(len(y)))
+}
+
+func compareMaps(a, b map[string]*pb.Value) int {
+	keysA, keysB := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(keysA) && i < len(keysB); i++ {
+		if c := strings.Compare(keysA[i], keysB[i]); c != 0 {
+			return c
+		}
+		if c := compareValues(a[keysA[i]], b[keysB[i]]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(int64(len(keysA)), int64(len(keysB)))
+}
+
+func sortedKeys(m map[string]*pb.Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
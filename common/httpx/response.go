@@ -2,7 +2,10 @@ package httpx
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ErrorResponse represents a standard error response
@@ -10,12 +13,19 @@ type ErrorResponse struct {
 	Error ErrorDetail `json:"error"`
 }
 
-// ErrorDetail contains error code and message
+// ErrorDetail contains error code and message, plus per-field errors for validation failures
 type ErrorDetail struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
 }
 
+// Authentication error codes
+const (
+	CodeAccountDisabled = "account_disabled"
+	CodeTokenRevoked    = "token_revoked"
+)
+
 // WriteJSON writes a JSON response
 func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -53,6 +63,30 @@ func NotFound(w http.ResponseWriter, message string) {
 	WriteError(w, http.StatusNotFound, "not_found", message)
 }
 
+// Forbidden writes a 403 error
+func Forbidden(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusForbidden, "forbidden", message)
+}
+
+// Conflict writes a 409 error
+func Conflict(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusConflict, "conflict", message)
+}
+
+// TooManyRequests writes a 429 error with a Retry-After header
+func TooManyRequests(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	WriteError(w, http.StatusTooManyRequests, "rate_limited", message)
+}
+
+// ServiceUnavailable writes a 503 error, with a Retry-After header when retryAfter is set
+func ServiceUnavailable(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		setRetryAfter(w, retryAfter)
+	}
+	WriteError(w, http.StatusServiceUnavailable, "service_unavailable", message)
+}
+
 // Success writes a 200 success response
 func Success(w http.ResponseWriter, data interface{}) {
 	WriteJSON(w, http.StatusOK, data)
@@ -62,3 +96,17 @@ func Success(w http.ResponseWriter, data interface{}) {
 func Created(w http.ResponseWriter, data interface{}) {
 	WriteJSON(w, http.StatusCreated, data)
 }
+
+// Accepted writes a 202 accepted response for work that completes later
+func Accepted(w http.ResponseWriter, data interface{}) {
+	WriteJSON(w, http.StatusAccepted, data)
+}
+
+// setRetryAfter sets Retry-After in whole seconds, at least one
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,150 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Validation error codes
+const (
+	CodeRequired      = "required"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeTooMany       = "too_many"
+	CodeInvalidFormat = "invalid_format"
+	CodeInvalidChoice = "invalid_choice"
+	CodeInvalidURL    = "invalid_url"
+	CodeInvalidValue  = "invalid_value"
+	CodeTooYoung      = "too_young"
+)
+
+// FieldError describes a validation failure on a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Validator collects field errors for a request body
+type Validator struct {
+	errors []FieldError
+}
+
+// NewValidator creates an empty validator
+func NewValidator() *Validator {
+	return &Validator{}
+}
+
+// Add records a field error
+func (v *Validator) Add(field, code, message string) {
+	v.errors = append(v.errors, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Check records a field error when ok is false and reports ok
+func (v *Validator) Check(ok bool, field, code, message string) bool {
+	if !ok {
+		v.Add(field, code, message)
+	}
+	return ok
+}
+
+// Required checks that value is not blank
+func (v *Validator) Required(field, value string) bool {
+	return v.Check(strings.TrimSpace(value) != "", field, CodeRequired,
+		fmt.Sprintf("%s is required", field))
+}
+
+// MinLength checks that value has at least min characters
+func (v *Validator) MinLength(field, value string, min int) bool {
+	return v.Check(utf8.RuneCountInString(value) >= min, field, CodeTooShort,
+		fmt.Sprintf("%s must be at least %d characters", field, min))
+}
+
+// MaxLength checks that value has at most max characters
+func (v *Validator) MaxLength(field, value string, max int) bool {
+	return v.Check(utf8.RuneCountInString(value) <= max, field, CodeTooLong,
+		fmt.Sprintf("%s must be at most %d characters", field, max))
+}
+
+// MaxItems checks that a list field has at most max entries
+func (v *Validator) MaxItems(field string, count, max int) bool {
+	return v.Check(count <= max, field, CodeTooMany,
+		fmt.Sprintf("%s must have at most %d items", field, max))
+}
+
+// OneOf checks that value is one of allowed
+func (v *Validator) OneOf(field, value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	v.Add(field, CodeInvalidChoice,
+		fmt.Sprintf("%s must be one of: %s", field, strings.Join(allowed, ", ")))
+	return false
+}
+
+// URL checks that value is an absolute URL using one of the allowed schemes
+func (v *Validator) URL(field, value string, schemes ...string) bool {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		v.Add(field, CodeInvalidURL, fmt.Sprintf("%s must be a valid URL", field))
+		return false
+	}
+
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	v.Add(field, CodeInvalidURL,
+		fmt.Sprintf("%s must use one of the schemes: %s", field, strings.Join(schemes, ", ")))
+	return false
+}
+
+// Date parses value with layout, recording an error if it does not match
+func (v *Validator) Date(field, value, layout string) (time.Time, bool) {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		v.Add(field, CodeInvalidFormat,
+			fmt.Sprintf("%s must be a date in the format %s", field, layout))
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// MinAge checks that birthday is at least years ago and not in the future
+func (v *Validator) MinAge(field string, birthday time.Time, years int) bool {
+	now := time.Now()
+	if birthday.After(now) {
+		v.Add(field, CodeInvalidValue, fmt.Sprintf("%s cannot be in the future", field))
+		return false
+	}
+	return v.Check(!birthday.AddDate(years, 0, 0).After(now), field, CodeTooYoung,
+		fmt.Sprintf("You must be at least %d years old", years))
+}
+
+// Valid reports whether no errors were recorded
+func (v *Validator) Valid() bool {
+	return len(v.errors) == 0
+}
+
+// Errors returns the recorded field errors
+func (v *Validator) Errors() []FieldError {
+	return v.errors
+}
+
+// ValidationFailed writes a 400 error listing the invalid fields
+func ValidationFailed(w http.ResponseWriter, errors []FieldError) {
+	WriteJSON(w, http.StatusBadRequest, ErrorResponse{
+		Error: ErrorDetail{
+			Code:    "validation_failed",
+			Message: "Request validation failed",
+			Details: errors,
+		},
+	})
+}
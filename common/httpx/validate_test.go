@@ -0,0 +1,125 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidatorChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		check    func(v *Validator) bool
+		wantOK   bool
+		wantCode string
+	}{
+		{"required", func(v *Validator) bool { return v.Required("name", "Ada") }, true, ""},
+		{"required blank", func(v *Validator) bool { return v.Required("name", "  \t") }, false, CodeRequired},
+		{"min length", func(v *Validator) bool { return v.MinLength("name", "abc", 3) }, true, ""},
+		{"min length short", func(v *Validator) bool { return v.MinLength("name", "ab", 3) }, false, CodeTooShort},
+		{"max length counts runes", func(v *Validator) bool { return v.MaxLength("name", "héllo", 5) }, true, ""},
+		{"max length long", func(v *Validator) bool { return v.MaxLength("name", "hello!", 5) }, false, CodeTooLong},
+		{"max items", func(v *Validator) bool { return v.MaxItems("tags", 3, 3) }, true, ""},
+		{"max items too many", func(v *Validator) bool { return v.MaxItems("tags", 4, 3) }, false, CodeTooMany},
+		{"one of", func(v *Validator) bool { return v.OneOf("visibility", "public", "public", "private") }, true, ""},
+		{"one of other", func(v *Validator) bool { return v.OneOf("visibility", "secret", "public", "private") }, false, CodeInvalidChoice},
+		{"url", func(v *Validator) bool { return v.URL("photoUrl", "https://example.com/a.png", "https") }, true, ""},
+		{"url scheme case", func(v *Validator) bool { return v.URL("photoUrl", "HTTPS://example.com", "https") }, true, ""},
+		{"url wrong scheme", func(v *Validator) bool { return v.URL("photoUrl", "javascript://example.com", "https") }, false, CodeInvalidURL},
+		{"url relative", func(v *Validator) bool { return v.URL("photoUrl", "/a.png", "https") }, false, CodeInvalidURL},
+		{"check", func(v *Validator) bool { return v.Check(true, "x", CodeInvalidValue, "bad") }, true, ""},
+		{"check failed", func(v *Validator) bool { return v.Check(false, "x", CodeInvalidValue, "bad") }, false, CodeInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator()
+			if ok := tt.check(v); ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if v.Valid() != tt.wantOK {
+				t.Fatalf("Valid() = %v, want %v", v.Valid(), tt.wantOK)
+			}
+			if tt.wantOK {
+				return
+			}
+			if errs := v.Errors(); len(errs) != 1 || errs[0].Code != tt.wantCode {
+				t.Fatalf("errors = %+v, want one %s", errs, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidatorDate(t *testing.T) {
+	v := NewValidator()
+	if d, ok := v.Date("birthday", "1990-04-01", "2006-01-02"); !ok || d.Year() != 1990 {
+		t.Fatalf("Date = %v, %v", d, ok)
+	}
+	if _, ok := v.Date("birthday", "01/04/1990", "2006-01-02"); ok {
+		t.Fatal("Date accepted the wrong layout")
+	}
+	if errs := v.Errors(); len(errs) != 1 || errs[0].Code != CodeInvalidFormat {
+		t.Fatalf("errors = %+v", errs)
+	}
+}
+
+func TestValidatorMinAge(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		birthday time.Time
+		wantCode string
+	}{
+		{"old enough", now.AddDate(-20, 0, 0), ""},
+		{"just old enough", now.AddDate(-13, 0, -1), ""},
+		{"too young", now.AddDate(-12, 0, 0), CodeTooYoung},
+		{"future", now.AddDate(0, 0, 1), CodeInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator()
+			ok := v.MinAge("birthday", tt.birthday, 13)
+			if ok != (tt.wantCode == "") {
+				t.Fatalf("ok = %v, errors = %+v", ok, v.Errors())
+			}
+			if !ok && v.Errors()[0].Code != tt.wantCode {
+				t.Fatalf("code = %s, want %s", v.Errors()[0].Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidatorCollectsEveryField(t *testing.T) {
+	v := NewValidator()
+	v.Required("displayName", "")
+	v.MaxLength("bio", "too long", 3)
+	v.Required("username", "ada")
+
+	errs := v.Errors()
+	if len(errs) != 2 || errs[0].Field != "displayName" || errs[1].Field != "bio" {
+		t.Fatalf("errors = %+v", errs)
+	}
+}
+
+func TestValidationFailed(t *testing.T) {
+	v := NewValidator()
+	v.Required("displayName", "")
+
+	w := httptest.NewRecorder()
+	ValidationFailed(w, v.Errors())
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != "validation_failed" || len(body.Error.Details) != 1 ||
+		body.Error.Details[0].Field != "displayName" || body.Error.Details[0].Code != CodeRequired {
+		t.Fatalf("body = %+v", body)
+	}
+}
@@ -3,6 +3,7 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -59,8 +60,16 @@ func Connect(url string) (*Connection, error) {
 	}, nil
 }
 
-// Publish publishes a message to the exchange with a routing key
+// ErrNotConnected is returned when publishing without a connection
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+// Publish publishes a message to the exchange with a routing key. Publishing
+// on a nil Connection returns ErrNotConnected.
 func (c *Connection) Publish(ctx context.Context, routingKey string, payload interface{}) error {
+	if c == nil {
+		return ErrNotConnected
+	}
+
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
@@ -95,18 +104,28 @@ type ConsumeOptions struct {
 	QueueName   string
 	RoutingKeys []string
 	Handler     func([]byte) error
+
+	// PerInstance consumes from a server-named queue that only this
+	// connection uses and that is deleted when it closes, so every instance
+	// of a service receives every message. Use it to keep in-process state
+	// such as caches in sync. QueueName must be empty.
+	PerInstance bool
 }
 
 // Consume sets up a consumer for the given queue and routing keys
 func (c *Connection) Consume(ctx context.Context, opts ConsumeOptions) error {
+	if opts.PerInstance && opts.QueueName != "" {
+		return fmt.Errorf("per-instance queues are server-named, got queue name %q", opts.QueueName)
+	}
+
 	// Declare queue
 	queue, err := c.channel.QueueDeclare(
-		opts.QueueName, // name
-		true,           // durable
-		false,          // delete when unused
-		false,          // exclusive
-		false,          // no-wait
-		nil,            // arguments
+		opts.QueueName,    // name
+		!opts.PerInstance, // durable
+		opts.PerInstance,  // delete when unused
+		opts.PerInstance,  // exclusive
+		false,             // no-wait
+		nil,               // arguments
 	)
 	if err != nil {
 		return fmt.Errorf("failed to declare queue: %w", err)
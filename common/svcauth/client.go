@@ -0,0 +1,28 @@
+package svcauth
+
+import (
+	"net/http"
+	"time"
+)
+
+// transport signs every request before sending it
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	r = r.Clone(r.Context())
+	if err := Sign(r); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(r)
+}
+
+// NewClient returns an HTTP client that signs requests as this service
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &transport{base: http.DefaultTransport},
+	}
+}
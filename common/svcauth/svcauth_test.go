@@ -0,0 +1,232 @@
+package svcauth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// useKeys makes this process sign as name with the given service keys
+func useKeys(t *testing.T, name string, serviceKeys map[string]string) {
+	t.Helper()
+	oldName, oldKeys := serviceName, keys
+	t.Cleanup(func() { serviceName, keys = oldName, oldKeys })
+
+	serviceName = name
+	keys = map[string][]byte{}
+	for svc, key := range serviceKeys {
+		keys[svc] = []byte(key)
+	}
+}
+
+func signedRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if err := Sign(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	useKeys(t, "feed", map[string]string{"feed": "feed-key", "profile": "profile-key"})
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		wantErr error
+	}{
+		{
+			name:    "signed",
+			request: func() *http.Request { return signedRequest(t, http.MethodPost, "/internal/users?x=1", `{"a":1}`) },
+		},
+		{
+			name: "no body",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/internal/users", nil)
+				if err := Sign(r); err != nil {
+					t.Fatal(err)
+				}
+				return r
+			},
+		},
+		{
+			name: "missing headers",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/internal/users", nil)
+			},
+			wantErr: errMissingHeaders,
+		},
+		{
+			name: "unknown service",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Header.Set(ServiceHeader, "mallory")
+				return r
+			},
+			wantErr: errUnknownService,
+		},
+		{
+			name: "claims to be another service",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Header.Set(ServiceHeader, "profile")
+				return r
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "body changed",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodPost, "/internal/users", `{"a":1}`)
+				r.Body = io.NopCloser(strings.NewReader(`{"a":2}`))
+				return r
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "method changed",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Method = http.MethodDelete
+				return r
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "query changed",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users?uid=a", "")
+				r.URL.RawQuery = "uid=b"
+				return r
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "timestamp changed",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix()-1, 10))
+				return r
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "timestamp not a number",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Header.Set(TimestampHeader, "yesterday")
+				return r
+			},
+			wantErr: errBadTimestamp,
+		},
+		{
+			name: "replayed too late",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Header.Set(TimestampHeader, strconv.FormatInt(now.Add(-2*maxSignatureAge).Unix(), 10))
+				return r
+			},
+			wantErr: errBadTimestamp,
+		},
+		{
+			name: "from the future",
+			request: func() *http.Request {
+				r := signedRequest(t, http.MethodGet, "/internal/users", "")
+				r.Header.Set(TimestampHeader, strconv.FormatInt(now.Add(2*maxSignatureAge).Unix(), 10))
+				return r
+			},
+			wantErr: errBadTimestamp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller, err := verify(tt.request(), now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && caller != "feed" {
+				t.Errorf("caller = %q, want feed", caller)
+			}
+		})
+	}
+}
+
+func TestVerifyLeavesBodyReadable(t *testing.T) {
+	useKeys(t, "feed", map[string]string{"feed": "feed-key"})
+
+	r := signedRequest(t, http.MethodPost, "/internal/users", "payload")
+	if _, err := verify(r, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil || string(body) != "payload" {
+		t.Errorf("body = %q, %v, want payload", body, err)
+	}
+}
+
+func TestSignWithoutKey(t *testing.T) {
+	useKeys(t, "feed", map[string]string{"profile": "profile-key"})
+
+	if Enabled() {
+		t.Error("Enabled without a key for this service")
+	}
+	if err := Sign(httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		t.Error("Sign succeeded without a key")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	useKeys(t, "feed", map[string]string{"feed": "feed-key", "profile": "profile-key"})
+
+	tests := []struct {
+		name       string
+		allowed    []string
+		signed     bool
+		wantStatus int
+	}{
+		{"any known service", nil, true, http.StatusOK},
+		{"allowed service", []string{"profile", "feed"}, true, http.StatusOK},
+		{"service not allowed", []string{"profile"}, true, http.StatusForbidden},
+		{"unsigned", nil, false, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotService string
+			handler := Middleware(tt.allowed...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotService, _ = GetService(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/internal/users", nil)
+			if tt.signed {
+				r = signedRequest(t, http.MethodGet, "/internal/users", "")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && gotService != "feed" {
+				t.Errorf("service = %q, want feed", gotService)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountDeletedEvent from profile service
+type AccountDeletedEvent struct {
+	UID         string    `json:"uid"`
+	RequestedAt time.Time `json:"requestedAt"`
+	DeletedAt   time.Time `json:"deletedAt"`
+}
+
+// AccountPurgedEvent is published to RabbitMQ once this service has deleted a user's data
+type AccountPurgedEvent struct {
+	UID         string    `json:"uid"`
+	Service     string    `json:"service"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// startAccountPurging deletes a user's relationships, endorsements and trust
+// score on account.deleted
+func startAccountPurging(ctx context.Context, conn *rabbitmq.Connection) error {
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		QueueName:   "connections-service.account-deletions",
+		RoutingKeys: []string{"account.deleted"},
+		Handler: func(body []byte) error {
+			var event AccountDeletedEvent
+			if err := json.Unmarshal(body, &event); err != nil || event.UID == "" {
+				log.Error("Failed to parse account.deleted event", zap.Error(err))
+				return nil
+			}
+			if err := purgeAccountData(ctx, event.UID); err != nil {
+				return err
+			}
+			return conn.Publish(ctx, "account.purged", AccountPurgedEvent{
+				UID:         event.UID,
+				Service:     "connections",
+				CompletedAt: time.Now(),
+			})
+		},
+	})
+}
+
+func purgeAccountData(ctx context.Context, uid string) error {
+	client := firestoredb.GetClient()
+
+	// Remember who uid was related to so their caches and scores can be refreshed
+	others := map[string]struct{}{}
+	for _, field := range []string{"fromUid", "toUid"} {
+		q := client.Collection("relationships").Where(field, "==", uid)
+
+		iter := q.Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return fmt.Errorf("failed to iterate relationships: %w", err)
+			}
+			var rel Relationship
+			if err := doc.DataTo(&rel); err == nil {
+				others[otherParty(rel, uid)] = struct{}{}
+			}
+		}
+		iter.Stop()
+
+		if _, err := firestoredb.DeleteDocuments(ctx, q); err != nil {
+			return fmt.Errorf("failed to delete relationships: %w", err)
+		}
+	}
+
+	given, err := revokeEndorsementsFrom(ctx, uid)
+	if err != nil {
+		return err
+	}
+	received, err := firestoredb.DeleteDocuments(ctx, client.Collection("endorsements").Where("toUid", "==", uid))
+	if err != nil {
+		return fmt.Errorf("failed to delete received endorsements: %w", err)
+	}
+
+	if _, err := client.Collection("trustScores").Doc(uid).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete trust score: %w", err)
+	}
+
+	otherUIDs := make([]string, 0, len(others))
+	for other := range others {
+		otherUIDs = append(otherUIDs, other)
+	}
+	invalidateGraphCache(append(otherUIDs, uid)...)
+	recommendationCache.Delete(uid)
+	for _, other := range otherUIDs {
+		recommendations.enqueue(other)
+	}
+
+	trust.removeUser(ctx, uid)
+
+	log.Info("Connections data purged",
+		zap.String("uid", uid),
+		zap.Int("relationships", len(others)),
+		zap.Int("endorsementsGiven", given),
+		zap.Int("endorsementsReceived", len(received)))
+	return nil
+}
+
+// revokeEndorsementsFrom deletes every endorsement uid gave, decrementing the
+// recipients' counts, and returns how many were revoked
+func revokeEndorsementsFrom(ctx context.Context, uid string) (int, error) {
+	client := firestoredb.GetClient()
+	iter := client.Collection("endorsements").Where("fromUid", "==", uid).Documents(ctx)
+	defer iter.Stop()
+
+	revoked := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return revoked, nil
+		}
+		if err != nil {
+			return revoked, fmt.Errorf("failed to iterate endorsements: %w", err)
+		}
+
+		var endorsement Endorsement
+		if err := doc.DataTo(&endorsement); err != nil {
+			return revoked, fmt.Errorf("failed to parse endorsement %s: %w", doc.Ref.ID, err)
+		}
+
+		err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			userRef := client.Collection("users").Doc(endorsement.ToUID)
+			if _, err := tx.Get(doc.Ref); err != nil {
+				if status.Code(err) == codes.NotFound {
+					return nil
+				}
+				return err
+			}
+			_, userErr := tx.Get(userRef)
+			if userErr != nil && status.Code(userErr) != codes.NotFound {
+				return userErr
+			}
+
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+			if userErr != nil {
+				// Recipient is gone too
+				return nil
+			}
+			return tx.Update(userRef, []firestore.Update{
+				{FieldPath: firestore.FieldPath{"endorsementCounts", endorsement.Skill}, Value: firestore.Increment(-1)},
+			})
+		})
+		if err != nil {
+			return revoked, fmt.Errorf("failed to revoke endorsement %s: %w", doc.Ref.ID, err)
+		}
+		revoked++
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestPurgeAccountData(t *testing.T) {
+	ctx := context.Background()
+	client := endorsementUsers(t)
+	addRelationship(t, client, "b", "c", StatusAccepted)
+	for _, e := range []struct{ from, to, skill string }{
+		{"a", "b", "go"},
+		{"c", "b", "go"},
+		{"b", "a", "design"},
+	} {
+		if status, _ := endorse(t, e.from, e.to, e.skill); status != http.StatusCreated {
+			t.Fatalf("endorse %+v = %d", e, status)
+		}
+	}
+
+	old := trust
+	trust = newTestTrustGraph(nil)
+	t.Cleanup(func() { trust = old })
+	if err := trust.rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := purgeAccountData(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	rels, err := client.Collection("relationships").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rels) != 1 || rels[0].Ref.ID != createRelationshipID("b", "c") {
+		t.Errorf("%d relationships left, want only b-c", len(rels))
+	}
+
+	endorsements, err := client.Collection("endorsements").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endorsements) != 1 || endorsements[0].Data()["fromUid"] != "c" {
+		t.Errorf("%d endorsements left, want only c's", len(endorsements))
+	}
+	if got := endorsementCounts(t, client, "b")["go"]; got != int64(1) {
+		t.Errorf("b's go count = %v, want 1", got)
+	}
+
+	if _, err := client.Collection("trustScores").Doc("a").Get(ctx); err == nil {
+		t.Error("trust score of a not deleted")
+	}
+	if _, ok := trust.adj["a"]; ok {
+		t.Error("a still in the trust graph")
+	}
+	if _, ok := trust.adj["b"]["a"]; ok {
+		t.Error("edge b-a still in the trust graph")
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxSkillLength is the longest skill/attribute name that can be endorsed
+const maxSkillLength = 50
+
+var (
+	errEndorsementExists   = errors.New("endorsement already exists")
+	errEndorseeNotFound    = errors.New("endorsed user has no profile")
+	errEndorsementNotFound = errors.New("endorsement not found")
+	errNotEndorser         = errors.New("only the endorser can revoke an endorsement")
+)
+
+// Endorsement represents one user vouching for another on a skill or attribute
+type Endorsement struct {
+	ID        string    `firestore:"-" json:"id"`
+	FromUID   string    `firestore:"fromUid" json:"fromUid"`
+	ToUID     string    `firestore:"toUid" json:"toUid"`
+	Skill     string    `firestore:"skill" json:"skill"`
+	CreatedAt time.Time `firestore:"createdAt" json:"createdAt"`
+}
+
+// CreateEndorsementRequest represents the request body for endorsing a connection
+type CreateEndorsementRequest struct {
+	ToUID string `json:"toUid"`
+	Skill string `json:"skill"`
+}
+
+// EndorsementEvent is published to RabbitMQ when an endorsement is created
+type EndorsementEvent struct {
+	EndorsementID string    `json:"endorsementId"`
+	FromUID       string    `json:"fromUid"`
+	ToUID         string    `json:"toUid"`
+	Skill         string    `json:"skill"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func createEndorsement(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	var req CreateEndorsementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpx.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	skill := normalizeSkill(req.Skill)
+
+	v := httpx.NewValidator()
+	if v.Required("toUid", req.ToUID) && v.MaxLength("toUid", req.ToUID, maxUIDLength) {
+		v.Check(req.ToUID != uid, "toUid", httpx.CodeInvalidValue, "Cannot endorse yourself")
+	}
+	if v.Required("skill", skill) {
+		v.MaxLength("skill", skill, maxSkillLength)
+	}
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	ctx := r.Context()
+	client := firestoredb.GetClient()
+
+	connected, err := isConnected(ctx, uid, req.ToUID)
+	if err != nil {
+		log.Error("Failed to check relationship", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create endorsement")
+		return
+	}
+	if !connected {
+		httpx.Forbidden(w, "You can only endorse accepted connections")
+		return
+	}
+
+	now := time.Now()
+	endorsement := Endorsement{
+		ID:        createEndorsementID(uid, req.ToUID, skill),
+		FromUID:   uid,
+		ToUID:     req.ToUID,
+		Skill:     skill,
+		CreatedAt: now,
+	}
+
+	endorsementRef := client.Collection("endorsements").Doc(endorsement.ID)
+	userRef := client.Collection("users").Doc(req.ToUID)
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(endorsementRef); err == nil {
+			return errEndorsementExists
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if _, err := tx.Get(userRef); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errEndorseeNotFound
+			}
+			return err
+		}
+
+		if err := tx.Create(endorsementRef, endorsement); err != nil {
+			return err
+		}
+		return tx.Update(userRef, []firestore.Update{
+			{FieldPath: firestore.FieldPath{"endorsementCounts", skill}, Value: firestore.Increment(1)},
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errEndorsementExists):
+			httpx.Conflict(w, "You have already endorsed this user for this skill")
+		case errors.Is(err, errEndorseeNotFound):
+			httpx.NotFound(w, "User not found")
+		default:
+			log.Error("Failed to create endorsement", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to create endorsement")
+		}
+		return
+	}
+
+	log.Info("Endorsement created",
+		zap.String("fromUid", uid),
+		zap.String("toUid", req.ToUID),
+		zap.String("skill", skill))
+
+	// Publish event
+	event := EndorsementEvent{
+		EndorsementID: endorsement.ID,
+		FromUID:       uid,
+		ToUID:         req.ToUID,
+		Skill:         skill,
+		CreatedAt:     now,
+	}
+
+	if err := rabbitConn.Publish(ctx, "endorsement.created", event); err != nil {
+		log.Error("Failed to publish endorsement.created event", zap.Error(err))
+	}
+
+	httpx.Created(w, endorsement)
+}
+
+func revokeEndorsement(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	endorsementID := chi.URLParam(r, "id")
+	if endorsementID == "" {
+		httpx.BadRequest(w, "id is required")
+		return
+	}
+
+	ctx := r.Context()
+	client := firestoredb.GetClient()
+	endorsementRef := client.Collection("endorsements").Doc(endorsementID)
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(endorsementRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errEndorsementNotFound
+			}
+			return err
+		}
+
+		var endorsement Endorsement
+		if err := doc.DataTo(&endorsement); err != nil {
+			return err
+		}
+		if endorsement.FromUID != uid {
+			return errNotEndorser
+		}
+
+		if err := tx.Delete(endorsementRef); err != nil {
+			return err
+		}
+		return tx.Update(client.Collection("users").Doc(endorsement.ToUID), []firestore.Update{
+			{FieldPath: firestore.FieldPath{"endorsementCounts", endorsement.Skill}, Value: firestore.Increment(-1)},
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errEndorsementNotFound):
+			httpx.NotFound(w, "Endorsement not found")
+		case errors.Is(err, errNotEndorser):
+			httpx.Forbidden(w, "Only the endorser can revoke an endorsement")
+		default:
+			log.Error("Failed to revoke endorsement", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to revoke endorsement")
+		}
+		return
+	}
+
+	log.Info("Endorsement revoked",
+		zap.String("endorsementId", endorsementID),
+		zap.String("fromUid", uid))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getEndorsements(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	targetUID := r.URL.Query().Get("uid")
+	if targetUID == "" {
+		targetUID = uid
+	}
+
+	field := "toUid"
+	switch r.URL.Query().Get("direction") {
+	case "", "received":
+	case "given":
+		field = "fromUid"
+	default:
+		httpx.BadRequest(w, "direction must be 'received' or 'given'")
+		return
+	}
+
+	ctx := r.Context()
+	iter := firestoredb.GetClient().Collection("endorsements").
+		Where(field, "==", targetUID).
+		OrderBy("createdAt", firestore.Desc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	endorsements := []Endorsement{}
+	counts := map[string]int{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Error("Failed to iterate endorsements", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to fetch endorsements")
+			return
+		}
+
+		var endorsement Endorsement
+		if err := doc.DataTo(&endorsement); err != nil {
+			log.Error("Failed to parse endorsement", zap.Error(err))
+			continue
+		}
+
+		endorsement.ID = doc.Ref.ID
+		endorsements = append(endorsements, endorsement)
+		counts[endorsement.Skill]++
+	}
+
+	httpx.Success(w, map[string]interface{}{
+		"endorsements": endorsements,
+		"skills":       skillCounts(counts),
+		"count":        len(endorsements),
+	})
+}
+
+// SkillCount is the number of endorsements for one skill
+type SkillCount struct {
+	Skill string `json:"skill"`
+	Count int    `json:"count"`
+}
+
+// skillCounts orders per-skill counts by count descending, then skill name
+func skillCounts(counts map[string]int) []SkillCount {
+	result := make([]SkillCount, 0, len(counts))
+	for skill, count := range counts {
+		result = append(result, SkillCount{Skill: skill, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Skill < result[j].Skill
+	})
+	return result
+}
+
+// isConnected reports whether two users have an accepted relationship
+func isConnected(ctx context.Context, uid1, uid2 string) (bool, error) {
+	relationshipID := createRelationshipID(uid1, uid2)
+	doc, err := firestoredb.GetClient().Collection("relationships").Doc(relationshipID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	s, _ := doc.Data()["status"].(string)
+	return s == string(StatusAccepted), nil
+}
+
+// normalizeSkill lowercases a skill and collapses whitespace so equivalent skills share counts
+func normalizeSkill(skill string) string {
+	return strings.Join(strings.Fields(strings.ToLower(skill)), " ")
+}
+
+// createEndorsementID builds a deterministic ID so a user can endorse a skill
+// only once. The skill is hashed so that distinct skills never share an ID.
+func createEndorsementID(fromUID, toUID, skill string) string {
+	sum := sha256.Sum256([]byte(skill))
+	return fromUID + "_" + toUID + "_" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+// endorsementUsers gives a an accepted connection b, a pending request to c
+// and an accepted connection d without a profile
+func endorsementUsers(t *testing.T) *firestore.Client {
+	t.Helper()
+	client := useFirestore(t)
+	for _, uid := range []string{"a", "b", "c"} {
+		addUser(t, client, uid, nil)
+	}
+	addRelationship(t, client, "a", "b", StatusAccepted)
+	addRelationship(t, client, "a", "c", StatusRequested)
+	addRelationship(t, client, "d", "a", StatusAccepted)
+	return client
+}
+
+func endorse(t *testing.T, from, to, skill string) (int, Endorsement) {
+	t.Helper()
+	w := serve(t, "/endorsements", createEndorsement, from, http.MethodPost, "/endorsements",
+		CreateEndorsementRequest{ToUID: to, Skill: skill})
+	var endorsement Endorsement
+	if w.Code == http.StatusCreated {
+		decode(t, w, &endorsement)
+	}
+	return w.Code, endorsement
+}
+
+func endorsementCounts(t *testing.T, client *firestore.Client, uid string) map[string]interface{} {
+	t.Helper()
+	doc, err := client.Collection("users").Doc(uid).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, _ := doc.Data()["endorsementCounts"].(map[string]interface{})
+	return counts
+}
+
+func TestCreateEndorsement(t *testing.T) {
+	client := endorsementUsers(t)
+
+	tests := []struct {
+		name       string
+		to, skill  string
+		wantStatus int
+	}{
+		{"yourself", "a", "go", http.StatusBadRequest},
+		{"no skill", "b", "  ", http.StatusBadRequest},
+		{"skill too long", "b", strings.Repeat("a", maxSkillLength+1), http.StatusBadRequest},
+		{"skill with a slash", "b", "ci/cd", http.StatusCreated},
+		{"pending request", "c", "go", http.StatusForbidden},
+		{"stranger", "e", "go", http.StatusForbidden},
+		{"connection without a profile", "d", "go", http.StatusNotFound},
+		{"connection", "b", "  Go   Programming ", http.StatusCreated},
+		{"same skill again", "b", "go programming", http.StatusConflict},
+		{"another skill", "b", "databases", http.StatusCreated},
+		{"hyphenated skill", "b", "front-end", http.StatusCreated},
+		{"spaced skill with the same slug", "b", "front end", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := endorse(t, "a", tt.to, tt.skill); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+
+	want := map[string]interface{}{"ci/cd": int64(1), "go programming": int64(1), "databases": int64(1), "front-end": int64(1), "front end": int64(1)}
+	if got := endorsementCounts(t, client, "b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("endorsementCounts = %v, want %v", got, want)
+	}
+}
+
+func TestRevokeEndorsement(t *testing.T) {
+	client := endorsementUsers(t)
+	status, endorsement := endorse(t, "a", "b", "go")
+	if status != http.StatusCreated {
+		t.Fatalf("endorse status = %d", status)
+	}
+
+	revoke := func(uid, id string) int {
+		return serve(t, "/endorsements/{id}", revokeEndorsement, uid, http.MethodDelete, "/endorsements/"+id, nil).Code
+	}
+
+	if got := revoke("b", endorsement.ID); got != http.StatusForbidden {
+		t.Errorf("revoke by the endorsee = %d, want 403", got)
+	}
+	if got := revoke("c", endorsement.ID); got != http.StatusForbidden {
+		t.Errorf("revoke by another user = %d, want 403", got)
+	}
+	if got := revoke("a", "missing"); got != http.StatusNotFound {
+		t.Errorf("revoke missing = %d, want 404", got)
+	}
+	if got := revoke("a", endorsement.ID); got != http.StatusNoContent {
+		t.Fatalf("revoke by the endorser = %d, want 204", got)
+	}
+	if got := revoke("a", endorsement.ID); got != http.StatusNotFound {
+		t.Errorf("revoke twice = %d, want 404", got)
+	}
+
+	if got := endorsementCounts(t, client, "b")["go"]; got != int64(0) {
+		t.Errorf("go count after revoking = %v, want 0", got)
+	}
+	if status, _ := endorse(t, "a", "b", "go"); status != http.StatusCreated {
+		t.Errorf("endorse again after revoking = %d, want 201", status)
+	}
+}
+
+func TestGetEndorsements(t *testing.T) {
+	client := endorsementUsers(t)
+	addRelationship(t, client, "c", "b", StatusAccepted)
+	for _, e := range []struct{ from, to, skill string }{
+		{"a", "b", "go"},
+		{"c", "b", "go"},
+		{"c", "b", "sql"},
+		{"b", "a", "design"},
+	} {
+		if status, _ := endorse(t, e.from, e.to, e.skill); status != http.StatusCreated {
+			t.Fatalf("endorse %+v = %d", e, status)
+		}
+	}
+
+	var resp struct {
+		Endorsements []Endorsement `json:"endorsements"`
+		Skills       []SkillCount  `json:"skills"`
+		Count        int           `json:"count"`
+	}
+	w := serve(t, "/endorsements", getEndorsements, "a", http.MethodGet, "/endorsements?uid=b", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	decode(t, w, &resp)
+	wantSkills := []SkillCount{{Skill: "go", Count: 2}, {Skill: "sql", Count: 1}}
+	if resp.Count != 3 || !reflect.DeepEqual(resp.Skills, wantSkills) {
+		t.Errorf("received = %d endorsements, skills %+v, want 3 and %+v", resp.Count, resp.Skills, wantSkills)
+	}
+
+	w = serve(t, "/endorsements", getEndorsements, "b", http.MethodGet, "/endorsements?direction=given", nil)
+	decode(t, w, &resp)
+	if resp.Count != 1 || resp.Endorsements[0].ToUID != "a" || resp.Endorsements[0].Skill != "design" {
+		t.Errorf("given = %+v, want b's endorsement of a", resp.Endorsements)
+	}
+
+	if w := serve(t, "/endorsements", getEndorsements, "a", http.MethodGet, "/endorsements?direction=sideways", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bad direction: status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/cache"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// maxPathFanout caps how many first-degree connections are expanded per search
+	maxPathFanout = 200
+	// graphCacheTTL is how long neighbor sets and trust paths are cached
+	graphCacheTTL = 5 * time.Minute
+)
+
+// UserSummary is the minimal public view of a user used in graph responses
+type UserSummary struct {
+	UID         string `json:"uid"`
+	DisplayName string `json:"displayName"`
+	Username    string `json:"username,omitempty"`
+	PhotoURL    string `json:"photoUrl,omitempty"`
+}
+
+// TrustPath describes the shortest chain of accepted connections between two users
+type TrustPath struct {
+	TargetUID        string        `json:"targetUid"`
+	Found            bool          `json:"found"`
+	Degree           int           `json:"degree"`
+	Path             []UserSummary `json:"path"`
+	ConnectedThrough *UserSummary  `json:"connectedThrough,omitempty"`
+}
+
+var (
+	// neighborCache maps uid -> accepted connection uids and when each was accepted
+	neighborCache = cache.NewTTL[string, map[string]time.Time](graphCacheTTL)
+	// pathCache maps "from|to" -> computed trust path
+	pathCache = cache.NewTTL[string, TrustPath](graphCacheTTL)
+)
+
+func getMutualConnections(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	otherUID := chi.URLParam(r, "uid")
+	if otherUID == "" {
+		httpx.BadRequest(w, "uid is required")
+		return
+	}
+	if otherUID == uid {
+		httpx.BadRequest(w, "Cannot compute mutual connections with yourself")
+		return
+	}
+
+	ctx := r.Context()
+
+	mine, err := connectedUIDs(ctx, uid)
+	if err != nil {
+		log.Error("Failed to fetch connections", zap.Error(err), zap.String("uid", uid))
+		httpx.InternalServerError(w, "Failed to fetch mutual connections")
+		return
+	}
+
+	theirs, err := connectedUIDs(ctx, otherUID)
+	if err != nil {
+		log.Error("Failed to fetch connections", zap.Error(err), zap.String("uid", otherUID))
+		httpx.InternalServerError(w, "Failed to fetch mutual connections")
+		return
+	}
+
+	var mutualUIDs []string
+	for id := range mine {
+		if _, ok := theirs[id]; ok {
+			mutualUIDs = append(mutualUIDs, id)
+		}
+	}
+	sort.Strings(mutualUIDs)
+
+	mutual, err := fetchUserSummaries(ctx, mutualUIDs)
+	if err != nil {
+		log.Error("Failed to fetch user summaries", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to fetch mutual connections")
+		return
+	}
+
+	httpx.Success(w, map[string]interface{}{
+		"mutual": mutual,
+		"count":  len(mutual),
+	})
+}
+
+func getTrustPath(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	targetUID := chi.URLParam(r, "uid")
+	if targetUID == "" {
+		httpx.BadRequest(w, "uid is required")
+		return
+	}
+	if targetUID == uid {
+		httpx.BadRequest(w, "Cannot compute a trust path to yourself")
+		return
+	}
+
+	cacheKey := uid + "|" + targetUID
+	if path, ok := pathCache.Get(cacheKey); ok {
+		httpx.Success(w, path)
+		return
+	}
+
+	ctx := r.Context()
+
+	uids, err := shortestPath(ctx, uid, targetUID)
+	if err != nil {
+		log.Error("Failed to compute trust path", zap.Error(err),
+			zap.String("fromUid", uid),
+			zap.String("toUid", targetUID))
+		httpx.InternalServerError(w, "Failed to compute trust path")
+		return
+	}
+
+	path := TrustPath{
+		TargetUID: targetUID,
+		Path:      []UserSummary{},
+	}
+
+	if uids != nil {
+		summaries, err := fetchUserSummaries(ctx, uids)
+		if err != nil {
+			log.Error("Failed to fetch user summaries", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to compute trust path")
+			return
+		}
+
+		path.Found = true
+		path.Degree = len(uids) - 1
+		path.Path = summaries
+		if len(summaries) > 2 {
+			path.ConnectedThrough = &summaries[1]
+		}
+	}
+
+	pathCache.Set(cacheKey, path)
+	httpx.Success(w, path)
+}
+
+// shortestPath runs a bounded BFS over accepted relationships and returns the
+// uids on the shortest path from -> to (inclusive), or nil if the users are
+// more than three hops apart.
+func shortestPath(ctx context.Context, from, to string) ([]string, error) {
+	fromNeighbors, err := connectedUIDs(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1 hop: direct connection
+	if _, ok := fromNeighbors[to]; ok {
+		return []string{from, to}, nil
+	}
+
+	toNeighbors, err := connectedUIDs(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2 hops: a mutual connection
+	if via := firstShared(fromNeighbors, toNeighbors); via != "" {
+		return []string{from, via, to}, nil
+	}
+
+	// 3 hops: a connection of ours knows a connection of theirs
+	expanded := 0
+	for _, a := range sortedKeys(fromNeighbors) {
+		if expanded >= maxPathFanout {
+			break
+		}
+		expanded++
+
+		aNeighbors, err := connectedUIDs(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+
+		if b := firstShared(aNeighbors, toNeighbors); b != "" {
+			return []string{from, a, b, to}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// connectedUIDs returns the users with an accepted relationship to uid, mapped
+// to the time the relationship was last updated (i.e. accepted)
+func connectedUIDs(ctx context.Context, uid string) (map[string]time.Time, error) {
+	if neighbors, ok := neighborCache.Get(uid); ok {
+		return neighbors, nil
+	}
+
+	relationships, err := queryRelationships(ctx, uid, string(StatusAccepted))
+	if err != nil {
+		return nil, err
+	}
+
+	neighbors := make(map[string]time.Time, len(relationships))
+	for _, rel := range relationships {
+		neighbors[otherParty(rel, uid)] = rel.UpdatedAt
+	}
+
+	neighborCache.Set(uid, neighbors)
+	return neighbors, nil
+}
+
+// queryRelationships returns relationships where uid is either party and the status matches
+func queryRelationships(ctx context.Context, uid, status string) ([]Relationship, error) {
+	client := firestoredb.GetClient()
+	var relationships []Relationship
+
+	for _, field := range []string{"fromUid", "toUid"} {
+		iter := client.Collection("relationships").
+			Where(field, "==", uid).
+			Where("status", "==", status).
+			Documents(ctx)
+
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, fmt.Errorf("failed to iterate relationships: %w", err)
+			}
+
+			var rel Relationship
+			if err := doc.DataTo(&rel); err != nil {
+				log.Error("Failed to parse relationship", zap.Error(err))
+				continue
+			}
+
+			rel.ID = doc.Ref.ID
+			relationships = append(relationships, rel)
+		}
+		iter.Stop()
+	}
+
+	return relationships, nil
+}
+
+// fetchUserSummaries loads public summaries for uids, preserving order
+func fetchUserSummaries(ctx context.Context, uids []string) ([]UserSummary, error) {
+	summaries := make([]UserSummary, 0, len(uids))
+	if len(uids) == 0 {
+		return summaries, nil
+	}
+
+	client := firestoredb.GetClient()
+	refs := make([]*firestore.DocumentRef, len(uids))
+	for i, id := range uids {
+		refs[i] = client.Collection("users").Doc(id)
+	}
+
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	for i, doc := range docs {
+		summary := UserSummary{UID: uids[i]}
+		if doc.Exists() {
+			data := doc.Data()
+			summary.DisplayName, _ = data["displayName"].(string)
+			summary.Username, _ = data["username"].(string)
+			summary.PhotoURL, _ = data["photoUrl"].(string)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+// invalidateGraphCache drops cached graph data after the graph changes
+func invalidateGraphCache(uids ...string) {
+	for _, id := range uids {
+		neighborCache.Delete(id)
+	}
+	pathCache.Clear()
+}
+
+// otherParty returns the uid on the other side of rel from uid
+func otherParty(rel Relationship, uid string) string {
+	if rel.FromUID == uid {
+		return rel.ToUID
+	}
+	return rel.FromUID
+}
+
+// firstShared returns the smallest uid present in both sets, or "" if none
+func firstShared(a, b map[string]time.Time) string {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+
+	shared := ""
+	for id := range a {
+		if _, ok := b[id]; ok && (shared == "" || id < shared) {
+			shared = id
+		}
+	}
+	return shared
+}
+
+// sortedKeys returns the keys of set in sorted order for deterministic traversal
+func sortedKeys(set map[string]time.Time) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// chain connects a-b-c-d-e with accepted relationships
+func chain(t *testing.T) *firestore.Client {
+	t.Helper()
+	client := useFirestore(t)
+	for _, uid := range []string{"a", "b", "c", "d", "e"} {
+		addUser(t, client, uid, nil)
+	}
+	addRelationship(t, client, "a", "b", StatusAccepted)
+	addRelationship(t, client, "c", "b", StatusAccepted)
+	addRelationship(t, client, "c", "d", StatusAccepted)
+	addRelationship(t, client, "d", "e", StatusAccepted)
+	addRelationship(t, client, "a", "e", StatusRequested)
+	return client
+}
+
+func TestShortestPath(t *testing.T) {
+	chain(t)
+
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"a", "b", []string{"a", "b"}},
+		{"a", "c", []string{"a", "b", "c"}},
+		{"a", "d", []string{"a", "b", "c", "d"}},
+		{"d", "a", []string{"d", "c", "b", "a"}},
+		{"a", "e", nil},
+		{"a", "nobody", nil},
+	}
+	for _, tt := range tests {
+		got, err := shortestPath(context.Background(), tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shortestPath(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestGetMutualConnections(t *testing.T) {
+	client := useFirestore(t)
+	for _, uid := range []string{"a", "b", "m1", "m2", "x"} {
+		addUser(t, client, uid, nil)
+	}
+	addRelationship(t, client, "a", "m2", StatusAccepted)
+	addRelationship(t, client, "m2", "b", StatusAccepted)
+	addRelationship(t, client, "m1", "a", StatusAccepted)
+	addRelationship(t, client, "b", "m1", StatusAccepted)
+	addRelationship(t, client, "a", "x", StatusAccepted)
+	addRelationship(t, client, "x", "b", StatusRequested)
+
+	w := serve(t, "/mutual/{uid}", getMutualConnections, "a", http.MethodGet, "/mutual/b", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Mutual []UserSummary `json:"mutual"`
+		Count  int           `json:"count"`
+	}
+	decode(t, w, &resp)
+
+	want := []UserSummary{{UID: "m1", DisplayName: "M1"}, {UID: "m2", DisplayName: "M2"}}
+	if resp.Count != 2 || !reflect.DeepEqual(resp.Mutual, want) {
+		t.Errorf("mutual = %+v (count %d), want %+v", resp.Mutual, resp.Count, want)
+	}
+
+	if w := serve(t, "/mutual/{uid}", getMutualConnections, "a", http.MethodGet, "/mutual/a", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("mutual with yourself: status = %d, want 400", w.Code)
+	}
+}
+
+func TestGetTrustPath(t *testing.T) {
+	chain(t)
+
+	w := serve(t, "/path/{uid}", getTrustPath, "a", http.MethodGet, "/path/c", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var path TrustPath
+	decode(t, w, &path)
+	if !path.Found || path.Degree != 2 || len(path.Path) != 3 {
+		t.Fatalf("path = %+v, want a second degree path", path)
+	}
+	if path.ConnectedThrough == nil || path.ConnectedThrough.UID != "b" || path.ConnectedThrough.DisplayName != "B" {
+		t.Errorf("connectedThrough = %+v, want b", path.ConnectedThrough)
+	}
+
+	w = serve(t, "/path/{uid}", getTrustPath, "a", http.MethodGet, "/path/e", nil)
+	var far TrustPath
+	decode(t, w, &far)
+	if far.Found || far.Degree != 0 || len(far.Path) != 0 || far.ConnectedThrough != nil {
+		t.Errorf("path beyond three hops = %+v, want not found", far)
+	}
+}
+
+func TestGraphCacheInvalidation(t *testing.T) {
+	client := chain(t)
+	ctx := context.Background()
+
+	if path, _ := shortestPath(ctx, "a", "e"); path != nil {
+		t.Fatalf("path = %v before a and e are connected", path)
+	}
+
+	addRelationship(t, client, "a", "e", StatusAccepted)
+	invalidateGraphCache("a", "e")
+
+	path, err := shortestPath(ctx, "a", "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(path, []string{"a", "e"}) {
+		t.Errorf("path after accepting = %v, want [a e]", path)
+	}
+}
+
+func TestFirstShared(t *testing.T) {
+	set := func(ids ...string) map[string]time.Time {
+		s := map[string]time.Time{}
+		for _, id := range ids {
+			s[id] = time.Now()
+		}
+		return s
+	}
+
+	if got := firstShared(set("d", "b", "a"), set("b", "d", "z")); got != "b" {
+		t.Errorf("firstShared = %q, want the smallest shared uid b", got)
+	}
+	if got := firstShared(set("a"), set("b")); got != "" {
+		t.Errorf("firstShared of disjoint sets = %q, want none", got)
+	}
+}
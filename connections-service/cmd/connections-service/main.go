@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -21,9 +22,13 @@ import (
 	"github.com/trustlink/common/log"
 	"github.com/trustlink/common/rabbitmq"
 	"go.uber.org/zap"
-	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// maxUIDLength is the longest Firebase UID accepted in request bodies
+const maxUIDLength = 128
+
 // RelationshipStatus represents the status of a connection
 type RelationshipStatus string
 
@@ -31,6 +36,7 @@ const (
 	StatusRequested RelationshipStatus = "requested"
 	StatusAccepted  RelationshipStatus = "accepted"
 	StatusRejected  RelationshipStatus = "rejected"
+	StatusBlocked   RelationshipStatus = "blocked"
 )
 
 // Relationship represents a connection between two users
@@ -60,6 +66,12 @@ type ConnectionEvent struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+var (
+	errRequestNotFound   = errors.New("connection request not found")
+	errRequestNotPending = errors.New("connection request is not pending")
+	errBlockedByTarget   = errors.New("already blocked by the other user")
+)
+
 var rabbitConn *rabbitmq.Connection
 
 func main() {
@@ -79,6 +91,11 @@ func main() {
 	}
 	log.Info("Firebase initialized successfully")
 
+	// Initialize auth middleware
+	if err := authmw.Initialize(); err != nil {
+		log.Fatal("Failed to initialize auth middleware", zap.Error(err))
+	}
+
 	// Initialize Firestore
 	if err := firestoredb.Initialize(ctx); err != nil {
 		log.Fatal("Failed to initialize Firestore", zap.Error(err))
@@ -96,6 +113,21 @@ func main() {
 	defer rabbitConn.Close()
 	log.Info("RabbitMQ connected successfully")
 
+	// Start background recommendation precomputation
+	if err := startRecommendationWorker(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start recommendation worker", zap.Error(err))
+	}
+
+	// Start trust score computation
+	if err := startTrustScoring(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start trust scoring", zap.Error(err))
+	}
+
+	// Delete user data when accounts are deleted
+	if err := startAccountPurging(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start account purging", zap.Error(err))
+	}
+
 	// Setup router
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
@@ -111,11 +143,20 @@ func main() {
 
 	// Protected routes
 	r.Route("/v1/connections", func(r chi.Router) {
+		r.Use(authmw.AllowAPIKeys(authmw.ScopeConnectionsRead, authmw.ScopeConnectionsWrite))
 		r.Use(authmw.AuthMiddleware)
 		r.Post("/request", requestConnection)
 		r.Post("/accept", acceptConnection)
 		r.Post("/reject", rejectConnection)
+		r.Post("/block", blockUser)
 		r.Get("/", getConnections)
+		r.Get("/mutual/{uid}", getMutualConnections)
+		r.Get("/path/{uid}", getTrustPath)
+		r.Get("/recommendations", getRecommendations)
+		r.Get("/trust/{uid}", getTrustScore)
+		r.Post("/endorsements", createEndorsement)
+		r.Get("/endorsements", getEndorsements)
+		r.Delete("/endorsements/{id}", revokeEndorsement)
 	})
 
 	// Start server
@@ -164,13 +205,14 @@ func requestConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.TargetUID == "" {
-		httpx.BadRequest(w, "targetUid is required")
+	if v := validateTargetUID(req.TargetUID, uid, "Cannot connect with yourself"); !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
-	if req.TargetUID == uid {
-		httpx.BadRequest(w, "Cannot connect with yourself")
+	if ok, retryAfter := connectionRequestLimiter.Allow(uid); !ok {
+		log.Warn("Connection request rate limit exceeded", zap.String("uid", uid))
+		httpx.TooManyRequests(w, "Too many connection requests, please try again later", retryAfter)
 		return
 	}
 
@@ -180,7 +222,52 @@ func requestConnection(w http.ResponseWriter, r *http.Request) {
 	// Create deterministic relationship ID
 	relationshipID := createRelationshipID(uid, req.TargetUID)
 
+	// Check the existing relationship between the pair, if any
+	existing, err := client.Collection("relationships").Doc(relationshipID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Error("Failed to get relationship", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create connection request")
+		return
+	}
+
 	now := time.Now()
+	if existing != nil && existing.Exists() {
+		var rel Relationship
+		if err := existing.DataTo(&rel); err != nil {
+			log.Error("Failed to parse relationship", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to create connection request")
+			return
+		}
+
+		switch rel.Status {
+		case StatusAccepted:
+			httpx.Conflict(w, "Already connected")
+			return
+		case StatusRequested:
+			httpx.Conflict(w, "Connection request already pending")
+			return
+		case StatusBlocked:
+			httpx.Forbidden(w, "Cannot send a connection request to this user")
+			return
+		case StatusRejected:
+			if retryAfter := rel.UpdatedAt.Add(rejectionCooldown).Sub(now); retryAfter > 0 {
+				httpx.TooManyRequests(w, "Connection request was recently rejected", retryAfter)
+				return
+			}
+		}
+	}
+
+	retryAfter, err := pendingRequestsRetryAfter(ctx, uid)
+	if err != nil {
+		log.Error("Failed to check pending requests", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create connection request")
+		return
+	}
+	if retryAfter > 0 {
+		httpx.TooManyRequests(w, "Too many pending connection requests", retryAfter)
+		return
+	}
+
 	relationship := Relationship{
 		ID:        relationshipID,
 		FromUID:   uid,
@@ -191,7 +278,7 @@ func requestConnection(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Save to Firestore
-	_, err := client.Collection("relationships").Doc(relationshipID).Set(ctx, relationship)
+	_, err = client.Collection("relationships").Doc(relationshipID).Set(ctx, relationship)
 	if err != nil {
 		log.Error("Failed to create connection request", zap.Error(err))
 		httpx.InternalServerError(w, "Failed to create connection request")
@@ -202,6 +289,8 @@ func requestConnection(w http.ResponseWriter, r *http.Request) {
 		zap.String("fromUid", uid),
 		zap.String("toUid", req.TargetUID))
 
+	invalidateRelationshipCaches(uid, req.TargetUID)
+
 	// Publish event
 	event := ConnectionEvent{
 		FromUID:   uid,
@@ -229,26 +318,15 @@ func acceptConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.FromUID == "" {
-		httpx.BadRequest(w, "fromUid is required")
+	if v := validateFromUID(req.FromUID); !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
 	ctx := r.Context()
-	client := firestoredb.GetClient()
-
-	relationshipID := createRelationshipID(req.FromUID, uid)
-	docRef := client.Collection("relationships").Doc(relationshipID)
-
-	// Update status
-	now := time.Now()
-	_, err := docRef.Update(ctx, []firestore.Update{
-		{Path: "status", Value: string(StatusAccepted)},
-		{Path: "updatedAt", Value: now},
-	})
+	relationship, err := respondToRequest(ctx, req.FromUID, uid, StatusAccepted)
 	if err != nil {
-		log.Error("Failed to accept connection", zap.Error(err))
-		httpx.InternalServerError(w, "Failed to accept connection")
+		writeRespondError(w, err, "Failed to accept connection")
 		return
 	}
 
@@ -256,33 +334,19 @@ func acceptConnection(w http.ResponseWriter, r *http.Request) {
 		zap.String("fromUid", req.FromUID),
 		zap.String("toUid", uid))
 
+	invalidateRelationshipCaches(req.FromUID, uid)
+
 	// Publish event
 	event := ConnectionEvent{
 		FromUID:   req.FromUID,
 		ToUID:     uid,
-		CreatedAt: now,
+		CreatedAt: relationship.UpdatedAt,
 	}
 
 	if err := rabbitConn.Publish(ctx, "connection.accepted", event); err != nil {
 		log.Error("Failed to publish connection.accepted event", zap.Error(err))
 	}
 
-	// Fetch and return updated relationship
-	doc, err := docRef.Get(ctx)
-	if err != nil {
-		log.Error("Failed to get updated relationship", zap.Error(err))
-		httpx.InternalServerError(w, "Failed to get updated relationship")
-		return
-	}
-
-	var relationship Relationship
-	if err := doc.DataTo(&relationship); err != nil {
-		log.Error("Failed to parse relationship", zap.Error(err))
-		httpx.InternalServerError(w, "Failed to parse relationship")
-		return
-	}
-
-	relationship.ID = doc.Ref.ID
 	httpx.Success(w, relationship)
 }
 
@@ -299,25 +363,15 @@ func rejectConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.FromUID == "" {
-		httpx.BadRequest(w, "fromUid is required")
+	if v := validateFromUID(req.FromUID); !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
 	ctx := r.Context()
-	client := firestoredb.GetClient()
-
-	relationshipID := createRelationshipID(req.FromUID, uid)
-	docRef := client.Collection("relationships").Doc(relationshipID)
-
-	// Update status
-	_, err := docRef.Update(ctx, []firestore.Update{
-		{Path: "status", Value: string(StatusRejected)},
-		{Path: "updatedAt", Value: time.Now()},
-	})
+	relationship, err := respondToRequest(ctx, req.FromUID, uid, StatusRejected)
 	if err != nil {
-		log.Error("Failed to reject connection", zap.Error(err))
-		httpx.InternalServerError(w, "Failed to reject connection")
+		writeRespondError(w, err, "Failed to reject connection")
 		return
 	}
 
@@ -325,97 +379,171 @@ func rejectConnection(w http.ResponseWriter, r *http.Request) {
 		zap.String("fromUid", req.FromUID),
 		zap.String("toUid", uid))
 
-	// Fetch and return updated relationship
-	doc, err := docRef.Get(ctx)
-	if err != nil {
-		log.Error("Failed to get updated relationship", zap.Error(err))
-		httpx.InternalServerError(w, "Failed to get updated relationship")
-		return
+	invalidateRelationshipCaches(req.FromUID, uid)
+
+	// Publish event
+	event := ConnectionEvent{
+		FromUID:   req.FromUID,
+		ToUID:     uid,
+		CreatedAt: relationship.UpdatedAt,
 	}
 
-	var relationship Relationship
-	if err := doc.DataTo(&relationship); err != nil {
-		log.Error("Failed to parse relationship", zap.Error(err))
-		httpx.InternalServerError(w, "Failed to parse relationship")
-		return
+	if err := rabbitConn.Publish(ctx, "connection.rejected", event); err != nil {
+		log.Error("Failed to publish connection.rejected event", zap.Error(err))
 	}
 
-	relationship.ID = doc.Ref.ID
 	httpx.Success(w, relationship)
 }
 
-func getConnections(w http.ResponseWriter, r *http.Request) {
+// respondToRequest moves the pending request from fromUID to uid to next.
+// Only the recipient can respond, and only while the request is pending.
+func respondToRequest(ctx context.Context, fromUID, uid string, next RelationshipStatus) (Relationship, error) {
+	client := firestoredb.GetClient()
+	docRef := client.Collection("relationships").Doc(createRelationshipID(fromUID, uid))
+
+	var relationship Relationship
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errRequestNotFound
+			}
+			return err
+		}
+
+		if err := doc.DataTo(&relationship); err != nil {
+			return err
+		}
+		if relationship.FromUID != fromUID || relationship.ToUID != uid {
+			return errRequestNotFound
+		}
+		if relationship.Status != StatusRequested {
+			return errRequestNotPending
+		}
+
+		relationship.Status = next
+		relationship.UpdatedAt = time.Now()
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "status", Value: string(next)},
+			{Path: "updatedAt", Value: relationship.UpdatedAt},
+		})
+	})
+	relationship.ID = docRef.ID
+	return relationship, err
+}
+
+// writeRespondError writes the response for an error from respondToRequest
+func writeRespondError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, errRequestNotFound):
+		httpx.NotFound(w, "Connection request not found")
+	case errors.Is(err, errRequestNotPending):
+		httpx.Conflict(w, "Connection request is no longer pending")
+	default:
+		log.Error(message, zap.Error(err))
+		httpx.InternalServerError(w, message)
+	}
+}
+
+func blockUser(w http.ResponseWriter, r *http.Request) {
 	uid, ok := authmw.GetUserID(r.Context())
 	if !ok {
 		httpx.Unauthorized(w, "User ID not found in context")
 		return
 	}
 
-	status := r.URL.Query().Get("status")
-	if status == "" {
-		status = string(StatusAccepted)
+	var req ConnectionRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpx.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	if v := validateTargetUID(req.TargetUID, uid, "Cannot block yourself"); !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
 	}
 
 	ctx := r.Context()
 	client := firestoredb.GetClient()
 
-	// Query connections where user is either fromUid or toUid
-	var relationships []Relationship
+	// Blocking replaces any existing relationship except the other user's
+	// block, which only they can lift; fromUid is the blocker
+	relationshipID := createRelationshipID(uid, req.TargetUID)
+	docRef := client.Collection("relationships").Doc(relationshipID)
 
-	// Query where user is fromUid
-	iter1 := client.Collection("relationships").
-		Where("fromUid", "==", uid).
-		Where("status", "==", status).
-		Documents(ctx)
-	defer iter1.Stop()
+	now := time.Now()
+	relationship := Relationship{
+		ID:        relationshipID,
+		FromUID:   uid,
+		ToUID:     req.TargetUID,
+		Status:    StatusBlocked,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
 
-	for {
-		doc, err := iter1.Next()
-		if err == iterator.Done {
-			break
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
 		}
-		if err != nil {
-			log.Error("Failed to iterate relationships", zap.Error(err))
-			httpx.InternalServerError(w, "Failed to fetch connections")
+		if err == nil {
+			var existing Relationship
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			if existing.Status == StatusBlocked && existing.FromUID != uid {
+				return errBlockedByTarget
+			}
+		}
+		return tx.Set(docRef, relationship)
+	})
+	if err != nil {
+		if errors.Is(err, errBlockedByTarget) {
+			httpx.Conflict(w, "This relationship is already blocked")
 			return
 		}
+		log.Error("Failed to block user", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to block user")
+		return
+	}
 
-		var rel Relationship
-		if err := doc.DataTo(&rel); err != nil {
-			log.Error("Failed to parse relationship", zap.Error(err))
-			continue
-		}
+	log.Info("User blocked",
+		zap.String("fromUid", uid),
+		zap.String("toUid", req.TargetUID))
+
+	invalidateRelationshipCaches(uid, req.TargetUID)
 
-		rel.ID = doc.Ref.ID
-		relationships = append(relationships, rel)
+	// Publish event
+	event := ConnectionEvent{
+		FromUID:   uid,
+		ToUID:     req.TargetUID,
+		CreatedAt: now,
 	}
 
-	// Query where user is toUid
-	iter2 := client.Collection("relationships").
-		Where("toUid", "==", uid).
-		Where("status", "==", status).
-		Documents(ctx)
-	defer iter2.Stop()
+	if err := rabbitConn.Publish(ctx, "connection.blocked", event); err != nil {
+		log.Error("Failed to publish connection.blocked event", zap.Error(err))
+	}
 
-	for {
-		doc, err := iter2.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Error("Failed to iterate relationships", zap.Error(err))
-			httpx.InternalServerError(w, "Failed to fetch connections")
-			return
-		}
+	httpx.Success(w, relationship)
+}
 
-		var rel Relationship
-		if err := doc.DataTo(&rel); err != nil {
-			log.Error("Failed to parse relationship", zap.Error(err))
-			continue
-		}
+func getConnections(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = string(StatusAccepted)
+	}
 
-		rel.ID = doc.Ref.ID
-		relationships = append(relationships, rel)
+	relationships, err := queryRelationships(r.Context(), uid, status)
+	if err != nil {
+		log.Error("Failed to query relationships", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to fetch connections")
+		return
 	}
 
 	if relationships == nil {
@@ -428,6 +556,24 @@ func getConnections(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// validateTargetUID checks the targetUid of a request/block body
+func validateTargetUID(targetUID, uid, selfMessage string) *httpx.Validator {
+	v := httpx.NewValidator()
+	if v.Required("targetUid", targetUID) && v.MaxLength("targetUid", targetUID, maxUIDLength) {
+		v.Check(targetUID != uid, "targetUid", httpx.CodeInvalidValue, selfMessage)
+	}
+	return v
+}
+
+// validateFromUID checks the fromUid of an accept/reject body
+func validateFromUID(fromUID string) *httpx.Validator {
+	v := httpx.NewValidator()
+	if v.Required("fromUid", fromUID) {
+		v.MaxLength("fromUid", fromUID, maxUIDLength)
+	}
+	return v
+}
+
 func createRelationshipID(uid1, uid2 string) string {
 	// Create deterministic ID by sorting UIDs
 	uids := []string{uid1, uid2}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb/firestoretest"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// useFirestore serves an empty in-memory Firestore for the test and drops
+// anything cached from earlier tests
+func useFirestore(t *testing.T) *firestore.Client {
+	t.Helper()
+	server, err := firestoretest.Install()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	neighborCache.Clear()
+	pathCache.Clear()
+	recommendationCache.Clear()
+	return server.Client()
+}
+
+// addUser stores a profile for uid
+func addUser(t *testing.T, client *firestore.Client, uid string, fields map[string]interface{}) {
+	t.Helper()
+	data := map[string]interface{}{"uid": uid, "displayName": strings.ToUpper(uid)}
+	for k, v := range fields {
+		data[k] = v
+	}
+	if _, err := client.Collection("users").Doc(uid).Set(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addRelationship stores a relationship from one user to another
+func addRelationship(t *testing.T, client *firestore.Client, from, to string, status RelationshipStatus) {
+	t.Helper()
+	now := time.Now()
+	rel := Relationship{FromUID: from, ToUID: to, Status: status, CreatedAt: now, UpdatedAt: now}
+	if _, err := client.Collection("relationships").Doc(createRelationshipID(from, to)).Set(context.Background(), rel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve routes a request from uid to handler mounted at pattern and returns the recorded response
+func serve(t *testing.T, pattern string, handler http.HandlerFunc, uid, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var r *http.Request
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = httptest.NewRequest(method, target, strings.NewReader(string(encoded)))
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if uid != "" {
+		r = r.WithContext(context.WithValue(r.Context(), authmw.UserIDKey, uid))
+	}
+
+	router := chi.NewRouter()
+	router.Method(method, pattern, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// decode unmarshals a JSON response body into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+}
+
+// relationshipStatus returns the stored status of the relationship between a and b
+func relationshipStatus(t *testing.T, client *firestore.Client, a, b string) RelationshipStatus {
+	t.Helper()
+	doc, err := client.Collection("relationships").Doc(createRelationshipID(a, b)).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rel Relationship
+	if err := doc.DataTo(&rel); err != nil {
+		t.Fatal(err)
+	}
+	return rel.Status
+}
+
+func TestRespondToRequest(t *testing.T) {
+	for _, action := range []struct {
+		path    string
+		handler http.HandlerFunc
+		want    RelationshipStatus
+	}{
+		{"/accept", acceptConnection, StatusAccepted},
+		{"/reject", rejectConnection, StatusRejected},
+	} {
+		t.Run(action.path, func(t *testing.T) {
+			client := useFirestore(t)
+			addRelationship(t, client, "a", "b", StatusRequested)
+			addRelationship(t, client, "a", "c", StatusBlocked)
+
+			tests := []struct {
+				name       string
+				uid, from  string
+				wantStatus int
+			}{
+				{"sender answers own request", "a", "b", http.StatusNotFound},
+				{"no request", "b", "c", http.StatusNotFound},
+				{"blocked pair", "c", "a", http.StatusConflict},
+				{"recipient", "b", "a", http.StatusOK},
+				{"already answered", "b", "a", http.StatusConflict},
+			}
+			for _, tt := range tests {
+				w := serve(t, action.path, action.handler, tt.uid, http.MethodPost, action.path, ConnectionActionRequest{FromUID: tt.from})
+				if w.Code != tt.wantStatus {
+					t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+				}
+			}
+
+			if got := relationshipStatus(t, client, "a", "b"); got != action.want {
+				t.Errorf("request status = %s, want %s", got, action.want)
+			}
+			if got := relationshipStatus(t, client, "a", "c"); got != StatusBlocked {
+				t.Errorf("block overwritten with %s", got)
+			}
+		})
+	}
+}
+
+func TestBlockUser(t *testing.T) {
+	client := useFirestore(t)
+	addRelationship(t, client, "a", "b", StatusAccepted)
+	addRelationship(t, client, "c", "a", StatusBlocked)
+
+	block := func(uid, target string) int {
+		return serve(t, "/block", blockUser, uid, http.MethodPost, "/block", ConnectionRequestRequest{TargetUID: target}).Code
+	}
+
+	if code := block("a", "b"); code != http.StatusOK {
+		t.Fatalf("blocking a connection: status = %d", code)
+	}
+	if got := relationshipStatus(t, client, "a", "b"); got != StatusBlocked {
+		t.Errorf("status after block = %s", got)
+	}
+
+	if code := block("a", "c"); code != http.StatusConflict {
+		t.Errorf("blocking someone who blocked you: status = %d, want 409", code)
+	}
+	doc, err := client.Collection("relationships").Doc(createRelationshipID("a", "c")).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from, _ := doc.DataAt("fromUid"); from != "c" {
+		t.Errorf("blocker = %v, want c's block kept", from)
+	}
+}
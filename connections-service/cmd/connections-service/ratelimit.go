@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustlink/common/cache"
+	"github.com/trustlink/common/firestoredb"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// requestRateLimit is how many connection requests a user may send per requestRateWindow
+	requestRateLimit  = 20
+	requestRateWindow = time.Hour
+	// rejectionCooldown is how long a pair must wait after a rejection before requesting again
+	rejectionCooldown = 7 * 24 * time.Hour
+	// maxDailyPendingRequests caps outgoing requests still pending from the last 24 hours
+	maxDailyPendingRequests = 50
+)
+
+// slidingWindowLimiter is an in-memory sliding window rate limiter for
+// single-node deployments. A key's hits expire from the cache one window
+// after its latest hit, so idle keys do not accumulate.
+type slidingWindowLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   *cache.TTL[string, []time.Time]
+}
+
+func newSlidingWindowLimiter(limit int, window time.Duration) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		limit:  limit,
+		window: window,
+		hits:   cache.NewTTL[string, []time.Time](window),
+	}
+}
+
+var connectionRequestLimiter = newSlidingWindowLimiter(requestRateLimit, requestRateWindow)
+
+// Allow records a hit for key if it is within the limit. When the limit is
+// exceeded it returns false and how long until the oldest hit leaves the window.
+func (l *slidingWindowLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+
+	hits, _ := l.hits.Get(key)
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	hits = hits[i:]
+
+	if len(hits) >= l.limit {
+		return false, hits[0].Add(l.window).Sub(now)
+	}
+
+	l.hits.Set(key, append(hits, now))
+	return true, 0
+}
+
+// pendingRequestsRetryAfter returns how long uid must wait before sending another
+// request if they already have maxDailyPendingRequests pending from the last 24 hours
+func pendingRequestsRetryAfter(ctx context.Context, uid string) (time.Duration, error) {
+	since := time.Now().Add(-24 * time.Hour)
+
+	iter := firestoredb.GetClient().Collection("relationships").
+		Where("fromUid", "==", uid).
+		Where("status", "==", string(StatusRequested)).
+		Where("createdAt", ">=", since).
+		Documents(ctx)
+	defer iter.Stop()
+
+	count := 0
+	var oldest time.Time
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to count pending requests: %w", err)
+		}
+
+		count++
+		if createdAt, ok := doc.Data()["createdAt"].(time.Time); ok && (oldest.IsZero() || createdAt.Before(oldest)) {
+			oldest = createdAt
+		}
+	}
+
+	if count < maxDailyPendingRequests {
+		return 0, nil
+	}
+	return time.Until(oldest.Add(24 * time.Hour)), nil
+}
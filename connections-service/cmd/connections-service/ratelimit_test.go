@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	l := newSlidingWindowLimiter(2, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("hit %d denied", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("a")
+	if ok {
+		t.Fatal("hit over the limit allowed")
+	}
+	if retryAfter <= 59*time.Minute || retryAfter > time.Hour {
+		t.Errorf("retryAfter = %v, want just under an hour", retryAfter)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("key b limited by key a")
+	}
+}
+
+func TestSlidingWindowLimiterSlides(t *testing.T) {
+	l := newSlidingWindowLimiter(1, 20*time.Millisecond)
+
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first hit denied")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("second hit allowed inside the window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := l.hits.Get("a"); ok {
+		t.Error("idle key kept after the window passed")
+	}
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("hit denied after the window passed")
+	}
+}
+
+// useRequestLimiter replaces the connection request limiter for the test
+func useRequestLimiter(t *testing.T, limit int) {
+	t.Helper()
+	old := connectionRequestLimiter
+	connectionRequestLimiter = newSlidingWindowLimiter(limit, requestRateWindow)
+	t.Cleanup(func() { connectionRequestLimiter = old })
+}
+
+func requestFrom(t *testing.T, from, to string) *http.Response {
+	t.Helper()
+	w := serve(t, "/request", requestConnection, from, http.MethodPost, "/request", ConnectionRequestRequest{TargetUID: to})
+	return w.Result()
+}
+
+func setRelationshipUpdatedAt(t *testing.T, client *firestore.Client, from, to string, at time.Time) {
+	t.Helper()
+	_, err := client.Collection("relationships").Doc(createRelationshipID(from, to)).Update(context.Background(), []firestore.Update{
+		{Path: "updatedAt", Value: at},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestConnection(t *testing.T) {
+	client := useFirestore(t)
+	useRequestLimiter(t, requestRateLimit)
+
+	addRelationship(t, client, "a", "friend", StatusAccepted)
+	addRelationship(t, client, "blocker", "a", StatusBlocked)
+	addRelationship(t, client, "a", "recent", StatusRejected)
+	addRelationship(t, client, "a", "old", StatusRejected)
+	setRelationshipUpdatedAt(t, client, "a", "old", time.Now().Add(-rejectionCooldown-time.Hour))
+
+	tests := []struct {
+		name       string
+		to         string
+		wantStatus int
+	}{
+		{"yourself", "a", http.StatusBadRequest},
+		{"new", "b", http.StatusCreated},
+		{"already pending", "b", http.StatusConflict},
+		{"already connected", "friend", http.StatusConflict},
+		{"blocked", "blocker", http.StatusForbidden},
+		{"rejected recently", "recent", http.StatusTooManyRequests},
+		{"rejected before the cooldown", "old", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := requestFrom(t, "a", tt.to)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+				t.Error("no Retry-After header")
+			}
+		})
+	}
+}
+
+func TestRequestConnectionRateLimit(t *testing.T) {
+	useFirestore(t)
+	useRequestLimiter(t, 2)
+
+	for i := 0; i < 2; i++ {
+		if resp := requestFrom(t, "a", fmt.Sprintf("u%d", i)); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("request %d status = %d", i+1, resp.StatusCode)
+		}
+	}
+	resp := requestFrom(t, "a", "u2")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("request over the limit: status = %d, Retry-After = %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp := requestFrom(t, "b", "u2"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("other user limited: status = %d", resp.StatusCode)
+	}
+}
+
+func TestRequestConnectionPendingCap(t *testing.T) {
+	client := useFirestore(t)
+	useRequestLimiter(t, 2*maxDailyPendingRequests)
+
+	for i := 0; i < maxDailyPendingRequests; i++ {
+		addRelationship(t, client, "a", fmt.Sprintf("u%d", i), StatusRequested)
+	}
+
+	if resp := requestFrom(t, "a", "one-more"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status with %d pending = %d, want 429", maxDailyPendingRequests, resp.StatusCode)
+	}
+
+	// Requests older than a day no longer count
+	_, err := client.Collection("relationships").Doc(createRelationshipID("a", "u0")).Update(context.Background(), []firestore.Update{
+		{Path: "createdAt", Value: time.Now().Add(-25 * time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := requestFrom(t, "a", "one-more"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("status after the oldest request aged out = %d, want 201", resp.StatusCode)
+	}
+}
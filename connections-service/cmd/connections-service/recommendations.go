@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/cache"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxRecommendations is the number of ranked candidates kept per user
+	maxRecommendations = 50
+	// maxProfileCandidates caps candidates pulled in by shared profession/location
+	maxProfileCandidates = 50
+	// recommendationCacheTTL is how long precomputed recommendations are served
+	recommendationCacheTTL = 6 * time.Hour
+	// recentInteractionWindow is how recent a connection must be to count as an interaction
+	recentInteractionWindow = 30 * 24 * time.Hour
+
+	weightMutual            = 3.0
+	weightSharedProfession  = 2.0
+	weightSharedLocation    = 1.5
+	weightRecentInteraction = 1.0
+)
+
+// Recommendation is a ranked "people you may know" candidate
+type Recommendation struct {
+	User               UserSummary `json:"user"`
+	Score              float64     `json:"score"`
+	MutualCount        int         `json:"mutualCount"`
+	SharedProfession   bool        `json:"sharedProfession"`
+	SharedLocation     bool        `json:"sharedLocation"`
+	RecentInteractions int         `json:"recentInteractions"`
+}
+
+// visibilityEveryone is the profile-service visibility of fields anyone may see
+const visibilityEveryone = "everyone"
+
+// PrivacySettings is the part of the per-field visibility profile-service
+// stores in users/{uid}.privacy that recommendations depend on
+type PrivacySettings struct {
+	Location string `firestore:"location,omitempty"`
+}
+
+// withDefaults fills unset fields from profile-service's defaults
+func (p *PrivacySettings) withDefaults() PrivacySettings {
+	settings := PrivacySettings{Location: visibilityEveryone}
+	if p != nil && p.Location != "" {
+		settings.Location = p.Location
+	}
+	return settings
+}
+
+// recommendationProfile is the part of users/{uid} recommendations read
+type recommendationProfile struct {
+	DisplayName string           `firestore:"displayName"`
+	Username    string           `firestore:"username"`
+	PhotoURL    string           `firestore:"photoUrl"`
+	Profession  string           `firestore:"profession"`
+	Location    string           `firestore:"location"`
+	Privacy     *PrivacySettings `firestore:"privacy"`
+}
+
+// publicFields returns the profession and location anyone may see, which are
+// the only ones matched on. Profession has no privacy setting.
+func (p recommendationProfile) publicFields() (profession, location string) {
+	if p.Privacy.withDefaults().Location == visibilityEveryone {
+		location = p.Location
+	}
+	return p.Profession, location
+}
+
+// recommendationCache maps uid -> ranked recommendations
+var recommendationCache = cache.NewTTL[string, []Recommendation](recommendationCacheTTL)
+
+// recommendationWorker recomputes recommendations in the background
+type recommendationWorker struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	queue   chan string
+}
+
+var recommendations = &recommendationWorker{
+	pending: make(map[string]struct{}),
+	queue:   make(chan string, 256),
+}
+
+func getRecommendations(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= maxRecommendations {
+			limit = l
+		}
+	}
+
+	recs, ok := recommendationCache.Get(uid)
+	if !ok {
+		var err error
+		recs, err = computeRecommendations(r.Context(), uid)
+		if err != nil {
+			log.Error("Failed to compute recommendations", zap.Error(err), zap.String("uid", uid))
+			httpx.InternalServerError(w, "Failed to fetch recommendations")
+			return
+		}
+		recommendationCache.Set(uid, recs)
+	}
+
+	if len(recs) > limit {
+		recs = recs[:limit]
+	}
+
+	httpx.Success(w, map[string]interface{}{
+		"recommendations": recs,
+		"count":           len(recs),
+	})
+}
+
+// computeRecommendations ranks users the caller is not yet connected to by
+// mutual connections, shared profile fields and recent network activity
+func computeRecommendations(ctx context.Context, uid string) ([]Recommendation, error) {
+	mine, err := connectedUIDs(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded, err := excludedUIDs(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	for id := range mine {
+		excluded[id] = struct{}{}
+	}
+
+	candidates := make(map[string]*Recommendation)
+	candidate := func(id string) *Recommendation {
+		rec, ok := candidates[id]
+		if !ok {
+			rec = &Recommendation{User: UserSummary{UID: id}}
+			candidates[id] = rec
+		}
+		return rec
+	}
+
+	// Friends of friends
+	recentSince := time.Now().Add(-recentInteractionWindow)
+	expanded := 0
+	for _, friend := range sortedKeys(mine) {
+		if expanded >= maxPathFanout {
+			break
+		}
+		expanded++
+
+		friendNeighbors, err := connectedUIDs(ctx, friend)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, connectedAt := range friendNeighbors {
+			if _, skip := excluded[id]; skip {
+				continue
+			}
+			rec := candidate(id)
+			rec.MutualCount++
+			if connectedAt.After(recentSince) {
+				rec.RecentInteractions++
+			}
+		}
+	}
+
+	// Users sharing profession or location
+	client := firestoredb.GetClient()
+	me, err := client.Collection("users").Doc(uid).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("failed to get user profile: %w", err)
+	}
+	// A user without a profile has no profession or location to share
+	var profession, location string
+	if me.Exists() {
+		var profile recommendationProfile
+		if err := me.DataTo(&profile); err != nil {
+			return nil, fmt.Errorf("failed to parse user profile: %w", err)
+		}
+		profession, location = profile.publicFields()
+	}
+
+	for field, value := range map[string]string{"profession": profession, "location": location} {
+		if value == "" {
+			continue
+		}
+
+		iter := client.Collection("users").
+			Where(field, "==", value).
+			Limit(maxProfileCandidates).
+			Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, fmt.Errorf("failed to query users by %s: %w", field, err)
+			}
+			if _, skip := excluded[doc.Ref.ID]; skip {
+				continue
+			}
+
+			// Skip users who hide the matching field
+			var profile recommendationProfile
+			if err := doc.DataTo(&profile); err != nil {
+				log.Warn("Failed to parse candidate profile", zap.String("uid", doc.Ref.ID), zap.Error(err))
+				continue
+			}
+			if p, l := profile.publicFields(); (field == "profession" && p == value) || (field == "location" && l == value) {
+				candidate(doc.Ref.ID)
+			}
+		}
+		iter.Stop()
+	}
+
+	if len(candidates) == 0 {
+		return []Recommendation{}, nil
+	}
+
+	// Load candidate profiles to fill in summaries and shared fields
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = client.Collection("users").Doc(id)
+	}
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get candidate profiles: %w", err)
+	}
+
+	recs := make([]Recommendation, 0, len(docs))
+	for i, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+
+		var profile recommendationProfile
+		if err := doc.DataTo(&profile); err != nil {
+			log.Warn("Failed to parse candidate profile", zap.String("uid", doc.Ref.ID), zap.Error(err))
+			continue
+		}
+
+		rec := candidates[ids[i]]
+		rec.User.DisplayName = profile.DisplayName
+		rec.User.Username = profile.Username
+		rec.User.PhotoURL = profile.PhotoURL
+
+		p, l := profile.publicFields()
+		rec.SharedProfession = profession != "" && p == profession
+		rec.SharedLocation = location != "" && l == location
+
+		rec.Score = scoreRecommendation(rec)
+		recs = append(recs, *rec)
+	}
+
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Score != recs[j].Score {
+			return recs[i].Score > recs[j].Score
+		}
+		return recs[i].User.UID < recs[j].User.UID
+	})
+
+	if len(recs) > maxRecommendations {
+		recs = recs[:maxRecommendations]
+	}
+
+	return recs, nil
+}
+
+// scoreRecommendation combines the ranking signals into a single score
+func scoreRecommendation(rec *Recommendation) float64 {
+	score := weightMutual * float64(rec.MutualCount)
+	score += weightRecentInteraction * float64(rec.RecentInteractions)
+	if rec.SharedProfession {
+		score += weightSharedProfession
+	}
+	if rec.SharedLocation {
+		score += weightSharedLocation
+	}
+	return score
+}
+
+// excludedUIDs returns uid plus everyone with a pending, rejected or blocked relationship to uid
+func excludedUIDs(ctx context.Context, uid string) (map[string]struct{}, error) {
+	excluded := map[string]struct{}{uid: {}}
+
+	for _, status := range []RelationshipStatus{StatusRequested, StatusRejected, StatusBlocked} {
+		relationships, err := queryRelationships(ctx, uid, string(status))
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range relationships {
+			excluded[otherParty(rel, uid)] = struct{}{}
+		}
+	}
+
+	return excluded, nil
+}
+
+// startRecommendationWorker consumes connection status change events and
+// recomputes recommendations for both parties in the background. Caches are
+// per process, so every instance consumes every event.
+func startRecommendationWorker(ctx context.Context, conn *rabbitmq.Connection) error {
+	go recommendations.run(ctx)
+
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		PerInstance: true,
+		RoutingKeys: []string{
+			"connection.requested",
+			"connection.accepted",
+			"connection.rejected",
+			"connection.blocked",
+		},
+		Handler: func(body []byte) error {
+			var event ConnectionEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				log.Error("Failed to parse connection event", zap.Error(err))
+				return nil
+			}
+
+			invalidateRelationshipCaches(event.FromUID, event.ToUID)
+			recommendations.enqueue(event.FromUID)
+			recommendations.enqueue(event.ToUID)
+			return nil
+		},
+	})
+}
+
+// invalidateRelationshipCaches forgets the cached graph and recommendations
+// of uids after their relationship changed
+func invalidateRelationshipCaches(uids ...string) {
+	invalidateGraphCache(uids...)
+	for _, uid := range uids {
+		recommendationCache.Delete(uid)
+	}
+}
+
+// enqueue schedules a recomputation for uid, coalescing duplicate requests
+func (rw *recommendationWorker) enqueue(uid string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if _, ok := rw.pending[uid]; ok {
+		return
+	}
+
+	select {
+	case rw.queue <- uid:
+		rw.pending[uid] = struct{}{}
+	default:
+		log.Warn("Recommendation queue full, dropping job", zap.String("uid", uid))
+	}
+}
+
+func (rw *recommendationWorker) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case uid := <-rw.queue:
+			rw.mu.Lock()
+			delete(rw.pending, uid)
+			rw.mu.Unlock()
+
+			recs, err := computeRecommendations(ctx, uid)
+			if err != nil {
+				log.Error("Failed to precompute recommendations", zap.Error(err), zap.String("uid", uid))
+				continue
+			}
+
+			recommendationCache.Set(uid, recs)
+			log.Debug("Recommendations precomputed",
+				zap.String("uid", uid),
+				zap.Int("count", len(recs)))
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// recommendationGraph gives a, an engineer in Berlin, friends b and c and
+// a few people around them
+func recommendationGraph(t *testing.T) *firestore.Client {
+	t.Helper()
+	client := useFirestore(t)
+
+	addUser(t, client, "a", map[string]interface{}{"profession": "engineer", "location": "berlin"})
+	for _, uid := range []string{"b", "c", "d", "e", "h", "i"} {
+		addUser(t, client, uid, nil)
+	}
+	addUser(t, client, "f", map[string]interface{}{"profession": "engineer"})
+	addUser(t, client, "g", map[string]interface{}{"location": "berlin", "profession": "designer"})
+
+	addRelationship(t, client, "a", "b", StatusAccepted)
+	addRelationship(t, client, "c", "a", StatusAccepted)
+	addRelationship(t, client, "b", "d", StatusAccepted)
+	addRelationship(t, client, "d", "c", StatusAccepted)
+	addRelationship(t, client, "b", "e", StatusAccepted)
+	addRelationship(t, client, "b", "h", StatusAccepted)
+	addRelationship(t, client, "a", "h", StatusRequested)
+	addRelationship(t, client, "c", "i", StatusAccepted)
+	addRelationship(t, client, "i", "a", StatusBlocked)
+
+	// b and e connected long ago, so e is not a recent interaction
+	_, err := client.Collection("relationships").Doc(createRelationshipID("b", "e")).Update(context.Background(), []firestore.Update{
+		{Path: "updatedAt", Value: time.Now().Add(-2 * recentInteractionWindow)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestComputeRecommendations(t *testing.T) {
+	recommendationGraph(t)
+
+	recs, err := computeRecommendations(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Recommendation{
+		{User: UserSummary{UID: "d", DisplayName: "D"}, Score: 8, MutualCount: 2, RecentInteractions: 2},
+		{User: UserSummary{UID: "e", DisplayName: "E"}, Score: 3, MutualCount: 1},
+		{User: UserSummary{UID: "f", DisplayName: "F"}, Score: 2, SharedProfession: true},
+		{User: UserSummary{UID: "g", DisplayName: "G"}, Score: 1.5, SharedLocation: true},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("recommendations = %+v, want %d", recs, len(want))
+	}
+	for i := range want {
+		if recs[i] != want[i] {
+			t.Errorf("recommendation %d = %+v, want %+v", i, recs[i], want[i])
+		}
+	}
+}
+
+func TestComputeRecommendationsWithoutConnections(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, "loner", nil)
+
+	recs, err := computeRecommendations(context.Background(), "loner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs == nil || len(recs) != 0 {
+		t.Errorf("recommendations = %#v, want an empty list", recs)
+	}
+}
+
+func TestComputeRecommendationsWithoutProfile(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, "b", nil)
+	addUser(t, client, "c", nil)
+	addRelationship(t, client, "a", "b", StatusAccepted)
+	addRelationship(t, client, "b", "c", StatusAccepted)
+
+	// a has relationships but no users doc
+	w := serve(t, "/recommendations", getRecommendations, "a", http.MethodGet, "/recommendations", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Recommendations []Recommendation `json:"recommendations"`
+	}
+	decode(t, w, &resp)
+	if len(resp.Recommendations) != 1 || resp.Recommendations[0].User.UID != "c" {
+		t.Errorf("recommendations = %+v, want c", resp.Recommendations)
+	}
+}
+
+func TestComputeRecommendationsRespectsPrivacy(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, "a", map[string]interface{}{"location": "berlin"})
+	addUser(t, client, "public", map[string]interface{}{"location": "berlin", "privacy": map[string]interface{}{"location": "everyone"}})
+	addUser(t, client, "hidden", map[string]interface{}{"location": "berlin", "privacy": map[string]interface{}{"location": "connections"}})
+	addUser(t, client, "friend", nil)
+	addUser(t, client, "mutual", map[string]interface{}{"location": "berlin", "privacy": map[string]interface{}{"location": "only_me"}})
+	addRelationship(t, client, "a", "friend", StatusAccepted)
+	addRelationship(t, client, "friend", "mutual", StatusAccepted)
+
+	recs, err := computeRecommendations(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Recommendation{
+		{User: UserSummary{UID: "mutual", DisplayName: "MUTUAL"}, Score: 4, MutualCount: 1, RecentInteractions: 1},
+		{User: UserSummary{UID: "public", DisplayName: "PUBLIC"}, Score: 1.5, SharedLocation: true},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("recommendations = %+v, want %+v", recs, want)
+	}
+	for i := range want {
+		if recs[i] != want[i] {
+			t.Errorf("recommendation %d = %+v, want %+v", i, recs[i], want[i])
+		}
+	}
+
+	// A caller hiding their location is not matched on it either
+	addUser(t, client, "b", map[string]interface{}{"location": "berlin", "privacy": map[string]interface{}{"location": "only_me"}})
+	recs, err = computeRecommendations(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Errorf("recommendations for a hidden location = %+v, want none", recs)
+	}
+}
+
+func TestGetRecommendations(t *testing.T) {
+	client := recommendationGraph(t)
+
+	var resp struct {
+		Recommendations []Recommendation `json:"recommendations"`
+		Count           int              `json:"count"`
+	}
+	w := serve(t, "/recommendations", getRecommendations, "a", http.MethodGet, "/recommendations?limit=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	decode(t, w, &resp)
+	if resp.Count != 2 || resp.Recommendations[0].User.UID != "d" || resp.Recommendations[1].User.UID != "e" {
+		t.Fatalf("recommendations = %+v, want d and e", resp.Recommendations)
+	}
+
+	// Served from the cache until it is recomputed
+	addUser(t, client, "j", map[string]interface{}{"profession": "engineer"})
+	w = serve(t, "/recommendations", getRecommendations, "a", http.MethodGet, "/recommendations", nil)
+	decode(t, w, &resp)
+	if resp.Count != 4 {
+		t.Errorf("count = %d, want the 4 cached recommendations", resp.Count)
+	}
+}
+
+func TestRelationshipChangeInvalidatesRecommendations(t *testing.T) {
+	recommendationGraph(t)
+	useRequestLimiter(t, requestRateLimit)
+
+	var resp struct {
+		Recommendations []Recommendation `json:"recommendations"`
+	}
+	w := serve(t, "/recommendations", getRecommendations, "a", http.MethodGet, "/recommendations", nil)
+	decode(t, w, &resp)
+	if len(resp.Recommendations) == 0 || resp.Recommendations[0].User.UID != "d" {
+		t.Fatalf("recommendations = %+v, want d first", resp.Recommendations)
+	}
+
+	if resp := requestFrom(t, "a", "d"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("request status = %d", resp.StatusCode)
+	}
+	w = serve(t, "/recommendations", getRecommendations, "a", http.MethodGet, "/recommendations", nil)
+	decode(t, w, &resp)
+	for _, rec := range resp.Recommendations {
+		if rec.User.UID == "d" {
+			t.Fatal("d still recommended after a sent a request")
+		}
+	}
+}
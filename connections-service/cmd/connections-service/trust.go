@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// trustDamping is the probability of following an edge rather than teleporting
+	trustDamping = 0.85
+	// trustMaxIterations bounds the power iteration
+	trustMaxIterations = 100
+	// trustTolerance is the L1 change at which propagation is considered converged
+	trustTolerance = 1e-6
+	// trustPersistEpsilon is the minimum change in network score worth persisting
+	trustPersistEpsilon = 1e-3
+
+	weightTrustNetwork      = 0.5
+	weightTrustAccountAge   = 0.2
+	weightTrustCompleteness = 0.2
+	weightTrustModeration   = 0.1
+
+	// accountMaturity is the account age at which the age factor saturates
+	accountMaturity = 365 * 24 * time.Hour
+	// moderationStrikePenalty is how much each upheld moderation action removes from that factor
+	moderationStrikePenalty = 0.25
+	// moderationDetail describes the moderation factor without revealing the number of strikes
+	moderationDetail = "Upheld moderation actions lower this factor"
+
+	// moderationFewStrikes is the most upheld actions shown to others as "some" rather than "many"
+	moderationFewStrikes = 2
+
+	// trustRetryDelay spaces out redeliveries of events that could not be applied
+	trustRetryDelay = 5 * time.Second
+	// trustRebuildInterval is how often the graph is reloaded to pick up
+	// events missed while this instance was disconnected from the broker
+	trustRebuildInterval = time.Hour
+)
+
+// trustProfileFields are the users fields counted towards profile completeness
+var trustProfileFields = []string{
+	"displayName", "username", "photoUrl", "profession",
+	"location", "bio", "birthday", "gender",
+}
+
+// TrustFactor is one explainable contribution to a trust score
+type TrustFactor struct {
+	Name         string  `firestore:"name" json:"name"`
+	Value        float64 `firestore:"value" json:"value"`
+	Weight       float64 `firestore:"weight" json:"weight"`
+	Contribution float64 `firestore:"contribution" json:"contribution"`
+	Detail       string  `firestore:"detail" json:"detail"`
+
+	// Level buckets the moderation factor as none, some or many
+	Level string `firestore:"-" json:"level,omitempty"`
+}
+
+// TrustScore is stored in the trustScores collection
+type TrustScore struct {
+	UID        string        `firestore:"-" json:"uid"`
+	Score      float64       `firestore:"score" json:"score"`
+	Factors    []TrustFactor `firestore:"factors" json:"factors"`
+	ComputedAt time.Time     `firestore:"computedAt" json:"computedAt"`
+}
+
+// trustGraph is an in-memory copy of the accepted connection graph with the
+// last propagated network scores, used to recompute incrementally. Each
+// process keeps its own copy, fed by every connection.* event and reloaded
+// every trustRebuildInterval, so replicas converge on the same scores.
+type trustGraph struct {
+	mu     sync.Mutex
+	adj    map[string]map[string]struct{}
+	scores map[string]float64
+	seeds  map[string]struct{}
+}
+
+var trust = &trustGraph{
+	adj:    make(map[string]map[string]struct{}),
+	scores: make(map[string]float64),
+	seeds:  make(map[string]struct{}),
+}
+
+func getTrustScore(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	targetUID := chi.URLParam(r, "uid")
+	if targetUID == "" {
+		httpx.BadRequest(w, "uid is required")
+		return
+	}
+
+	doc, err := firestoredb.GetClient().Collection("trustScores").Doc(targetUID).Get(r.Context())
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "Trust score not computed yet")
+			return
+		}
+		log.Error("Failed to get trust score", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get trust score")
+		return
+	}
+
+	var score TrustScore
+	if err := doc.DataTo(&score); err != nil {
+		log.Error("Failed to parse trust score", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse trust score")
+		return
+	}
+
+	score.UID = targetUID
+	// Only the user and admins see how many upheld actions lowered the score
+	exact := uid == targetUID || authmw.HasRole(r.Context(), authmw.RoleAdmin)
+	for i, f := range score.Factors {
+		if f.Name == "moderation" {
+			score.Factors[i] = bucketModerationFactor(f, exact)
+		}
+	}
+	httpx.Success(w, score)
+}
+
+// bucketModerationFactor sets the level of a moderation factor and, unless
+// exact, replaces its value with that of the level so the number of upheld
+// actions cannot be read from it
+func bucketModerationFactor(f TrustFactor, exact bool) TrustFactor {
+	strikes := int(math.Round((1 - f.Value) / moderationStrikePenalty))
+
+	level, value := "none", 1.0
+	switch {
+	case strikes > moderationFewStrikes:
+		level, value = "many", 0
+	case strikes > 0:
+		level, value = "some", 0.5
+	}
+
+	f.Level = level
+	if !exact {
+		bucketed := newTrustFactor(f.Name, value, f.Weight, f.Detail)
+		f.Value, f.Contribution = bucketed.Value, bucketed.Contribution
+	}
+	return f
+}
+
+// startTrustScoring loads the connection graph, computes initial scores and
+// keeps them up to date from connection.* events
+func startTrustScoring(ctx context.Context, conn *rabbitmq.Connection) error {
+	for _, uid := range strings.Split(os.Getenv("TRUST_SEED_UIDS"), ",") {
+		if uid = strings.TrimSpace(uid); uid != "" {
+			trust.seeds[uid] = struct{}{}
+		}
+	}
+
+	go trust.run(ctx)
+
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		PerInstance: true,
+		RoutingKeys: []string{"connection.*"},
+		Handler: func(body []byte) error {
+			var event ConnectionEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				log.Error("Failed to parse connection event", zap.Error(err))
+				return nil
+			}
+
+			err := trust.applyChange(ctx, event.FromUID, event.ToUID)
+			if err != nil {
+				// The event is requeued; wait so a persistent failure does not spin
+				log.Error("Failed to apply connection change to trust graph", zap.Error(err))
+				select {
+				case <-ctx.Done():
+				case <-time.After(trustRetryDelay):
+				}
+			}
+			return err
+		},
+	})
+}
+
+// run computes the initial scores and rebuilds the graph every
+// trustRebuildInterval until ctx is done
+func (g *trustGraph) run(ctx context.Context) {
+	ticker := time.NewTicker(trustRebuildInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := g.rebuild(ctx); err != nil {
+			log.Error("Failed to rebuild trust graph", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rebuild loads every accepted relationship and recomputes all scores. It
+// holds g.mu throughout so changes applied concurrently are not overwritten
+// by the snapshot.
+func (g *trustGraph) rebuild(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	iter := firestoredb.GetClient().Collection("relationships").
+		Where("status", "==", string(StatusAccepted)).
+		Documents(ctx)
+	defer iter.Stop()
+
+	adj := make(map[string]map[string]struct{})
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate relationships: %w", err)
+		}
+
+		var rel Relationship
+		if err := doc.DataTo(&rel); err != nil {
+			log.Error("Failed to parse relationship", zap.Error(err))
+			continue
+		}
+		addEdge(adj, rel.FromUID, rel.ToUID)
+	}
+
+	g.adj = adj
+	changed := g.propagate()
+
+	log.Info("Trust graph loaded",
+		zap.Int("users", len(adj)),
+		zap.Int("changed", len(changed)))
+
+	persistTrustScores(ctx, changed)
+	return nil
+}
+
+// applyChange syncs the edge between two users with Firestore and propagates
+// scores warm-started from the previous result. It only fails if the
+// relationship cannot be read, before the graph is changed. The relationship
+// is read under g.mu so changes apply in the order they were read.
+func (g *trustGraph) applyChange(ctx context.Context, uid1, uid2 string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	relationshipID := createRelationshipID(uid1, uid2)
+	doc, err := firestoredb.GetClient().Collection("relationships").Doc(relationshipID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to get relationship: %w", err)
+	}
+
+	accepted := false
+	if doc != nil && doc.Exists() {
+		s, _ := doc.Data()["status"].(string)
+		accepted = s == string(StatusAccepted)
+	}
+
+	if accepted {
+		addEdge(g.adj, uid1, uid2)
+	} else {
+		removeEdge(g.adj, uid1, uid2)
+	}
+	changed := g.propagate()
+
+	// Always refresh both parties, even if their network score barely moved
+	for _, uid := range []string{uid1, uid2} {
+		if _, ok := changed[uid]; !ok {
+			changed[uid] = g.networkScore(uid)
+		}
+	}
+
+	persistTrustScores(ctx, changed)
+	return nil
+}
+
+// removeUser drops uid and its edges from the graph after account deletion
+// and refreshes the scores of its former connections
+func (g *trustGraph) removeUser(ctx context.Context, uid string) {
+	g.mu.Lock()
+	neighbors := g.adj[uid]
+	for other := range neighbors {
+		removeEdge(g.adj, uid, other)
+	}
+	delete(g.adj, uid)
+	delete(g.scores, uid)
+	changed := g.propagate()
+
+	for other := range neighbors {
+		if _, ok := changed[other]; !ok {
+			changed[other] = g.networkScore(other)
+		}
+	}
+	g.mu.Unlock()
+
+	persistTrustScores(ctx, changed)
+}
+
+// propagate runs EigenTrust-style power iteration over the graph, starting
+// from the previous scores, and returns the normalized network scores of
+// users whose score changed noticeably. Caller must hold g.mu.
+func (g *trustGraph) propagate() map[string]float64 {
+	n := len(g.adj)
+	changed := make(map[string]float64)
+	if n == 0 {
+		return changed
+	}
+
+	// Teleport distribution: pre-trusted seeds if any are in the graph, otherwise uniform
+	teleport := make(map[string]float64, n)
+	seeds := 0
+	for uid := range g.seeds {
+		if _, ok := g.adj[uid]; ok {
+			seeds++
+		}
+	}
+	for uid := range g.adj {
+		if seeds == 0 {
+			teleport[uid] = 1 / float64(n)
+		} else if _, ok := g.seeds[uid]; ok {
+			teleport[uid] = 1 / float64(seeds)
+		}
+	}
+
+	// Warm start from previous scores; users new to the graph start uniform
+	previous := g.scores
+	t := make(map[string]float64, n)
+	total := 0.0
+	for uid := range g.adj {
+		if v, ok := previous[uid]; ok {
+			t[uid] = v
+		} else {
+			t[uid] = 1 / float64(n)
+		}
+		total += t[uid]
+	}
+	for uid := range t {
+		t[uid] /= total
+	}
+
+	for i := 0; i < trustMaxIterations; i++ {
+		next := make(map[string]float64, n)
+		dangling := 0.0
+		for uid, score := range t {
+			neighbors := g.adj[uid]
+			if len(neighbors) == 0 {
+				dangling += score
+				continue
+			}
+			share := score / float64(len(neighbors))
+			for neighbor := range neighbors {
+				next[neighbor] += share
+			}
+		}
+
+		delta := 0.0
+		for uid := range g.adj {
+			v := trustDamping*(next[uid]+dangling*teleport[uid]) + (1-trustDamping)*teleport[uid]
+			delta += math.Abs(v - t[uid])
+			next[uid] = v
+		}
+		t = next
+
+		if delta < trustTolerance {
+			break
+		}
+	}
+
+	oldMax, newMax := maxScore(previous), maxScore(t)
+	for uid, v := range t {
+		oldNorm := 0.0
+		if oldMax > 0 {
+			oldNorm = previous[uid] / oldMax
+		}
+		if math.Abs(v/newMax-oldNorm) > trustPersistEpsilon {
+			changed[uid] = v / newMax
+		}
+	}
+	for uid := range previous {
+		if _, ok := t[uid]; !ok {
+			changed[uid] = 0
+		}
+	}
+
+	g.scores = t
+	return changed
+}
+
+// networkScore returns the normalized network score for uid. Caller must hold g.mu.
+func (g *trustGraph) networkScore(uid string) float64 {
+	highest := maxScore(g.scores)
+	if highest == 0 {
+		return 0
+	}
+	return g.scores[uid] / highest
+}
+
+// persistTrustScores combines network scores with per-user factors and writes
+// them out. A failed write is logged and skipped rather than retried: the
+// graph already reflects the change, and the user is rewritten the next time
+// their score moves or the graph is rebuilt at startup.
+func persistTrustScores(ctx context.Context, networkScores map[string]float64) {
+	failed := 0
+	for uid, network := range networkScores {
+		if err := persistTrustScore(ctx, uid, network); err != nil {
+			log.Error("Failed to persist trust score", zap.String("uid", uid), zap.Error(err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Warn("Some trust scores were not persisted",
+			zap.Int("failed", failed),
+			zap.Int("total", len(networkScores)))
+	}
+}
+
+func persistTrustScore(ctx context.Context, uid string, network float64) error {
+	client := firestoredb.GetClient()
+
+	userDoc, err := client.Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			// No profile yet; it will be scored on the next graph change
+			return nil
+		}
+		return fmt.Errorf("failed to get user %s: %w", uid, err)
+	}
+	data := userDoc.Data()
+
+	strikes, err := countModerationStrikes(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	age := 0.0
+	ageDays := 0
+	if createdAt, ok := data["createdAt"].(time.Time); ok {
+		elapsed := time.Since(createdAt)
+		ageDays = int(elapsed.Hours() / 24)
+		age = math.Min(float64(elapsed)/float64(accountMaturity), 1)
+	}
+
+	filled := 0
+	for _, field := range trustProfileFields {
+		if v, _ := data[field].(string); v != "" {
+			filled++
+		}
+	}
+	completeness := float64(filled) / float64(len(trustProfileFields))
+
+	moderation := math.Max(0, 1-moderationStrikePenalty*float64(strikes))
+
+	factors := []TrustFactor{
+		newTrustFactor("network", network, weightTrustNetwork,
+			"Trust propagated through accepted connections"),
+		newTrustFactor("accountAge", age, weightTrustAccountAge,
+			fmt.Sprintf("Account is %d days old", ageDays)),
+		newTrustFactor("profileCompleteness", completeness, weightTrustCompleteness,
+			fmt.Sprintf("%d of %d profile fields filled", filled, len(trustProfileFields))),
+		newTrustFactor("moderation", moderation, weightTrustModeration, moderationDetail),
+	}
+
+	total := 0.0
+	for _, f := range factors {
+		total += f.Contribution
+	}
+
+	score := TrustScore{
+		UID:        uid,
+		Score:      round1(total),
+		Factors:    factors,
+		ComputedAt: time.Now(),
+	}
+
+	if _, err := client.Collection("trustScores").Doc(uid).Set(ctx, score); err != nil {
+		return fmt.Errorf("failed to save trust score for %s: %w", uid, err)
+	}
+
+	// Denormalize onto the profile so it is returned with the user
+	if _, err := userDoc.Ref.Update(ctx, []firestore.Update{
+		{Path: "trustScore", Value: score.Score},
+	}); err != nil {
+		return fmt.Errorf("failed to update trust score on profile %s: %w", uid, err)
+	}
+
+	log.Debug("Trust score updated", zap.String("uid", uid), zap.Float64("score", score.Score))
+	return nil
+}
+
+// countModerationStrikes counts upheld moderation actions against uid
+func countModerationStrikes(ctx context.Context, uid string) (int, error) {
+	iter := firestoredb.GetClient().Collection("moderationActions").
+		Where("targetUid", "==", uid).
+		Where("status", "==", "upheld").
+		Documents(ctx)
+	defer iter.Stop()
+
+	strikes := 0
+	for {
+		_, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to query moderation actions: %w", err)
+		}
+		strikes++
+	}
+	return strikes, nil
+}
+
+func newTrustFactor(name string, value, weight float64, detail string) TrustFactor {
+	return TrustFactor{
+		Name:         name,
+		Value:        round3(value),
+		Weight:       weight,
+		Contribution: round1(100 * value * weight),
+		Detail:       detail,
+	}
+}
+
+func addEdge(adj map[string]map[string]struct{}, a, b string) {
+	if adj[a] == nil {
+		adj[a] = make(map[string]struct{})
+	}
+	if adj[b] == nil {
+		adj[b] = make(map[string]struct{})
+	}
+	adj[a][b] = struct{}{}
+	adj[b][a] = struct{}{}
+}
+
+func removeEdge(adj map[string]map[string]struct{}, a, b string) {
+	delete(adj[a], b)
+	delete(adj[b], a)
+}
+
+func maxScore(scores map[string]float64) float64 {
+	highest := 0.0
+	for _, v := range scores {
+		if v > highest {
+			highest = v
+		}
+	}
+	return highest
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
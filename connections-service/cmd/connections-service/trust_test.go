@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+)
+
+// newTestTrustGraph builds a graph from undirected edges with the given seeds
+func newTestTrustGraph(edges [][2]string, seeds ...string) *trustGraph {
+	g := &trustGraph{
+		adj:    make(map[string]map[string]struct{}),
+		scores: make(map[string]float64),
+		seeds:  make(map[string]struct{}),
+	}
+	for _, e := range edges {
+		addEdge(g.adj, e[0], e[1])
+	}
+	for _, seed := range seeds {
+		g.seeds[seed] = struct{}{}
+	}
+	return g
+}
+
+func assertNear(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-3 {
+		t.Errorf("%s = %.4f, want %.4f", name, got, want)
+	}
+}
+
+func TestPropagateEmptyGraph(t *testing.T) {
+	g := newTestTrustGraph(nil)
+	if changed := g.propagate(); len(changed) != 0 {
+		t.Errorf("changed = %v, want none", changed)
+	}
+}
+
+func TestPropagateStar(t *testing.T) {
+	g := newTestTrustGraph([][2]string{{"hub", "a"}, {"hub", "b"}, {"hub", "c"}})
+	changed := g.propagate()
+
+	assertNear(t, "hub", changed["hub"], 1)
+	for _, leaf := range []string{"a", "b", "c"} {
+		if changed[leaf] >= changed["hub"] {
+			t.Errorf("leaf %s scored %.4f, not below the hub", leaf, changed[leaf])
+		}
+		assertNear(t, leaf, changed[leaf], changed["a"])
+	}
+
+	total := 0.0
+	for _, v := range g.scores {
+		total += v
+	}
+	assertNear(t, "sum of raw scores", total, 1)
+}
+
+func TestPropagateSymmetricGraph(t *testing.T) {
+	g := newTestTrustGraph([][2]string{{"a", "b"}, {"b", "c"}, {"c", "a"}})
+	g.propagate()
+
+	for _, uid := range []string{"a", "b", "c"} {
+		assertNear(t, uid, g.networkScore(uid), 1)
+	}
+}
+
+func TestPropagateFavorsSeeds(t *testing.T) {
+	edges := [][2]string{{"a", "b"}, {"b", "c"}, {"c", "d"}}
+
+	uniform := newTestTrustGraph(edges)
+	uniform.propagate()
+	assertNear(t, "unseeded a", uniform.networkScore("a"), uniform.networkScore("d"))
+
+	seeded := newTestTrustGraph(edges, "a")
+	seeded.propagate()
+	if seeded.networkScore("a") <= seeded.networkScore("d") {
+		t.Errorf("seed a = %.4f, not above d = %.4f", seeded.networkScore("a"), seeded.networkScore("d"))
+	}
+	if seeded.networkScore("c") <= seeded.networkScore("d") {
+		t.Errorf("c = %.4f, not above d = %.4f, though closer to the seed",
+			seeded.networkScore("c"), seeded.networkScore("d"))
+	}
+}
+
+func TestPropagateSeedOutsideGraphIsIgnored(t *testing.T) {
+	g := newTestTrustGraph([][2]string{{"a", "b"}, {"b", "c"}}, "absent")
+	g.propagate()
+	assertNear(t, "a", g.networkScore("a"), g.networkScore("c"))
+}
+
+func TestPropagateReportsOnlyChanges(t *testing.T) {
+	g := newTestTrustGraph([][2]string{{"a", "b"}, {"b", "c"}})
+	g.propagate()
+
+	if changed := g.propagate(); len(changed) != 0 {
+		t.Errorf("second propagation changed %v, want nothing", changed)
+	}
+
+	addEdge(g.adj, "c", "d")
+	changed := g.propagate()
+	if _, ok := changed["d"]; !ok {
+		t.Errorf("new user d missing from changed %v", changed)
+	}
+}
+
+func TestPropagateWarmStartMatchesColdStart(t *testing.T) {
+	edges := [][2]string{{"a", "b"}, {"b", "c"}, {"c", "d"}, {"d", "a"}, {"a", "c"}, {"d", "e"}}
+
+	warm := newTestTrustGraph(edges[:3])
+	warm.propagate()
+	for _, e := range edges[3:] {
+		addEdge(warm.adj, e[0], e[1])
+	}
+	warm.propagate()
+
+	cold := newTestTrustGraph(edges)
+	cold.propagate()
+
+	for uid := range cold.adj {
+		assertNear(t, uid, warm.networkScore(uid), cold.networkScore(uid))
+	}
+}
+
+func TestPropagateRemovedUserScoresZero(t *testing.T) {
+	g := newTestTrustGraph([][2]string{{"a", "b"}, {"b", "c"}})
+	g.propagate()
+
+	removeEdge(g.adj, "b", "c")
+	delete(g.adj, "c")
+	changed := g.propagate()
+
+	if v, ok := changed["c"]; !ok || v != 0 {
+		t.Errorf("removed user c = %v, %v, want 0, true", v, ok)
+	}
+	if _, ok := g.scores["c"]; ok {
+		t.Error("removed user c still has a score")
+	}
+}
+
+func trustScoreOf(t *testing.T, client *firestore.Client, uid string) TrustScore {
+	t.Helper()
+	doc, err := client.Collection("trustScores").Doc(uid).Get(context.Background())
+	if err != nil {
+		t.Fatalf("trust score of %s: %v", uid, err)
+	}
+	var score TrustScore
+	if err := doc.DataTo(&score); err != nil {
+		t.Fatal(err)
+	}
+	return score
+}
+
+func factorOf(t *testing.T, score TrustScore, name string) TrustFactor {
+	t.Helper()
+	for _, f := range score.Factors {
+		if f.Name == name {
+			return f
+		}
+	}
+	t.Fatalf("no %s factor in %+v", name, score.Factors)
+	return TrustFactor{}
+}
+
+func TestTrustRebuildAndApplyChange(t *testing.T) {
+	ctx := context.Background()
+	client := useFirestore(t)
+	addUser(t, client, "a", map[string]interface{}{"createdAt": time.Now().Add(-2 * accountMaturity), "profession": "engineer"})
+	addUser(t, client, "b", nil)
+	addUser(t, client, "c", nil)
+	addRelationship(t, client, "a", "b", StatusAccepted)
+	addRelationship(t, client, "b", "c", StatusAccepted)
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.Collection("moderationActions").Add(ctx, map[string]interface{}{"targetUid": "c", "status": "upheld"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := newTestTrustGraph(nil)
+	if err := g.rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	a := trustScoreOf(t, client, "a")
+	assertNear(t, "a account age", factorOf(t, a, "accountAge").Value, 1)
+	assertNear(t, "a completeness", factorOf(t, a, "profileCompleteness").Value, 2.0/float64(len(trustProfileFields)))
+	assertNear(t, "a moderation", factorOf(t, a, "moderation").Value, 1)
+	assertNear(t, "b network", factorOf(t, trustScoreOf(t, client, "b"), "network").Value, 1)
+	assertNear(t, "c moderation", factorOf(t, trustScoreOf(t, client, "c"), "moderation").Value, 0.5)
+
+	doc, err := client.Collection("users").Doc("a").Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Data()["trustScore"]; got != a.Score {
+		t.Errorf("profile trustScore = %v, want %v", got, a.Score)
+	}
+
+	// Closing the triangle makes everyone equally trusted
+	addRelationship(t, client, "a", "c", StatusAccepted)
+	if err := g.applyChange(ctx, "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"a", "b", "c"} {
+		assertNear(t, uid+" network", factorOf(t, trustScoreOf(t, client, uid), "network").Value, 1)
+	}
+}
+
+func TestGetTrustScore(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, "a", nil)
+
+	if w := serve(t, "/trust/{uid}", getTrustScore, "viewer", http.MethodGet, "/trust/a", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("status before scoring = %d, want 404", w.Code)
+	}
+
+	if err := persistTrustScore(context.Background(), "a", 0.5); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(t, "/trust/{uid}", getTrustScore, "viewer", http.MethodGet, "/trust/a", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var score TrustScore
+	decode(t, w, &score)
+	if score.UID != "a" || len(score.Factors) != 4 {
+		t.Errorf("score = %+v", score)
+	}
+	assertNear(t, "network contribution", factorOf(t, score, "network").Contribution, 100*0.5*weightTrustNetwork)
+}
+
+func TestTrustRebuildKeepsConcurrentChanges(t *testing.T) {
+	ctx := context.Background()
+	client := useFirestore(t)
+	for _, uid := range []string{"a", "b", "c"} {
+		addUser(t, client, uid, nil)
+	}
+	addRelationship(t, client, "a", "b", StatusAccepted)
+
+	g := newTestTrustGraph(nil)
+	if err := g.rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	addRelationship(t, client, "b", "c", StatusAccepted)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := g.rebuild(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := g.applyChange(ctx, "b", "c"); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if _, ok := g.adj["b"]["c"]; !ok {
+		t.Errorf("edge b-c lost, graph = %v", g.adj)
+	}
+}
+
+func TestGetTrustScoreBucketsModeration(t *testing.T) {
+	ctx := context.Background()
+	client := useFirestore(t)
+	addUser(t, client, "a", nil)
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.Collection("moderationActions").Add(ctx, map[string]interface{}{"targetUid": "a", "status": "upheld"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := persistTrustScore(ctx, "a", 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		uid       string
+		admin     bool
+		wantValue float64
+	}{
+		{"other user", "viewer", false, 0},
+		{"self", "a", false, 0.25},
+		{"admin", "viewer", true, 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/trust/a", nil)
+			r = r.WithContext(authmw.WithIdentity(r.Context(), authmw.Identity{
+				UID:    tt.uid,
+				Claims: map[string]interface{}{authmw.RoleAdmin: tt.admin},
+			}))
+			router := chi.NewRouter()
+			router.Get("/trust/{uid}", getTrustScore)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var score TrustScore
+			decode(t, w, &score)
+			moderation := factorOf(t, score, "moderation")
+			if moderation.Level != "many" {
+				t.Errorf("level = %q, want many", moderation.Level)
+			}
+			assertNear(t, "value", moderation.Value, tt.wantValue)
+			assertNear(t, "contribution", moderation.Contribution, 100*tt.wantValue*weightTrustModeration)
+		})
+	}
+}
+
+func TestBucketModerationFactor(t *testing.T) {
+	tests := []struct {
+		strikes   int
+		wantLevel string
+		wantValue float64
+	}{
+		{0, "none", 1},
+		{1, "some", 0.5},
+		{2, "some", 0.5},
+		{3, "many", 0},
+		{5, "many", 0},
+	}
+	for _, tt := range tests {
+		value := math.Max(0, 1-moderationStrikePenalty*float64(tt.strikes))
+		f := bucketModerationFactor(newTrustFactor("moderation", value, weightTrustModeration, moderationDetail), false)
+		if f.Level != tt.wantLevel || f.Value != tt.wantValue {
+			t.Errorf("%d strikes = %s %.2f, want %s %.2f", tt.strikes, f.Level, f.Value, tt.wantLevel, tt.wantValue)
+		}
+	}
+}
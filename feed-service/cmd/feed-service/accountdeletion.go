@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+)
+
+// AccountDeletedEvent from profile service
+type AccountDeletedEvent struct {
+	UID         string    `json:"uid"`
+	RequestedAt time.Time `json:"requestedAt"`
+	DeletedAt   time.Time `json:"deletedAt"`
+}
+
+// AccountPurgedEvent is published to RabbitMQ once this service has deleted a user's data
+type AccountPurgedEvent struct {
+	UID         string    `json:"uid"`
+	Service     string    `json:"service"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// startAccountPurging deletes a user's posts and comments on account.deleted
+func startAccountPurging(ctx context.Context, conn *rabbitmq.Connection) error {
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		QueueName:   "feed-service.account-deletions",
+		RoutingKeys: []string{"account.deleted"},
+		Handler: func(body []byte) error {
+			var event AccountDeletedEvent
+			if err := json.Unmarshal(body, &event); err != nil || event.UID == "" {
+				log.Error("Failed to parse account.deleted event", zap.Error(err))
+				return nil
+			}
+			if err := purgeAccountData(ctx, event.UID); err != nil {
+				return err
+			}
+			return conn.Publish(ctx, "account.purged", AccountPurgedEvent{
+				UID:         event.UID,
+				Service:     "feed",
+				CompletedAt: time.Now(),
+			})
+		},
+	})
+}
+
+func purgeAccountData(ctx context.Context, uid string) error {
+	client := firestoredb.GetClient()
+
+	postIDs, err := firestoredb.DeleteDocuments(ctx, client.Collection("posts").Where("authorUid", "==", uid))
+	// Drop whatever was deleted from search even if some deletes failed
+	now := time.Now()
+	for _, postID := range postIDs {
+		postSearchIndex.Delete(postID)
+		publishPostChanged(ctx, "post.deleted", Post{ID: postID, AuthorUID: uid}, now)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete posts: %w", err)
+	}
+
+	comments, err := firestoredb.DeleteDocuments(ctx, client.Collection("comments").Where("authorUid", "==", uid))
+	if err != nil {
+		return fmt.Errorf("failed to delete comments: %w", err)
+	}
+
+	log.Info("Feed data purged",
+		zap.String("uid", uid),
+		zap.Int("posts", len(postIDs)),
+		zap.Int("comments", len(comments)))
+	return nil
+}
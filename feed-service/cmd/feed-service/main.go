@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -21,8 +22,17 @@ import (
 	"github.com/trustlink/common/httpx"
 	"github.com/trustlink/common/log"
 	"github.com/trustlink/common/rabbitmq"
+	"github.com/trustlink/common/svcauth"
 	"go.uber.org/zap"
-	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxPostTextLength = 5000
+	maxMediaURLs      = 10
+	// maxFeedScan bounds how many posts one feed page reads while skipping posts the viewer cannot see
+	maxFeedScan = 500
 )
 
 // Post represents a post in Firestore
@@ -33,13 +43,24 @@ type Post struct {
 	AuthorPhotoURL    string    `firestore:"authorPhotoUrl,omitempty" json:"authorPhotoUrl,omitempty"`
 	Text              string    `firestore:"text" json:"text"`
 	MediaURLs         []string  `firestore:"mediaUrls,omitempty" json:"mediaUrls,omitempty"`
+	Hashtags          []string  `firestore:"hashtags,omitempty" json:"hashtags,omitempty"`
+	Mentions          []Mention `firestore:"mentions,omitempty" json:"mentions,omitempty"`
+	Visibility        string    `firestore:"visibility,omitempty" json:"visibility,omitempty"`
 	CreatedAt         time.Time `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt         time.Time `firestore:"updatedAt" json:"updatedAt"`
 }
 
 // CreatePostRequest represents the request body for creating a post
 type CreatePostRequest struct {
-	Text      string   `json:"text"`
-	MediaURLs []string `json:"mediaUrls,omitempty"`
+	Text       string   `json:"text"`
+	MediaURLs  []string `json:"mediaUrls,omitempty"`
+	Visibility string   `json:"visibility,omitempty"`
+}
+
+// UpdatePostRequest represents the request body for editing a post
+type UpdatePostRequest struct {
+	Text       *string `json:"text,omitempty"`
+	Visibility *string `json:"visibility,omitempty"`
 }
 
 // PostCreatedEvent is published to RabbitMQ when a post is created
@@ -49,6 +70,13 @@ type PostCreatedEvent struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// PostChangedEvent is published to RabbitMQ when a post is edited or deleted
+type PostChangedEvent struct {
+	PostID    string    `json:"postId"`
+	AuthorUID string    `json:"authorUid"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
 var rabbitConn *rabbitmq.Connection
 
 func main() {
@@ -68,6 +96,17 @@ func main() {
 	}
 	log.Info("Firebase initialized successfully")
 
+	// Initialize auth middleware
+	if err := authmw.Initialize(); err != nil {
+		log.Fatal("Failed to initialize auth middleware", zap.Error(err))
+	}
+
+	// Initialize service-to-service authentication
+	if err := svcauth.Initialize("feed-service"); err != nil {
+		log.Fatal("Failed to initialize service authentication", zap.Error(err))
+	}
+	profileServiceURL = getEnv("PROFILE_SERVICE_URL", "http://localhost:8081")
+
 	// Initialize Firestore
 	if err := firestoredb.Initialize(ctx); err != nil {
 		log.Fatal("Failed to initialize Firestore", zap.Error(err))
@@ -85,6 +124,16 @@ func main() {
 	defer rabbitConn.Close()
 	log.Info("RabbitMQ connected successfully")
 
+	// Start post search indexing
+	if err := startPostIndexing(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start post indexing", zap.Error(err))
+	}
+
+	// Delete user data when accounts are deleted
+	if err := startAccountPurging(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start account purging", zap.Error(err))
+	}
+
 	// Setup router
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
@@ -100,9 +149,14 @@ func main() {
 
 	// Protected routes
 	r.Route("/v1/posts", func(r chi.Router) {
+		r.Use(authmw.AllowAPIKeys(authmw.ScopePostsRead, authmw.ScopePostsWrite))
 		r.Use(authmw.AuthMiddleware)
 		r.Post("/", createPost)
 		r.Get("/", getPosts)
+		r.Get("/search", searchPosts)
+		r.Get("/tags/{tag}", getPostsByTag)
+		r.Patch("/{id}", updatePost)
+		r.Delete("/{id}", deletePost)
 	})
 
 	// Start server
@@ -151,8 +205,21 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Text == "" {
-		httpx.BadRequest(w, "Text is required")
+	v := httpx.NewValidator()
+	if v.Required("text", req.Text) {
+		v.MaxLength("text", req.Text, maxPostTextLength)
+	}
+	if v.MaxItems("mediaUrls", len(req.MediaURLs), maxMediaURLs) {
+		for i, mediaURL := range req.MediaURLs {
+			v.URL(fmt.Sprintf("mediaUrls[%d]", i), mediaURL, "https")
+		}
+	}
+	if req.Visibility == "" {
+		req.Visibility = VisibilityPublic
+	}
+	v.OneOf("visibility", req.Visibility, VisibilityPublic, VisibilityConnections)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
 		return
 	}
 
@@ -160,17 +227,22 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 	client := firestoredb.GetClient()
 
 	// Get user profile for denormalized data
-	userDoc, err := client.Collection("users").Doc(uid).Get(ctx)
+	author, err := getProfileSummary(ctx, uid)
 	if err != nil {
+		if errors.Is(err, errProfileNotFound) {
+			httpx.NotFound(w, "User profile not found")
+			return
+		}
 		log.Error("Failed to get user profile", zap.Error(err))
 		httpx.InternalServerError(w, "Failed to get user profile")
 		return
 	}
 
-	displayName := userDoc.Data()["displayName"].(string)
-	photoURL := ""
-	if url, ok := userDoc.Data()["photoUrl"].(string); ok {
-		photoURL = url
+	mentions, err := resolveMentions(ctx, req.Text)
+	if err != nil {
+		log.Error("Failed to resolve mentions", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to resolve mentions")
+		return
 	}
 
 	// Create post
@@ -179,11 +251,15 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 	post := Post{
 		ID:                postID,
 		AuthorUID:         uid,
-		AuthorDisplayName: displayName,
-		AuthorPhotoURL:    photoURL,
+		AuthorDisplayName: author.DisplayName,
+		AuthorPhotoURL:    author.PhotoURL,
 		Text:              req.Text,
 		MediaURLs:         req.MediaURLs,
+		Hashtags:          analyzeText(req.Text).Hashtags,
+		Mentions:          mentions,
+		Visibility:        req.Visibility,
 		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 
 	// Save to Firestore
@@ -196,6 +272,8 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 
 	log.Info("Post created", zap.String("postId", postID), zap.String("authorUid", uid))
 
+	postSearchIndex.Upsert(post)
+
 	// Publish event to RabbitMQ
 	event := PostCreatedEvent{
 		PostID:    postID,
@@ -208,10 +286,18 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 		// Don't fail the request if event publishing fails
 	}
 
+	publishPostMentioned(ctx, post, mentionedUIDs(mentions, uid, nil))
+
 	httpx.Created(w, post)
 }
 
 func getPosts(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
 	// Get limit from query params
 	limitStr := r.URL.Query().Get("limit")
 	limit := 20
@@ -224,43 +310,251 @@ func getPosts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	client := firestoredb.GetClient()
 
-	// Query posts ordered by createdAt descending
-	iter := client.Collection("posts").
-		OrderBy("createdAt", firestore.Desc).
-		Limit(limit).
-		Documents(ctx)
-	defer iter.Stop()
-
-	var posts []Post
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
+	network, err := loadViewerNetwork(ctx, uid)
+	if err != nil {
+		log.Error("Failed to load viewer network", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to fetch posts")
+		return
+	}
+
+	// Continue after the post named by cursor, from a previous page's nextCursor
+	var after *firestore.DocumentSnapshot
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err = client.Collection("posts").Doc(cursor).Get(ctx)
 		if err != nil {
-			log.Error("Failed to iterate posts", zap.Error(err))
+			if status.Code(err) == codes.NotFound {
+				httpx.BadRequest(w, "Invalid cursor")
+				return
+			}
+			log.Error("Failed to get cursor post", zap.Error(err))
 			httpx.InternalServerError(w, "Failed to fetch posts")
 			return
 		}
+	}
+
+	posts, next, err := listVisiblePosts(ctx, network, limit, after)
+	if err != nil {
+		log.Error("Failed to list posts", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to fetch posts")
+		return
+	}
 
-		var post Post
-		if err := doc.DataTo(&post); err != nil {
-			log.Error("Failed to parse post", zap.Error(err))
-			continue
+	resp := map[string]interface{}{
+		"posts": posts,
+		"count": len(posts),
+	}
+	if next != "" {
+		resp["nextCursor"] = next
+	}
+	httpx.Success(w, resp)
+}
+
+// listVisiblePosts pages through posts newest first, starting after after if
+// set, until limit posts the viewer may see are collected. It scans at most
+// maxFeedScan posts and returns the cursor to continue from, or "" once
+// there are no more posts.
+func listVisiblePosts(ctx context.Context, network *viewerNetwork, limit int, after *firestore.DocumentSnapshot) ([]Post, string, error) {
+	posts := []Post{}
+	scanned := 0
+	for scanned < maxFeedScan {
+		query := firestoredb.GetClient().Collection("posts").
+			OrderBy("createdAt", firestore.Desc).
+			Limit(limit)
+		if after != nil {
+			query = query.StartAfter(after)
 		}
 
-		post.ID = doc.Ref.ID
-		posts = append(posts, post)
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query posts: %w", err)
+		}
+
+		for _, doc := range docs {
+			after = doc
+			scanned++
+
+			var post Post
+			if err := doc.DataTo(&post); err != nil {
+				log.Error("Failed to parse post", zap.Error(err))
+				continue
+			}
+			post.ID = doc.Ref.ID
+			if !network.canView(post) {
+				continue
+			}
+
+			posts = append(posts, post)
+			if len(posts) == limit {
+				return posts, doc.Ref.ID, nil
+			}
+		}
+
+		if len(docs) < limit {
+			return posts, "", nil
+		}
 	}
 
-	if posts == nil {
-		posts = []Post{}
+	// Give up on this page; the client continues from the last scanned post
+	return posts, after.Ref.ID, nil
+}
+
+func updatePost(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
 	}
 
-	httpx.Success(w, map[string]interface{}{
-		"posts": posts,
-		"count": len(posts),
-	})
+	var req UpdatePostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpx.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	now := time.Now()
+	updates := []firestore.Update{
+		{Path: "updatedAt", Value: now},
+	}
+
+	v := httpx.NewValidator()
+	if req.Text != nil {
+		if v.Required("text", *req.Text) && v.MaxLength("text", *req.Text, maxPostTextLength) {
+			updates = append(updates,
+				firestore.Update{Path: "text", Value: *req.Text},
+				firestore.Update{Path: "hashtags", Value: analyzeText(*req.Text).Hashtags},
+			)
+		}
+	}
+	if req.Visibility != nil {
+		if v.OneOf("visibility", *req.Visibility, VisibilityPublic, VisibilityConnections) {
+			updates = append(updates, firestore.Update{Path: "visibility", Value: *req.Visibility})
+		}
+	}
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	ctx := r.Context()
+	postID := chi.URLParam(r, "id")
+	docRef := firestoredb.GetClient().Collection("posts").Doc(postID)
+
+	post, ok := getAuthoredPost(w, r, docRef, uid)
+	if !ok {
+		return
+	}
+
+	var mentions []Mention
+	if req.Text != nil {
+		var err error
+		mentions, err = resolveMentions(ctx, *req.Text)
+		if err != nil {
+			log.Error("Failed to resolve mentions", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to resolve mentions")
+			return
+		}
+		updates = append(updates, firestore.Update{Path: "mentions", Value: mentions})
+	}
+
+	if _, err := docRef.Update(ctx, updates); err != nil {
+		log.Error("Failed to update post", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to update post")
+		return
+	}
+
+	// Only users newly mentioned by an edit are notified
+	previousMentions := post.Mentions
+	if req.Text != nil {
+		post.Text = *req.Text
+		post.Hashtags = analyzeText(*req.Text).Hashtags
+		post.Mentions = mentions
+	}
+	if req.Visibility != nil {
+		post.Visibility = *req.Visibility
+	}
+	post.UpdatedAt = now
+
+	log.Info("Post updated", zap.String("postId", postID), zap.String("authorUid", uid))
+
+	postSearchIndex.Upsert(post)
+	publishPostChanged(ctx, "post.updated", post, now)
+	if req.Text != nil {
+		publishPostMentioned(ctx, post, mentionedUIDs(mentions, uid, previousMentions))
+	}
+
+	httpx.Success(w, post)
+}
+
+func deletePost(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	ctx := r.Context()
+	postID := chi.URLParam(r, "id")
+	docRef := firestoredb.GetClient().Collection("posts").Doc(postID)
+
+	post, ok := getAuthoredPost(w, r, docRef, uid)
+	if !ok {
+		return
+	}
+
+	if _, err := docRef.Delete(ctx); err != nil {
+		log.Error("Failed to delete post", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to delete post")
+		return
+	}
+
+	log.Info("Post deleted", zap.String("postId", postID), zap.String("authorUid", uid))
+
+	postSearchIndex.Delete(postID)
+	publishPostChanged(ctx, "post.deleted", post, time.Now())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getAuthoredPost loads the post at docRef, writing an error response unless it exists and uid wrote it
+func getAuthoredPost(w http.ResponseWriter, r *http.Request, docRef *firestore.DocumentRef, uid string) (Post, bool) {
+	doc, err := docRef.Get(r.Context())
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "Post not found")
+			return Post{}, false
+		}
+		log.Error("Failed to get post", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get post")
+		return Post{}, false
+	}
+
+	var post Post
+	if err := doc.DataTo(&post); err != nil {
+		log.Error("Failed to parse post", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse post")
+		return Post{}, false
+	}
+	post.ID = doc.Ref.ID
+
+	if post.AuthorUID != uid {
+		httpx.Forbidden(w, "Only the author can modify this post")
+		return Post{}, false
+	}
+
+	return post, true
+}
+
+// publishPostChanged publishes a post.updated or post.deleted event
+func publishPostChanged(ctx context.Context, routingKey string, post Post, at time.Time) {
+	event := PostChangedEvent{
+		PostID:    post.ID,
+		AuthorUID: post.AuthorUID,
+		UpdatedAt: at,
+	}
+	if err := rabbitConn.Publish(ctx, routingKey, event); err != nil {
+		log.Error("Failed to publish "+routingKey+" event", zap.Error(err))
+		// Don't fail the request if event publishing fails
+	}
 }
 
 func getEnv(key, fallback string) string {
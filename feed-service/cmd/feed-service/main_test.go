@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/firestoredb/firestoretest"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// useFirestore serves an empty in-memory Firestore for the test
+func useFirestore(t *testing.T) *firestore.Client {
+	t.Helper()
+	server, err := firestoretest.Install()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server.Client()
+}
+
+func TestListVisiblePosts(t *testing.T) {
+	client := useFirestore(t)
+	ctx := context.Background()
+
+	// Every other post is for a stranger's connections only
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		post := Post{AuthorUID: "friend", Visibility: VisibilityPublic, CreatedAt: start.Add(-time.Duration(i) * time.Minute)}
+		if i%2 == 1 {
+			post.AuthorUID, post.Visibility = "stranger", VisibilityConnections
+		}
+		if _, err := client.Collection("posts").Doc(fmt.Sprintf("p%d", i)).Set(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	network := &viewerNetwork{uid: "viewer", connected: map[string]struct{}{}, blocked: map[string]struct{}{}}
+
+	var pages [][]string
+	var after *firestore.DocumentSnapshot
+	for {
+		posts, next, err := listVisiblePosts(ctx, network, 3, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+		pages = append(pages, ids)
+		if next == "" {
+			break
+		}
+		if after, err = client.Collection("posts").Doc(next).Get(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]string{{"p0", "p2", "p4"}, {"p6"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want full pages of visible posts %v", pages, want)
+	}
+}
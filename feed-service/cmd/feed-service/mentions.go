@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxMentionsPerPost caps how many distinct usernames are resolved per post
+	maxMentionsPerPost = 20
+)
+
+// Same format as profile-service usernames
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// Mention is a resolved @username in a post's text. Start and End are
+// offsets in Unicode code points, End exclusive, covering the '@'.
+type Mention struct {
+	UID      string `firestore:"uid" json:"uid"`
+	Username string `firestore:"username" json:"username"`
+	Start    int    `firestore:"start" json:"start"`
+	End      int    `firestore:"end" json:"end"`
+}
+
+// PostMentionedEvent is published to RabbitMQ when users are mentioned in a post
+type PostMentionedEvent struct {
+	PostID        string    `json:"postId"`
+	AuthorUID     string    `json:"authorUid"`
+	MentionedUIDs []string  `json:"mentionedUids"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// findMentions returns the ranges of well-formed @usernames in text, using
+// the same word boundaries as analyzeText. UID is left empty.
+func findMentions(text string) []Mention {
+	var mentions []Mention
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isTermRune(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isTermRune(runes[end]) {
+			end++
+		}
+
+		username := string(runes[i+1 : end])
+		if usernamePattern.MatchString(username) {
+			mentions = append(mentions, Mention{Username: username, Start: i, End: end})
+		}
+		i = end - 1
+	}
+	return mentions
+}
+
+// resolveMentions finds @usernames in text and resolves them against the
+// username registry, dropping names that are not currently claimed
+func resolveMentions(ctx context.Context, text string) ([]Mention, error) {
+	found := findMentions(text)
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	client := firestoredb.GetClient()
+	var refs []*firestore.DocumentRef
+	seen := map[string]struct{}{}
+	for _, m := range found {
+		key := strings.ToLower(m.Username)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if len(refs) >= maxMentionsPerPost {
+			break
+		}
+		seen[key] = struct{}{}
+		refs = append(refs, client.Collection("usernames").Doc(key))
+	}
+
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get username reservations: %w", err)
+	}
+
+	type reservation struct {
+		UID      string `firestore:"uid"`
+		Username string `firestore:"username"`
+		Released bool   `firestore:"released"`
+	}
+	owners := map[string]reservation{}
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var res reservation
+		if err := doc.DataTo(&res); err != nil {
+			log.Error("Failed to parse username reservation", zap.String("username", doc.Ref.ID), zap.Error(err))
+			continue
+		}
+		if res.UID != "" && !res.Released {
+			owners[doc.Ref.ID] = res
+		}
+	}
+
+	var mentions []Mention
+	for _, m := range found {
+		res, ok := owners[strings.ToLower(m.Username)]
+		if !ok {
+			continue
+		}
+		m.UID = res.UID
+		m.Username = res.Username
+		mentions = append(mentions, m)
+	}
+	return mentions, nil
+}
+
+// mentionedUIDs returns the distinct users in mentions, excluding authorUID
+// and anyone already in previous
+func mentionedUIDs(mentions []Mention, authorUID string, previous []Mention) []string {
+	skip := map[string]struct{}{authorUID: {}}
+	for _, m := range previous {
+		skip[m.UID] = struct{}{}
+	}
+
+	var uids []string
+	for _, m := range mentions {
+		if _, ok := skip[m.UID]; ok {
+			continue
+		}
+		skip[m.UID] = struct{}{}
+		uids = append(uids, m.UID)
+	}
+	return uids
+}
+
+// publishPostMentioned publishes a post.mentioned event when uids is non-empty
+func publishPostMentioned(ctx context.Context, post Post, uids []string) {
+	if len(uids) == 0 {
+		return
+	}
+
+	event := PostMentionedEvent{
+		PostID:        post.ID,
+		AuthorUID:     post.AuthorUID,
+		MentionedUIDs: uids,
+		CreatedAt:     time.Now(),
+	}
+	if err := rabbitConn.Publish(ctx, "post.mentioned", event); err != nil {
+		log.Error("Failed to publish post.mentioned event", zap.Error(err))
+		// Don't fail the request if event publishing fails
+	}
+}
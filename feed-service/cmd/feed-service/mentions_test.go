@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Mention
+	}{
+		{"none", "hello world", nil},
+		{"one", "hi @alice!", []Mention{{Username: "alice", Start: 3, End: 9}}},
+		{"offsets in code points", "héllo @Bob_1", []Mention{{Username: "Bob_1", Start: 6, End: 12}}},
+		{"several", "@alice and @bob_", []Mention{{Username: "alice", Start: 0, End: 6}, {Username: "bob_", Start: 11, End: 16}}},
+		{"email address", "mail me@alice.com", nil},
+		{"too short", "@al", nil},
+		{"too long", "@" + strings.Repeat("a", 31), nil},
+		{"bare at sign", "@ alice", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findMentions(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveMentions(t *testing.T) {
+	client := useFirestore(t)
+	reservations := map[string]map[string]interface{}{
+		"alice": {"uid": "u1", "username": "Alice", "released": false},
+		"bob":   {"uid": "u2", "username": "bob", "released": true},
+	}
+	for id, data := range reservations {
+		if _, err := client.Collection("usernames").Doc(id).Set(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mentions, err := resolveMentions(context.Background(), "@alice meet @bob and @carol, cc @ALICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mention{
+		{UID: "u1", Username: "Alice", Start: 0, End: 6},
+		{UID: "u1", Username: "Alice", Start: 32, End: 38},
+	}
+	if !reflect.DeepEqual(mentions, want) {
+		t.Errorf("mentions = %+v, want %+v", mentions, want)
+	}
+
+	if mentions, err := resolveMentions(context.Background(), "no mentions"); err != nil || mentions != nil {
+		t.Errorf("resolveMentions without mentions = %+v, %v", mentions, err)
+	}
+}
+
+func TestResolveMentionsLimit(t *testing.T) {
+	client := useFirestore(t)
+	var text []string
+	for i := 0; i <= maxMentionsPerPost; i++ {
+		username := fmt.Sprintf("user%02d", i)
+		data := map[string]interface{}{"uid": username, "username": username}
+		if _, err := client.Collection("usernames").Doc(username).Set(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+		text = append(text, "@"+username)
+	}
+
+	mentions, err := resolveMentions(context.Background(), strings.Join(text, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mentions) != maxMentionsPerPost {
+		t.Errorf("resolved %d mentions, want %d", len(mentions), maxMentionsPerPost)
+	}
+}
+
+func TestMentionedUIDs(t *testing.T) {
+	mentions := []Mention{{UID: "author"}, {UID: "u1"}, {UID: "u2"}, {UID: "u1"}, {UID: "u3"}}
+	previous := []Mention{{UID: "u2"}}
+
+	if got, want := mentionedUIDs(mentions, "author", nil), []string{"u1", "u2", "u3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mentionedUIDs = %v, want %v", got, want)
+	}
+	if got, want := mentionedUIDs(mentions, "author", previous), []string{"u1", "u3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mentionedUIDs after an edit = %v, want %v", got, want)
+	}
+}
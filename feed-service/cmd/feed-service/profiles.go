@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/svcauth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// profileRequestTimeout bounds calls to profile-service
+const profileRequestTimeout = 5 * time.Second
+
+var errProfileNotFound = errors.New("profile not found")
+
+// ProfileSummary is the author data denormalized onto posts, as served by
+// profile-service at /internal/profiles/{uid}/summary
+type ProfileSummary struct {
+	UID         string `firestore:"-" json:"uid"`
+	DisplayName string `firestore:"displayName" json:"displayName"`
+	Username    string `firestore:"username" json:"username,omitempty"`
+	PhotoURL    string `firestore:"photoUrl" json:"photoUrl,omitempty"`
+	Profession  string `firestore:"profession" json:"profession,omitempty"`
+	Disabled    bool   `firestore:"disabled" json:"disabled"`
+}
+
+var (
+	profileServiceURL string
+	profileClient     = svcauth.NewClient(profileRequestTimeout)
+)
+
+// getProfileSummary asks profile-service for uid's summary, or reads the
+// users document directly when service authentication is not configured
+func getProfileSummary(ctx context.Context, uid string) (ProfileSummary, error) {
+	if !svcauth.Enabled() {
+		return readProfileSummary(ctx, uid)
+	}
+
+	endpoint := profileServiceURL + "/internal/profiles/" + url.PathEscape(uid) + "/summary"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return ProfileSummary{}, err
+	}
+
+	resp, err := profileClient.Do(req)
+	if err != nil {
+		return ProfileSummary{}, fmt.Errorf("failed to call profile-service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ProfileSummary{}, errProfileNotFound
+	default:
+		return ProfileSummary{}, fmt.Errorf("profile-service returned %d", resp.StatusCode)
+	}
+
+	var summary ProfileSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return ProfileSummary{}, fmt.Errorf("failed to decode profile summary: %w", err)
+	}
+	return summary, nil
+}
+
+func readProfileSummary(ctx context.Context, uid string) (ProfileSummary, error) {
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ProfileSummary{}, errProfileNotFound
+		}
+		return ProfileSummary{}, err
+	}
+
+	var summary ProfileSummary
+	if err := doc.DataTo(&summary); err != nil {
+		return ProfileSummary{}, err
+	}
+	summary.UID = uid
+	return summary, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchQueryLen  = 200
+	maxHashtagLength   = 100
+)
+
+func searchPosts(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	v := httpx.NewValidator()
+	if v.Required("q", q) {
+		v.MaxLength("q", q, maxSearchQueryLen)
+	}
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	writeSearchResults(w, r, postSearchIndex.Search(q))
+}
+
+func getPostsByTag(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimPrefix(chi.URLParam(r, "tag"), "#")
+
+	v := httpx.NewValidator()
+	if v.Required("tag", tag) && v.MaxLength("tag", tag, maxHashtagLength) {
+		analysis := analyzeText(tag)
+		v.Check(len(analysis.Words) == 1 && analysis.Words[0] == strings.ToLower(tag),
+			"tag", httpx.CodeInvalidFormat, "tag may only contain letters, digits and underscores")
+	}
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	writeSearchResults(w, r, postSearchIndex.Tagged(tag))
+}
+
+// writeSearchResults filters matches to what the caller may see and writes the first page
+func writeSearchResults(w http.ResponseWriter, r *http.Request, matches []Post) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= maxSearchLimit {
+			limit = l
+		}
+	}
+
+	network, err := loadViewerNetwork(r.Context(), uid)
+	if err != nil {
+		log.Error("Failed to load viewer network", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to search posts")
+		return
+	}
+
+	posts := network.filter(matches)
+	if len(posts) > limit {
+		posts = posts[:limit]
+	}
+
+	httpx.Success(w, map[string]interface{}{
+		"posts": posts,
+		"count": len(posts),
+	})
+}
+
+// startPostIndexing loads every post into the search index and keeps it in
+// sync from post.created, post.updated and post.deleted events. The index is
+// per process, so every instance consumes every event.
+func startPostIndexing(ctx context.Context, conn *rabbitmq.Connection) error {
+	go func() {
+		if err := loadPostIndex(ctx); err != nil {
+			log.Error("Failed to load post index", zap.Error(err))
+			return
+		}
+		log.Info("Post index loaded", zap.Int("posts", postSearchIndex.Len()))
+	}()
+
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		PerInstance: true,
+		RoutingKeys: []string{"post.created", "post.updated", "post.deleted"},
+		Handler: func(body []byte) error {
+			var event struct {
+				PostID string `json:"postId"`
+			}
+			if err := json.Unmarshal(body, &event); err != nil || event.PostID == "" {
+				log.Error("Failed to parse post event", zap.Error(err))
+				return nil
+			}
+			return reindexPost(ctx, event.PostID)
+		},
+	})
+}
+
+func loadPostIndex(ctx context.Context) error {
+	iter := firestoredb.GetClient().Collection("posts").Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate posts: %w", err)
+		}
+
+		var post Post
+		if err := doc.DataTo(&post); err != nil {
+			log.Error("Failed to parse post", zap.Error(err))
+			continue
+		}
+		post.ID = doc.Ref.ID
+		postSearchIndex.Upsert(post)
+	}
+}
+
+// reindexPost reloads postID from Firestore, removing it from the index if it no longer exists.
+// Events are only used as a trigger so that redelivered or reordered events cannot
+// resurrect a deleted post.
+func reindexPost(ctx context.Context, postID string) error {
+	doc, err := firestoredb.GetClient().Collection("posts").Doc(postID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			postSearchIndex.Delete(postID)
+			return nil
+		}
+		return fmt.Errorf("failed to get post %s: %w", postID, err)
+	}
+
+	var post Post
+	if err := doc.DataTo(&post); err != nil {
+		return fmt.Errorf("failed to parse post %s: %w", postID, err)
+	}
+	post.ID = postID
+	postSearchIndex.Upsert(post)
+	return nil
+}
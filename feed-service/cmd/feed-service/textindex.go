@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TextAnalysis is the result of tokenizing post text. Hashtags and mentions
+// are lowercased and returned without their '#' or '@'.
+type TextAnalysis struct {
+	Words    []string
+	Hashtags []string
+	Mentions []string
+}
+
+// terms returns the index terms for the analysis: plain words, "#tag" and "@user"
+func (a TextAnalysis) terms() []string {
+	terms := make([]string, 0, len(a.Words)+len(a.Hashtags)+len(a.Mentions))
+	terms = append(terms, a.Words...)
+	for _, tag := range a.Hashtags {
+		terms = append(terms, "#"+tag)
+	}
+	for _, mention := range a.Mentions {
+		terms = append(terms, "@"+mention)
+	}
+	return terms
+}
+
+// analyzeText splits text into distinct words, hashtags and mentions. The
+// word of a hashtag is also indexed as a plain word so "#golang" matches "golang".
+func analyzeText(text string) TextAnalysis {
+	var analysis TextAnalysis
+	seen := map[string]struct{}{}
+	add := func(list *[]string, key, term string) {
+		if _, ok := seen[key]; ok {
+			return
+		}
+		seen[key] = struct{}{}
+		*list = append(*list, term)
+	}
+
+	runes := []rune(strings.ToLower(text))
+	for i := 0; i < len(runes); {
+		sigil := runes[i]
+		if sigil != '#' && sigil != '@' && !isTermRune(sigil) {
+			i++
+			continue
+		}
+		// A sigil inside a word, as in "a@b.com", does not start a hashtag or mention
+		if (sigil == '#' || sigil == '@') && i > 0 && isTermRune(runes[i-1]) {
+			i++
+			continue
+		}
+
+		start := i
+		if sigil == '#' || sigil == '@' {
+			start++
+		}
+		end := start
+		for end < len(runes) && isTermRune(runes[end]) {
+			end++
+		}
+		term := string(runes[start:end])
+
+		switch {
+		case term == "":
+			// Lone '#' or '@'
+			end = start
+		case sigil == '#':
+			add(&analysis.Hashtags, "#"+term, term)
+			add(&analysis.Words, term, term)
+		case sigil == '@':
+			add(&analysis.Mentions, "@"+term, term)
+		default:
+			add(&analysis.Words, term, term)
+		}
+		i = end
+	}
+
+	return analysis
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// postIndex is an in-process inverted index over post words, hashtags and mentions
+type postIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]Post
+	terms    map[string][]string
+	postings map[string]map[string]struct{}
+}
+
+func newPostIndex() *postIndex {
+	return &postIndex{
+		docs:     make(map[string]Post),
+		terms:    make(map[string][]string),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+var postSearchIndex = newPostIndex()
+
+// Upsert adds or replaces post in the index
+func (idx *postIndex) Upsert(post Post) {
+	terms := analyzeText(post.Text).terms()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.unindex(post.ID)
+	idx.docs[post.ID] = post
+	idx.terms[post.ID] = terms
+	for _, term := range terms {
+		postings, ok := idx.postings[term]
+		if !ok {
+			postings = make(map[string]struct{})
+			idx.postings[term] = postings
+		}
+		postings[post.ID] = struct{}{}
+	}
+}
+
+// Delete removes postID from the index
+func (idx *postIndex) Delete(postID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.unindex(postID)
+	delete(idx.docs, postID)
+}
+
+// Search returns posts containing every term of query, newest first. A
+// "#tag" term only matches the hashtag, not the plain word.
+func (idx *postIndex) Search(query string) []Post {
+	analysis := analyzeText(query)
+	hashtags := make(map[string]struct{}, len(analysis.Hashtags))
+	for _, tag := range analysis.Hashtags {
+		hashtags[tag] = struct{}{}
+	}
+
+	var terms []string
+	for _, term := range analysis.terms() {
+		if _, ok := hashtags[term]; !ok {
+			terms = append(terms, term)
+		}
+	}
+	return idx.match(terms)
+}
+
+// Tagged returns posts with hashtag tag, newest first
+func (idx *postIndex) Tagged(tag string) []Post {
+	return idx.match([]string{"#" + strings.ToLower(tag)})
+}
+
+// Len returns the number of indexed posts
+func (idx *postIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// match intersects the posting lists of terms
+func (idx *postIndex) match(terms []string) []Post {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Start from the rarest term to keep the intersection small
+	sort.Slice(terms, func(i, j int) bool {
+		return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]])
+	})
+
+	var results []Post
+	for postID := range idx.postings[terms[0]] {
+		matched := true
+		for _, term := range terms[1:] {
+			if _, ok := idx.postings[term][postID]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			results = append(results, idx.docs[postID])
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	return results
+}
+
+// unindex removes postID's postings. Caller must hold idx.mu.
+func (idx *postIndex) unindex(postID string) {
+	for _, term := range idx.terms[postID] {
+		if postings, ok := idx.postings[term]; ok {
+			delete(postings, postID)
+			if len(postings) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	delete(idx.terms, postID)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want TextAnalysis
+	}{
+		{
+			name: "empty",
+			text: "",
+			want: TextAnalysis{},
+		},
+		{
+			name: "words are lowercased and deduplicated",
+			text: "Hello, hello WORLD!",
+			want: TextAnalysis{Words: []string{"hello", "world"}},
+		},
+		{
+			name: "hashtag is also a word",
+			text: "Learning #GoLang today",
+			want: TextAnalysis{Words: []string{"learning", "golang", "today"}, Hashtags: []string{"golang"}},
+		},
+		{
+			name: "mention is not a word",
+			text: "thanks @Ada_L",
+			want: TextAnalysis{Words: []string{"thanks"}, Mentions: []string{"ada_l"}},
+		},
+		{
+			name: "sigil inside a word",
+			text: "mail a@b.com or c#d",
+			want: TextAnalysis{Words: []string{"mail", "a", "b", "com", "or", "c", "d"}},
+		},
+		{
+			name: "lone sigils",
+			text: "# @ ## @@x",
+			want: TextAnalysis{Mentions: []string{"x"}},
+		},
+		{
+			name: "repeated hashtags and mentions",
+			text: "#go #Go @ada @ADA",
+			want: TextAnalysis{Words: []string{"go"}, Hashtags: []string{"go"}, Mentions: []string{"ada"}},
+		},
+		{
+			name: "unicode letters and digits",
+			text: "Café 2024 #über",
+			want: TextAnalysis{Words: []string{"café", "2024", "über"}, Hashtags: []string{"über"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzeText(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("analyzeText(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextAnalysisTerms(t *testing.T) {
+	got := analyzeText("#go with @ada").terms()
+	want := []string{"go", "with", "#go", "@ada"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("terms = %v, want %v", got, want)
+	}
+}
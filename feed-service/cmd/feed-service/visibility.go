@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trustlink/common/firestoredb"
+	"google.golang.org/api/iterator"
+)
+
+// Post visibility values
+const (
+	VisibilityPublic      = "public"
+	VisibilityConnections = "connections"
+)
+
+// viewerNetwork holds the relationships that decide which posts a user can see
+type viewerNetwork struct {
+	uid       string
+	connected map[string]struct{}
+	// blocked holds users with a block in either direction
+	blocked map[string]struct{}
+}
+
+// loadViewerNetwork reads uid's accepted connections and blocks
+func loadViewerNetwork(ctx context.Context, uid string) (*viewerNetwork, error) {
+	network := &viewerNetwork{
+		uid:       uid,
+		connected: map[string]struct{}{},
+		blocked:   map[string]struct{}{},
+	}
+
+	client := firestoredb.GetClient()
+	for _, field := range []string{"fromUid", "toUid"} {
+		other := "toUid"
+		if field == "toUid" {
+			other = "fromUid"
+		}
+
+		iter := client.Collection("relationships").
+			Where(field, "==", uid).
+			Where("status", "in", []string{"accepted", "blocked"}).
+			Documents(ctx)
+
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, fmt.Errorf("failed to iterate relationships: %w", err)
+			}
+
+			data := doc.Data()
+			otherUID, _ := data[other].(string)
+			if otherUID == "" {
+				continue
+			}
+			if data["status"] == "blocked" {
+				network.blocked[otherUID] = struct{}{}
+			} else {
+				network.connected[otherUID] = struct{}{}
+			}
+		}
+		iter.Stop()
+	}
+
+	return network, nil
+}
+
+// canView reports whether the viewer may see post
+func (n *viewerNetwork) canView(post Post) bool {
+	if post.AuthorUID == n.uid {
+		return true
+	}
+	if _, ok := n.blocked[post.AuthorUID]; ok {
+		return false
+	}
+	if post.Visibility == VisibilityConnections {
+		_, ok := n.connected[post.AuthorUID]
+		return ok
+	}
+	return true
+}
+
+// filter returns the posts the viewer may see
+func (n *viewerNetwork) filter(posts []Post) []Post {
+	visible := []Post{}
+	for _, post := range posts {
+		if n.canView(post) {
+			visible = append(visible, post)
+		}
+	}
+	return visible
+}
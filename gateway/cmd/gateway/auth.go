@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// identitySecret signs identities forwarded to services. When empty, services
+// must run with AUTH_MODE=firebase and verify tokens themselves.
+var identitySecret []byte
+
+// anonymous forwards requests on routes without authentication, dropping any
+// identity supplied by the client
+func anonymous(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(authmw.IdentityHeader)
+		r.Header.Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate verifies the caller's Firebase ID token once and forwards a
+// signed identity header to the services behind the gateway
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never forward an identity supplied by the client
+		r.Header.Del(authmw.IdentityHeader)
+		r.Header.Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+
+		// API keys are verified by the services that accept them
+		if r.Header.Get(authmw.APIKeyHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, authErr := authmw.VerifyTokenRequest(r)
+		if authErr != nil {
+			log.Warn("Authentication failed", zap.Error(authErr), zap.String("path", r.URL.Path))
+			authmw.WriteAuthError(w, authErr)
+			return
+		}
+		id.RequestID = middleware.GetReqID(r.Context())
+
+		if len(identitySecret) > 0 {
+			header, err := authmw.SignIdentity(id, identitySecret)
+			if err != nil {
+				log.Error("Failed to sign identity", zap.Error(err))
+				httpx.InternalServerError(w, "Failed to forward identity")
+				return
+			}
+			r.Header.Set(authmw.IdentityHeader, header)
+		}
+		next.ServeHTTP(w, r.WithContext(authmw.WithIdentity(r.Context(), id)))
+	})
+}
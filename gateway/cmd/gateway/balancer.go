@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/trustlink/common/authmw"
+)
+
+// Route load balancing strategies
+const (
+	// BalancerRoundRobin sends requests to each upstream in turn
+	BalancerRoundRobin = "round_robin"
+	// BalancerLeastConnections sends requests to the upstream with the fewest requests in flight
+	BalancerLeastConnections = "least_connections"
+	// BalancerConsistentHash sends each user's requests to the same upstream while it is available
+	BalancerConsistentHash = "consistent_hash"
+)
+
+// hashRingReplicas is how many points each upstream has on the hash ring
+const hashRingReplicas = 100
+
+// balancer chooses an upstream from candidates, which is never empty
+type balancer interface {
+	pick(r *http.Request, candidates []*upstream) *upstream
+}
+
+func newBalancer(name string, upstreams []*upstream) balancer {
+	switch name {
+	case BalancerLeastConnections:
+		return &leastConnections{}
+	case BalancerConsistentHash:
+		return newConsistentHash(upstreams)
+	default:
+		return &roundRobin{}
+	}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobin) pick(r *http.Request, candidates []*upstream) *upstream {
+	i := b.next.Add(1) - 1
+	return candidates[i%uint64(len(candidates))]
+}
+
+// leastConnections breaks ties in turn so idle upstreams share the load
+type leastConnections struct {
+	next atomic.Uint64
+}
+
+func (b *leastConnections) pick(r *http.Request, candidates []*upstream) *upstream {
+	start := b.next.Add(1) - 1
+	n := uint64(len(candidates))
+
+	var best *upstream
+	for i := uint64(0); i < n; i++ {
+		u := candidates[(start+i)%n]
+		if best == nil || u.active.Load() < best.active.Load() {
+			best = u
+		}
+	}
+	return best
+}
+
+type ringPoint struct {
+	hash     uint32
+	upstream *upstream
+}
+
+// consistentHash hashes the caller's uid, or their IP for anonymous routes,
+// onto a ring of upstreams. When an upstream is unavailable its users move
+// to the next upstream on the ring and the others stay where they are.
+type consistentHash struct {
+	ring []ringPoint
+}
+
+func newConsistentHash(upstreams []*upstream) *consistentHash {
+	b := &consistentHash{}
+	for _, u := range upstreams {
+		for i := 0; i < hashRingReplicas; i++ {
+			b.ring = append(b.ring, ringPoint{
+				hash:     ringHash(u.url + "#" + strconv.Itoa(i)),
+				upstream: u,
+			})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return b
+}
+
+// ringHash spreads similar keys, like sequential upstream names, evenly around the ring
+func ringHash(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (b *consistentHash) pick(r *http.Request, candidates []*upstream) *upstream {
+	key, ok := authmw.GetUserID(r.Context())
+	if !ok || key == "" {
+		key = clientIP(r)
+	}
+	h := ringHash(key)
+
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		u := b.ring[(start+i)%len(b.ring)].upstream
+		for _, c := range candidates {
+			if c == u {
+				return u
+			}
+		}
+	}
+	return candidates[0]
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/authmw"
+)
+
+func testUpstreams(n int) []*upstream {
+	upstreams := make([]*upstream, n)
+	for i := range upstreams {
+		upstreams[i] = &upstream{url: "http://service-" + strconv.Itoa(i+1) + ":8080"}
+	}
+	return upstreams
+}
+
+func requestFrom(uid string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if uid != "" {
+		r = r.WithContext(authmw.WithIdentity(r.Context(), authmw.Identity{UID: uid}))
+	}
+	return r
+}
+
+func TestNewBalancer(t *testing.T) {
+	upstreams := testUpstreams(2)
+	if _, ok := newBalancer("", upstreams).(*roundRobin); !ok {
+		t.Error("default balancer is not round robin")
+	}
+	if _, ok := newBalancer(BalancerLeastConnections, upstreams).(*leastConnections); !ok {
+		t.Error("least_connections balancer not created")
+	}
+	if _, ok := newBalancer(BalancerConsistentHash, upstreams).(*consistentHash); !ok {
+		t.Error("consistent_hash balancer not created")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	upstreams := testUpstreams(3)
+	b := &roundRobin{}
+
+	for i := 0; i < 6; i++ {
+		if got := b.pick(requestFrom(""), upstreams); got != upstreams[i%3] {
+			t.Fatalf("pick %d = %s, want %s", i, got.url, upstreams[i%3].url)
+		}
+	}
+
+	// Fewer candidates when some upstreams are unavailable
+	if got := b.pick(requestFrom(""), upstreams[:1]); got != upstreams[0] {
+		t.Errorf("pick from one candidate = %s", got.url)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	upstreams := testUpstreams(3)
+	upstreams[0].active.Store(5)
+	upstreams[1].active.Store(2)
+	upstreams[2].active.Store(7)
+	b := &leastConnections{}
+
+	for i := 0; i < 3; i++ {
+		if got := b.pick(requestFrom(""), upstreams); got != upstreams[1] {
+			t.Fatalf("pick = %s, want the least busy %s", got.url, upstreams[1].url)
+		}
+	}
+
+	// Ties are shared in turn
+	for _, u := range upstreams {
+		u.active.Store(0)
+	}
+	counts := map[*upstream]int{}
+	for i := 0; i < 30; i++ {
+		counts[b.pick(requestFrom(""), upstreams)]++
+	}
+	for _, u := range upstreams {
+		if counts[u] != 10 {
+			t.Errorf("%s picked %d of 30 times with equal load, want 10", u.url, counts[u])
+		}
+	}
+}
+
+func TestConsistentHashIsSticky(t *testing.T) {
+	upstreams := testUpstreams(3)
+	b := newConsistentHash(upstreams)
+
+	for i := 0; i < 100; i++ {
+		uid := "user-" + strconv.Itoa(i)
+		first := b.pick(requestFrom(uid), upstreams)
+		for j := 0; j < 3; j++ {
+			if got := b.pick(requestFrom(uid), upstreams); got != first {
+				t.Fatalf("%s moved from %s to %s", uid, first.url, got.url)
+			}
+		}
+	}
+
+	// Anonymous callers are keyed by IP
+	r1, r2 := requestFrom(""), requestFrom("")
+	r1.RemoteAddr, r2.RemoteAddr = "203.0.113.7:1234", "203.0.113.7:5678"
+	if b.pick(r1, upstreams) != b.pick(r2, upstreams) {
+		t.Error("requests from one IP went to different upstreams")
+	}
+}
+
+func TestConsistentHashSpreadsUsers(t *testing.T) {
+	upstreams := testUpstreams(3)
+	b := newConsistentHash(upstreams)
+
+	const users = 3000
+	counts := map[*upstream]int{}
+	for i := 0; i < users; i++ {
+		counts[b.pick(requestFrom("user-"+strconv.Itoa(i)), upstreams)]++
+	}
+	for _, u := range upstreams {
+		if share := float64(counts[u]) / users; share < 0.2 || share > 0.47 {
+			t.Errorf("%s got %.0f%% of users", u.url, share*100)
+		}
+	}
+}
+
+func TestConsistentHashMovesOnlyUnavailableUsers(t *testing.T) {
+	upstreams := testUpstreams(3)
+	b := newConsistentHash(upstreams)
+	down := upstreams[1]
+	available := []*upstream{upstreams[0], upstreams[2]}
+
+	for i := 0; i < 1000; i++ {
+		r := requestFrom("user-" + strconv.Itoa(i))
+		before := b.pick(r, upstreams)
+		after := b.pick(r, available)
+
+		if after == down {
+			t.Fatalf("user-%d sent to the unavailable upstream", i)
+		}
+		if before != down && after != before {
+			t.Fatalf("user-%d moved from %s to %s though its upstream is available", i, before.url, after.url)
+		}
+	}
+}
+
+// recordResults adds successes and failures to u's current outlier interval
+func recordResults(u *upstream, successes, failures int) {
+	u.outlier.successes.Add(int64(successes))
+	u.outlier.failures.Add(int64(failures))
+}
+
+func TestEjectOutliers(t *testing.T) {
+	upstreams := testUpstreams(4)
+	pool := &upstreamPool{upstreams: upstreams}
+	now := time.Now()
+
+	recordResults(upstreams[0], 2, 8)
+	recordResults(upstreams[1], 3, 7)
+	recordResults(upstreams[2], 0, 9) // too few requests to judge
+	recordResults(upstreams[3], 10, 0)
+	pool.ejectOutliers(now)
+
+	if !upstreams[0].outlier.ejected(now) || !upstreams[1].outlier.ejected(now) {
+		t.Fatal("failing upstreams not ejected")
+	}
+	if upstreams[2].outlier.ejected(now) || upstreams[3].outlier.ejected(now) {
+		t.Error("upstream ejected without enough failures")
+	}
+	if !upstreams[0].outlier.ejected(now.Add(outlierBaseEjection-time.Second)) || upstreams[0].outlier.ejected(now.Add(outlierBaseEjection)) {
+		t.Errorf("first ejection does not last %s", outlierBaseEjection)
+	}
+
+	// At most half the upstreams are ejected at once
+	recordResults(upstreams[2], 0, 10)
+	pool.ejectOutliers(now.Add(time.Second))
+	if upstreams[2].outlier.ejected(now.Add(time.Second)) {
+		t.Error("ejected more than half the upstreams")
+	}
+
+	// Repeat offenders stay out for longer
+	later := now.Add(outlierBaseEjection)
+	recordResults(upstreams[0], 0, 10)
+	pool.ejectOutliers(later)
+	if !upstreams[0].outlier.ejected(later.Add(2*outlierBaseEjection - time.Second)) {
+		t.Errorf("second ejection shorter than %s", 2*outlierBaseEjection)
+	}
+}
+
+func TestServeHealth(t *testing.T) {
+	router, err := newGatewayRouter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(router.table.Load().close)
+
+	posts := router.table.Load().match("/v1/posts")
+	openBreaker(&posts.pool.upstreams[0].breaker, time.Now())
+
+	// /healthz names upstreams after their route instead of their address
+	w := httptest.NewRecorder()
+	router.serveHealth(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if strings.Contains(w.Body.String(), "http") {
+		t.Errorf("/healthz leaks upstream addresses: %s", w.Body)
+	}
+	var summary PublicHealth
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || summary.Status != healthDegraded || len(summary.Routes) == 0 {
+		t.Fatalf("/healthz = %d %+v, want 200 degraded with every route", w.Code, summary)
+	}
+	for _, rh := range summary.Routes {
+		wantStatus, wantState := healthOK, "up"
+		if rh.Name == "posts" {
+			wantStatus, wantState = healthDown, "open"
+		}
+		if rh.Status != wantStatus || len(rh.Upstreams) != 1 {
+			t.Errorf("route %s = %+v, want %s with one upstream", rh.Name, rh, wantStatus)
+			continue
+		}
+		if u := rh.Upstreams[0]; u.Name != rh.Name+"-1" || u.State != wantState {
+			t.Errorf("route %s upstream = %+v, want %s-1 %s", rh.Name, u, rh.Name, wantState)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.serveHealthDetail(w, httptest.NewRequest(http.MethodGet, "/v1/admin/gateway/health", nil))
+	var health GatewayHealth
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != healthDegraded || len(health.Routes) == 0 {
+		t.Fatalf("detail = %+v, want degraded with every route", health)
+	}
+	for _, rh := range health.Routes {
+		want := healthOK
+		if rh.Name == "posts" {
+			want = healthDown
+		}
+		if rh.Status != want {
+			t.Errorf("route %s is %s, want %s", rh.Name, rh.Status, want)
+		}
+	}
+}
+
+func TestUpstreamHealthState(t *testing.T) {
+	tests := []struct {
+		health UpstreamHealth
+		want   string
+	}{
+		{UpstreamHealth{Healthy: true, Circuit: "closed"}, "up"},
+		{UpstreamHealth{Healthy: true, Circuit: "open", Ejected: true}, "open"},
+		{UpstreamHealth{Healthy: true, Circuit: "half_open"}, "half_open"},
+		{UpstreamHealth{Healthy: true, Circuit: "closed", Ejected: true}, "ejected"},
+		{UpstreamHealth{Circuit: "closed"}, "unhealthy"},
+	}
+	for _, tt := range tests {
+		if got := tt.health.state(); got != tt.want {
+			t.Errorf("state of %+v = %s, want %s", tt.health, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trustlink/common/httpx"
+)
+
+// Route and gateway health states
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// GatewayHealth is the admin gateway health response
+type GatewayHealth struct {
+	Status string        `json:"status"`
+	Routes []RouteHealth `json:"routes"`
+}
+
+// RouteHealth is ok when every upstream is up, degraded when some are and
+// down when none are
+type RouteHealth struct {
+	Name      string           `json:"name"`
+	Prefix    string           `json:"prefix"`
+	Balancer  string           `json:"balancer"`
+	Status    string           `json:"status"`
+	Upstreams []UpstreamHealth `json:"upstreams"`
+}
+
+// UpstreamHealth is one upstream's state. It is up when its health check
+// passes, its circuit is closed and it is not ejected.
+type UpstreamHealth struct {
+	URL            string     `json:"url"`
+	Healthy        bool       `json:"healthy"`
+	Circuit        string     `json:"circuit"`
+	Ejected        bool       `json:"ejected"`
+	EjectedUntil   *time.Time `json:"ejectedUntil,omitempty"`
+	ActiveRequests int64      `json:"activeRequests"`
+}
+
+func (h UpstreamHealth) up() bool {
+	return h.Healthy && h.Circuit == "closed" && !h.Ejected
+}
+
+// state summarizes h as up, or the first reason it is not: its circuit
+// state (open or half_open), ejected or unhealthy
+func (h UpstreamHealth) state() string {
+	switch {
+	case h.Circuit != "closed":
+		return h.Circuit
+	case h.Ejected:
+		return "ejected"
+	case !h.Healthy:
+		return "unhealthy"
+	default:
+		return "up"
+	}
+}
+
+// PublicHealth is the /healthz response. Upstreams are named after their
+// route and position rather than their internal address.
+type PublicHealth struct {
+	Status string              `json:"status"`
+	Routes []PublicRouteHealth `json:"routes"`
+}
+
+// PublicRouteHealth is a route's status and the state of each upstream
+type PublicRouteHealth struct {
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"`
+	Upstreams []PublicUpstreamHealth `json:"upstreams"`
+}
+
+// PublicUpstreamHealth is an upstream's state under a redacted name
+type PublicUpstreamHealth struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// public strips h of upstream addresses and details
+func (h GatewayHealth) public() PublicHealth {
+	health := PublicHealth{Status: h.Status, Routes: make([]PublicRouteHealth, len(h.Routes))}
+	for i, rh := range h.Routes {
+		route := PublicRouteHealth{Name: rh.Name, Status: rh.Status, Upstreams: make([]PublicUpstreamHealth, len(rh.Upstreams))}
+		for j, uh := range rh.Upstreams {
+			route.Upstreams[j] = PublicUpstreamHealth{
+				Name:  fmt.Sprintf("%s-%d", rh.Name, j+1),
+				State: uh.state(),
+			}
+		}
+		health.Routes[i] = route
+	}
+	return health
+}
+
+func (u *upstream) health(now time.Time) UpstreamHealth {
+	h := UpstreamHealth{
+		URL:            u.target.Redacted(),
+		Healthy:        u.healthy.Load(),
+		Circuit:        u.breaker.stateName(),
+		ActiveRequests: u.active.Load(),
+	}
+	if until := u.outlier.ejectedUntilTime(now); !until.IsZero() {
+		h.Ejected = true
+		h.EjectedUntil = &until
+	}
+	return h
+}
+
+func (rt *gatewayRoute) health(now time.Time) RouteHealth {
+	h := RouteHealth{
+		Name:     rt.config.Name,
+		Prefix:   rt.config.Prefix,
+		Balancer: rt.config.Balancer,
+	}
+	if h.Balancer == "" {
+		h.Balancer = BalancerRoundRobin
+	}
+
+	up := 0
+	for _, u := range rt.pool.upstreams {
+		uh := u.health(now)
+		if uh.up() {
+			up++
+		}
+		h.Upstreams = append(h.Upstreams, uh)
+	}
+
+	switch up {
+	case len(h.Upstreams):
+		h.Status = healthOK
+	case 0:
+		h.Status = healthDown
+	default:
+		h.Status = healthDegraded
+	}
+	return h
+}
+
+// serveHealth reports the state of every route and upstream without upstream
+// addresses. It responds 200 even when upstreams are down, since the gateway
+// itself is still serving.
+func (g *gatewayRouter) serveHealth(w http.ResponseWriter, r *http.Request) {
+	httpx.Success(w, g.health(time.Now()).public())
+}
+
+// serveHealthDetail reports the state of every route and upstream. Upstream
+// addresses are internal, so it is only mounted behind admin authentication.
+func (g *gatewayRouter) serveHealthDetail(w http.ResponseWriter, r *http.Request) {
+	httpx.Success(w, g.health(time.Now()))
+}
+
+func (g *gatewayRouter) health(now time.Time) GatewayHealth {
+	health := GatewayHealth{Status: healthOK, Routes: []RouteHealth{}}
+	for _, rt := range g.table.Load().routes {
+		rh := rt.health(now)
+		if rh.Status != healthOK {
+			health.Status = healthDegraded
+		}
+		health.Routes = append(health.Routes, rh)
+	}
+	return health
+}
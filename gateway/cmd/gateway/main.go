@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -14,9 +13,9 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/trustlink/common/authmw"
 	"github.com/trustlink/common/firebaseapp"
 	"github.com/trustlink/common/firestoredb"
-	"github.com/trustlink/common/httpx"
 	"github.com/trustlink/common/log"
 	"go.uber.org/zap"
 )
@@ -38,6 +37,11 @@ func main() {
 	}
 	log.Info("Firebase initialized successfully")
 
+	// Initialize auth middleware
+	if err := authmw.Initialize(); err != nil {
+		log.Fatal("Failed to initialize auth middleware", zap.Error(err))
+	}
+
 	// Initialize Firestore
 	if err := firestoredb.Initialize(ctx); err != nil {
 		log.Fatal("Failed to initialize Firestore", zap.Error(err))
@@ -45,6 +49,14 @@ func main() {
 	defer firestoredb.Close()
 	log.Info("Firestore initialized successfully")
 
+	// Identities forwarded to services are signed with a shared secret
+	identitySecret = []byte(getEnv("GATEWAY_IDENTITY_SECRET", ""))
+	if len(identitySecret) == 0 {
+		log.Warn("GATEWAY_IDENTITY_SECRET not set, services must verify tokens themselves")
+	}
+
+	configureRateLimits()
+
 	// Setup router
 	r := chi.NewRouter()
 
@@ -53,7 +65,7 @@ func main() {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(maxRouteTimeout))
 
 	// CORS is handled by Caddy reverse proxy in production
 	// Uncomment for local development without Caddy
@@ -66,22 +78,22 @@ func main() {
 	// 	MaxAge:           300,
 	// }))
 
+	// Service proxy routes, from GATEWAY_ROUTES_FILE or the default table.
+	// Send SIGHUP to reload the file.
+	routes, err := newGatewayRouter(getEnv("GATEWAY_ROUTES_FILE", ""))
+	if err != nil {
+		log.Fatal("Failed to load routes", zap.Error(err))
+	}
+	routes.reloadOnSIGHUP()
+
 	// Health check
-	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		httpx.Success(w, map[string]string{"status": "ok"})
-	})
+	r.Get("/healthz", routes.serveHealth)
 
-	// Service proxy routes
-	profileURL := getServiceURL("PROFILE_SERVICE_URL", "http://localhost:8081")
-	feedURL := getServiceURL("FEED_SERVICE_URL", "http://localhost:8082")
-	connectionsURL := getServiceURL("CONNECTIONS_SERVICE_URL", "http://localhost:8083")
+	// State of every route and upstream, for admins
+	r.With(authenticate, authmw.RequireRole(authmw.RoleAdmin)).
+		Get("/v1/admin/gateway/health", routes.serveHealthDetail)
 
-	r.Route("/v1", func(r chi.Router) {
-		r.Handle("/profile/*", createProxy(profileURL))
-		r.Handle("/posts/*", createProxy(feedURL))
-		r.Handle("/posts", createProxy(feedURL))
-		r.Handle("/connections/*", createProxy(connectionsURL))
-	})
+	r.Handle("/*", routes)
 
 	// Start server
 	port := getEnv("PORT", "8080")
@@ -89,7 +101,7 @@ func main() {
 		Addr:         ":" + port,
 		Handler:      r,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: serverWriteTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -118,20 +130,23 @@ func main() {
 	log.Info("Gateway stopped")
 }
 
-func createProxy(targetURL string) http.Handler {
-	target, _ := url.Parse(targetURL)
+func createProxy(u *upstream) http.Handler {
+	target := u.target
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = u.recordResponse
+	proxy.ErrorHandler = u.handleError
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Don't strip prefix, just forward the request as-is
+		r = r.Clone(r.Context())
+		r.Header.Set("X-Forwarded-Host", r.Host)
 		r.URL.Host = target.Host
 		r.URL.Scheme = target.Scheme
-		r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
 		r.Host = target.Host
 
 		log.Debug("Proxying request",
 			zap.String("original_path", r.URL.Path),
-			zap.String("target", targetURL))
+			zap.String("target", u.url))
 
 		proxy.ServeHTTP(w, r)
 	})
@@ -144,8 +159,9 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-func getServiceURL(envKey, fallback string) string {
-	return getEnv(envKey, fallback)
+// getServiceURLs reads a comma-separated list of service instance URLs
+func getServiceURLs(envKey, fallback string) []string {
+	return splitURLs(getEnv(envKey, fallback))
 }
 
 func getAllowedOrigins() []string {
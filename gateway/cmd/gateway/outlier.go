@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// outlierInterval is how often upstream error rates are compared
+	outlierInterval = 10 * time.Second
+	// outlierMinRequests is how many requests an upstream needs in an interval to be judged
+	outlierMinRequests = 10
+	// outlierFailureRate is the share of failed requests that ejects an upstream
+	outlierFailureRate = 0.5
+	// outlierMaxEjectedPercent keeps most of a route's upstreams in rotation
+	outlierMaxEjectedPercent = 50
+	// outlierBaseEjection is doubled each time the same upstream is ejected again
+	outlierBaseEjection = 30 * time.Second
+	outlierMaxEjection  = 5 * time.Minute
+)
+
+// outlierStats counts an upstream's results for the current interval and
+// whether it is ejected from load balancing
+type outlierStats struct {
+	successes atomic.Int64
+	failures  atomic.Int64
+
+	mu           sync.Mutex
+	ejectedUntil time.Time
+	ejections    int
+}
+
+func (s *outlierStats) success() {
+	s.successes.Add(1)
+}
+
+func (s *outlierStats) failure() {
+	s.failures.Add(1)
+}
+
+func (s *outlierStats) ejected(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return now.Before(s.ejectedUntil)
+}
+
+// ejectedUntilTime returns when the ejection ends, or the zero time if the upstream is not ejected
+func (s *outlierStats) ejectedUntilTime(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Before(s.ejectedUntil) {
+		return s.ejectedUntil
+	}
+	return time.Time{}
+}
+
+// detectOutliers ejects upstreams with a high failure rate every
+// outlierInterval until ctx is cancelled
+func (p *upstreamPool) detectOutliers(ctx context.Context) {
+	ticker := time.NewTicker(outlierInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.ejectOutliers(now)
+		}
+	}
+}
+
+func (p *upstreamPool) ejectOutliers(now time.Time) {
+	maxEjected := len(p.upstreams) * outlierMaxEjectedPercent / 100
+	ejected := 0
+	for _, u := range p.upstreams {
+		if u.outlier.ejected(now) {
+			ejected++
+		}
+	}
+
+	for _, u := range p.upstreams {
+		successes := u.outlier.successes.Swap(0)
+		failures := u.outlier.failures.Swap(0)
+		total := successes + failures
+		if total < outlierMinRequests {
+			continue
+		}
+		if float64(failures)/float64(total) < outlierFailureRate {
+			// A good interval forgives earlier ejections
+			if !u.outlier.ejected(now) {
+				u.outlier.mu.Lock()
+				u.outlier.ejections = 0
+				u.outlier.mu.Unlock()
+			}
+			continue
+		}
+		if u.outlier.ejected(now) || ejected >= maxEjected {
+			continue
+		}
+
+		u.outlier.mu.Lock()
+		u.outlier.ejections++
+		duration := outlierBaseEjection << (u.outlier.ejections - 1)
+		if duration > outlierMaxEjection || duration <= 0 {
+			duration = outlierMaxEjection
+		}
+		u.outlier.ejectedUntil = now.Add(duration)
+		u.outlier.mu.Unlock()
+		ejected++
+
+		log.Warn("Upstream ejected",
+			zap.String("upstream", u.url),
+			zap.Int64("failures", failures),
+			zap.Int64("requests", total),
+			zap.Duration("duration", duration))
+	}
+}
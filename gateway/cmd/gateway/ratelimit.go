@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// rateLimitPolicy limits requests matching method and path, per user and per client IP
+type rateLimitPolicy struct {
+	name   string
+	method string
+	path   string
+	user   Limit
+	ip     Limit
+}
+
+// defaultRateLimitPolicy applies to every route without its own rateLimit,
+// on top of any endpoint policy
+var defaultRateLimitPolicy = rateLimitPolicy{
+	name: "default",
+	user: Limit{Requests: 300, Per: time.Minute},
+	ip:   Limit{Requests: 600, Per: time.Minute},
+}
+
+// endpointRateLimitPolicies are stricter limits for expensive or abusable endpoints
+var endpointRateLimitPolicies = []rateLimitPolicy{
+	{
+		name:   "create_post",
+		method: http.MethodPost,
+		path:   "/v1/posts",
+		user:   Limit{Requests: 10, Per: time.Minute},
+		ip:     Limit{Requests: 30, Per: time.Minute},
+	},
+	{
+		name:   "connection_request",
+		method: http.MethodPost,
+		path:   "/v1/connections/request",
+		user:   Limit{Requests: 10, Per: time.Minute},
+		ip:     Limit{Requests: 30, Per: time.Minute},
+	},
+}
+
+// rateLimitStore holds the buckets. Replace it with a redisStore to share
+// buckets between gateway instances.
+var rateLimitStore RateLimitStore = newMemoryStore()
+
+// configureRateLimits applies RATE_LIMIT_USER_PER_MINUTE and RATE_LIMIT_IP_PER_MINUTE
+// to the default policy
+func configureRateLimits() {
+	if n, err := strconv.Atoi(getEnv("RATE_LIMIT_USER_PER_MINUTE", "")); err == nil && n > 0 {
+		defaultRateLimitPolicy.user = Limit{Requests: n, Per: time.Minute}
+	}
+	if n, err := strconv.Atoi(getEnv("RATE_LIMIT_IP_PER_MINUTE", "")); err == nil && n > 0 {
+		defaultRateLimitPolicy.ip = Limit{Requests: n, Per: time.Minute}
+	}
+	log.Info("Rate limits configured",
+		zap.Int("userPerMinute", defaultRateLimitPolicy.user.Requests),
+		zap.Int("ipPerMinute", defaultRateLimitPolicy.ip.Requests))
+}
+
+// matchingPolicies returns the policies that apply to r, endpoint policy
+// first, then the route's base policy
+func matchingPolicies(r *http.Request, base rateLimitPolicy) []rateLimitPolicy {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	for _, p := range endpointRateLimitPolicies {
+		if p.method == r.Method && p.path == path {
+			return []rateLimitPolicy{p, base}
+		}
+	}
+	return []rateLimitPolicy{base}
+}
+
+// limitByIP rate limits requests per client IP, as set by middleware.RealIP.
+// It runs before authentication so invalid tokens are throttled too.
+func limitByIP(base rateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			if !takeRateLimit(w, r, "ip:"+ip, base, func(p rateLimitPolicy) Limit { return p.ip }) {
+				log.Warn("IP rate limited", zap.String("ip", ip), zap.String("path", r.URL.Path))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitByUser rate limits requests per authenticated uid. API key requests
+// are limited per key by the services instead.
+func limitByUser(base rateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, ok := authmw.GetUserID(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !takeRateLimit(w, r, "user:"+uid, base, func(p rateLimitPolicy) Limit { return p.user }) {
+				log.Warn("User rate limited", zap.String("uid", uid), zap.String("path", r.URL.Path))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// takeRateLimit takes a token from subject's bucket for every matching policy
+// and sets RateLimit-* headers from the most constrained one. When any bucket
+// is empty no token is taken, it writes a 429 and returns false. Store errors
+// let the request through.
+func takeRateLimit(w http.ResponseWriter, r *http.Request, subject string, base rateLimitPolicy, limitOf func(rateLimitPolicy) Limit) bool {
+	policies := matchingPolicies(r, base)
+	buckets := make([]Bucket, len(policies))
+	for i, p := range policies {
+		buckets[i] = Bucket{Key: subject + ":" + p.name, Limit: limitOf(p)}
+	}
+
+	results, err := rateLimitStore.Take(r.Context(), buckets, time.Now())
+	if err != nil {
+		log.Error("Failed to check rate limit", zap.String("subject", subject), zap.Error(err))
+		return true
+	}
+
+	var tightest, denied *RateLimitResult
+	for i := range results {
+		result := &results[i]
+		if !result.Allowed && (denied == nil || result.RetryAfter > denied.RetryAfter) {
+			denied = result
+		}
+		if tightest == nil || result.Remaining < tightest.Remaining {
+			tightest = result
+		}
+	}
+
+	if denied != nil {
+		setRateLimitHeaders(w, *denied)
+		httpx.TooManyRequests(w, "Too many requests, please try again later", denied.RetryAfter)
+		return false
+	}
+	if tightest != nil {
+		setRateLimitHeaders(w, *tightest)
+	}
+	return true
+}
+
+// setRateLimitHeaders writes the RateLimit-Limit, -Remaining and -Reset headers
+func setRateLimitHeaders(w http.ResponseWriter, result RateLimitResult) {
+	reset := int(result.ResetAfter.Round(time.Second) / time.Second)
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+}
+
+// clientIP returns the IP in r.RemoteAddr without its port
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
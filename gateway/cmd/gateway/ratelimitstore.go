@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Per, with bursts of up to Requests
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// rate is the number of tokens added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Bucket names a token bucket and the limit it enforces
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// RateLimitResult is the state of a bucket after a Take
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until the next request is allowed, when denied
+	ResetAfter time.Duration // until the bucket is full again
+}
+
+// RateLimitStore keeps token buckets. Take refills buckets and takes a token
+// from each only if every one has a token left, so a denied request never
+// drains the buckets that would have allowed it. It returns one result per
+// bucket, in order. Take must be atomic so several gateway instances can
+// share a store.
+type RateLimitStore interface {
+	Take(ctx context.Context, buckets []Bucket, now time.Time) ([]RateLimitResult, error)
+}
+
+// bucketResult builds a RateLimitResult from the tokens left in a bucket
+func bucketResult(allowed bool, tokens float64, limit Limit) RateLimitResult {
+	rate := limit.rate()
+	result := RateLimitResult{
+		Allowed:    allowed,
+		Limit:      limit.Requests,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(limit.Requests) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
+
+// tokenBucket is a bucket in memoryStore
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket refills completely, after which it can be dropped
+}
+
+// memoryStore is a RateLimitStore for a single gateway instance
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]tokenBucket
+	nextSweep time.Time
+}
+
+// memoryStoreSweepInterval is how often full buckets are dropped
+const memoryStoreSweepInterval = time.Minute
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{buckets: make(map[string]tokenBucket)}
+}
+
+func (s *memoryStore) Take(ctx context.Context, buckets []Bucket, now time.Time) ([]RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.After(s.nextSweep) {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.nextSweep = now.Add(memoryStoreSweepInterval)
+	}
+
+	refilled := make([]tokenBucket, len(buckets))
+	allowed := true
+	for i, bucket := range buckets {
+		capacity := float64(bucket.Limit.Requests)
+		b, ok := s.buckets[bucket.Key]
+		if !ok {
+			b = tokenBucket{tokens: capacity, updated: now}
+		}
+		if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+			b.tokens = math.Min(capacity, b.tokens+elapsed*bucket.Limit.rate())
+			b.updated = now
+		}
+		if b.tokens < 1 {
+			allowed = false
+		}
+		refilled[i] = b
+	}
+
+	results := make([]RateLimitResult, len(buckets))
+	for i, bucket := range buckets {
+		b := refilled[i]
+		if allowed {
+			b.tokens--
+		}
+		b.full = now.Add(time.Duration((float64(bucket.Limit.Requests) - b.tokens) / bucket.Limit.rate() * float64(time.Second)))
+		s.buckets[bucket.Key] = b
+		results[i] = bucketResult(b.tokens >= 1 || allowed, b.tokens, bucket.Limit)
+	}
+	return results, nil
+}
+
+// ScriptRunner evaluates a Lua script, as Redis EVAL does. Wrap a Redis
+// client in a ScriptRunner to share buckets between gateway instances.
+type ScriptRunner interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// tokenBucketScript refills the buckets at KEYS and takes a token from each
+// only if all of them have one, like memoryStore. ARGV is now in
+// milliseconds followed by the capacity and tokens per millisecond of each
+// bucket. It returns whether the request is allowed followed by the tokens
+// left in each bucket in thousandths, since Redis truncates Lua numbers to
+// integers.
+const tokenBucketScript = `
+local now = tonumber(ARGV[1])
+local capacity, rate, tokens, updated = {}, {}, {}, {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+  capacity[i] = tonumber(ARGV[2 * i])
+  rate[i] = tonumber(ARGV[2 * i + 1])
+  local bucket = redis.call("HMGET", key, "tokens", "updated")
+  tokens[i] = tonumber(bucket[1]) or capacity[i]
+  updated[i] = tonumber(bucket[2]) or now
+  if now > updated[i] then
+    tokens[i] = math.min(capacity[i], tokens[i] + (now - updated[i]) * rate[i])
+    updated[i] = now
+  end
+  if tokens[i] < 1 then
+    allowed = 0
+  end
+end
+local reply = {allowed}
+for i, key in ipairs(KEYS) do
+  if allowed == 1 then
+    tokens[i] = tokens[i] - 1
+  end
+  redis.call("HSET", key, "tokens", tostring(tokens[i]), "updated", updated[i])
+  redis.call("PEXPIRE", key, math.ceil((capacity[i] - tokens[i]) / rate[i]) + 1000)
+  reply[i + 1] = math.floor(tokens[i] * 1000)
+end
+return reply
+`
+
+// redisStore is a RateLimitStore backed by Redis or a compatible server
+type redisStore struct {
+	runner ScriptRunner
+	prefix string
+}
+
+func newRedisStore(runner ScriptRunner, prefix string) *redisStore {
+	return &redisStore{runner: runner, prefix: prefix}
+}
+
+func (s *redisStore) Take(ctx context.Context, buckets []Bucket, now time.Time) ([]RateLimitResult, error) {
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, 1+2*len(buckets))
+	args = append(args, now.UnixMilli())
+	for i, bucket := range buckets {
+		keys[i] = s.prefix + bucket.Key
+		args = append(args, bucket.Limit.Requests, bucket.Limit.rate()/1000)
+	}
+
+	reply, err := s.runner.Eval(ctx, tokenBucketScript, keys, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take tokens: %w", err)
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 1+len(buckets) {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	allowed, ok := values[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+
+	results := make([]RateLimitResult, len(buckets))
+	for i, bucket := range buckets {
+		milliTokens, ok := values[i+1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+		}
+		tokens := float64(milliTokens) / 1000
+		results[i] = bucketResult(tokens >= 1 || allowed == 1, tokens, bucket.Limit)
+	}
+	return results, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func take(t *testing.T, s RateLimitStore, now time.Time, buckets ...Bucket) []RateLimitResult {
+	t.Helper()
+	results, err := s.Take(context.Background(), buckets, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(buckets) {
+		t.Fatalf("%d results for %d buckets", len(results), len(buckets))
+	}
+	return results
+}
+
+// testStoreTake checks the token bucket behaviour every store must have
+func testStoreTake(t *testing.T, s RateLimitStore) {
+	bucket := Bucket{Key: "user:a", Limit: Limit{Requests: 3, Per: 3 * time.Second}}
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		r := take(t, s, now, bucket)[0]
+		if !r.Allowed || r.Remaining != i || r.Limit != 3 {
+			t.Fatalf("take %d = %+v, want allowed with %d remaining", 3-i, r, i)
+		}
+	}
+
+	denied := take(t, s, now, bucket)[0]
+	if denied.Allowed || denied.Remaining != 0 {
+		t.Fatalf("take over the limit = %+v, want denied", denied)
+	}
+	if denied.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", denied.RetryAfter)
+	}
+	if denied.ResetAfter != 3*time.Second {
+		t.Errorf("ResetAfter = %v, want 3s", denied.ResetAfter)
+	}
+
+	// One token is back after a second
+	if r := take(t, s, now.Add(time.Second), bucket)[0]; !r.Allowed || r.Remaining != 0 {
+		t.Errorf("take after refill = %+v, want allowed", r)
+	}
+	// Refills never exceed capacity
+	if r := take(t, s, now.Add(time.Hour), bucket)[0]; !r.Allowed || r.Remaining != 2 {
+		t.Errorf("take after a long idle = %+v, want 2 remaining", r)
+	}
+}
+
+// testStoreKeysAreIndependent checks that every key has its own bucket
+func testStoreKeysAreIndependent(t *testing.T, s RateLimitStore) {
+	limit := Limit{Requests: 1, Per: time.Minute}
+	now := time.Now()
+
+	take(t, s, now, Bucket{Key: "a", Limit: limit})
+	if r := take(t, s, now, Bucket{Key: "b", Limit: limit})[0]; !r.Allowed {
+		t.Errorf("bucket b limited by bucket a: %+v", r)
+	}
+}
+
+// testStoreDeniedTakeLeavesOtherBuckets checks that a request denied by one
+// bucket takes nothing from the others
+func testStoreDeniedTakeLeavesOtherBuckets(t *testing.T, s RateLimitStore) {
+	strict := Bucket{Key: "user:a:create_post", Limit: Limit{Requests: 1, Per: time.Minute}}
+	loose := Bucket{Key: "user:a:default", Limit: Limit{Requests: 10, Per: time.Minute}}
+	now := time.Now()
+
+	results := take(t, s, now, strict, loose)
+	if !results[0].Allowed || !results[1].Allowed || results[1].Remaining != 9 {
+		t.Fatalf("first take = %+v", results)
+	}
+
+	for i := 0; i < 5; i++ {
+		results = take(t, s, now, strict, loose)
+		if results[0].Allowed {
+			t.Fatalf("strict bucket allowed: %+v", results[0])
+		}
+		if !results[1].Allowed || results[1].Remaining != 9 {
+			t.Fatalf("denied request took from the loose bucket: %+v", results[1])
+		}
+	}
+
+	if r := take(t, s, now, loose)[0]; !r.Allowed || r.Remaining != 8 {
+		t.Errorf("loose bucket alone = %+v, want 8 remaining", r)
+	}
+}
+
+// testStore runs the checks every store must pass against fresh stores
+func testStore(t *testing.T, newStore func() RateLimitStore) {
+	t.Run("take", func(t *testing.T) { testStoreTake(t, newStore()) })
+	t.Run("keys are independent", func(t *testing.T) { testStoreKeysAreIndependent(t, newStore()) })
+	t.Run("denied take leaves other buckets", func(t *testing.T) { testStoreDeniedTakeLeavesOtherBuckets(t, newStore()) })
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func() RateLimitStore { return newMemoryStore() })
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	s := newMemoryStore()
+	now := time.Now()
+	take(t, s, now, Bucket{Key: "a", Limit: Limit{Requests: 10, Per: time.Second}})
+
+	take(t, s, now.Add(2*memoryStoreSweepInterval), Bucket{Key: "b", Limit: Limit{Requests: 10, Per: time.Second}})
+	if _, ok := s.buckets["a"]; ok {
+		t.Error("full bucket a was not swept")
+	}
+}
+
+// fakeRedis runs tokenBucketScript against in-memory hashes, mirroring the
+// Lua line by line, and returns replies typed as Redis clients decode them
+type fakeRedis struct {
+	hashes  map[string]map[string]string
+	expires map[string]int64
+	err     error
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{hashes: make(map[string]map[string]string), expires: make(map[string]int64)}
+}
+
+func (f *fakeRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if script != tokenBucketScript || len(args) != 1+2*len(keys) {
+		return nil, errors.New("unexpected script")
+	}
+
+	// Redis passes every argument as a string
+	argv := make([]float64, len(args))
+	for i, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'g', -1, 64)
+		default:
+			return nil, errors.New("unsupported argument type")
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		argv[i] = n
+	}
+	now := argv[0]
+
+	capacity := make([]float64, len(keys))
+	rate := make([]float64, len(keys))
+	tokens := make([]float64, len(keys))
+	updated := make([]float64, len(keys))
+	allowed := int64(1)
+	for i, key := range keys {
+		capacity[i], rate[i] = argv[1+2*i], argv[2+2*i]
+		bucket := f.hashes[key]
+		var err error
+		if tokens[i], err = strconv.ParseFloat(bucket["tokens"], 64); err != nil {
+			tokens[i] = capacity[i]
+		}
+		if updated[i], err = strconv.ParseFloat(bucket["updated"], 64); err != nil {
+			updated[i] = now
+		}
+		if now > updated[i] {
+			tokens[i] = math.Min(capacity[i], tokens[i]+(now-updated[i])*rate[i])
+			updated[i] = now
+		}
+		if tokens[i] < 1 {
+			allowed = 0
+		}
+	}
+
+	reply := []interface{}{allowed}
+	for i, key := range keys {
+		if allowed == 1 {
+			tokens[i]--
+		}
+		f.hashes[key] = map[string]string{
+			"tokens":  strconv.FormatFloat(tokens[i], 'g', -1, 64),
+			"updated": strconv.FormatFloat(updated[i], 'f', -1, 64),
+		}
+		f.expires[key] = int64(math.Ceil((capacity[i]-tokens[i])/rate[i])) + 1000
+		reply = append(reply, int64(math.Floor(tokens[i]*1000)))
+	}
+	return reply, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, func() RateLimitStore { return newRedisStore(newFakeRedis(), "ratelimit:") })
+}
+
+func TestRedisStoreKeys(t *testing.T) {
+	redis := newFakeRedis()
+	s := newRedisStore(redis, "ratelimit:")
+	take(t, s, time.Now(), Bucket{Key: "user:a", Limit: Limit{Requests: 10, Per: 10 * time.Second}})
+
+	if _, ok := redis.hashes["ratelimit:user:a"]; !ok {
+		t.Errorf("buckets not stored under the prefix: %v", redis.hashes)
+	}
+	// Buckets expire once they would be full again
+	if ttl := redis.expires["ratelimit:user:a"]; ttl != 1000+1000 {
+		t.Errorf("PEXPIRE of a bucket one token short = %dms, want 2000ms", ttl)
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	buckets := []Bucket{{Key: "a", Limit: Limit{Requests: 1, Per: time.Second}}}
+
+	redis := newFakeRedis()
+	redis.err = errors.New("connection refused")
+	if _, err := newRedisStore(redis, "").Take(context.Background(), buckets, time.Now()); err == nil {
+		t.Error("Take succeeded with Redis down")
+	}
+
+	for _, reply := range []interface{}{"OK", []interface{}{int64(1)}, []interface{}{"1", int64(0)}, []interface{}{int64(1), "0"}} {
+		if _, err := newRedisStore(replyRunner{reply}, "").Take(context.Background(), buckets, time.Now()); err == nil {
+			t.Errorf("Take accepted the malformed reply %v", reply)
+		}
+	}
+}
+
+// replyRunner answers every script with reply
+type replyRunner struct{ reply interface{} }
+
+func (r replyRunner) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return r.reply, nil
+}
+
+func TestTakeRateLimit(t *testing.T) {
+	old := rateLimitStore
+	rateLimitStore = newMemoryStore()
+	t.Cleanup(func() { rateLimitStore = old })
+
+	base := rateLimitPolicy{name: "test", user: Limit{Requests: 20, Per: time.Minute}}
+	userLimit := func(p rateLimitPolicy) Limit { return p.user }
+
+	// POST /v1/posts also matches the stricter create_post policy (10/min)
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/posts", nil)
+		if !takeRateLimit(w, r, "user:a", base, userLimit) {
+			t.Fatalf("request %d limited", i+1)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "10" {
+			t.Fatalf("RateLimit-Limit = %s, want the tighter policy's 10", got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if takeRateLimit(w, httptest.NewRequest(http.MethodPost, "/v1/posts", nil), "user:a", base, userLimit) {
+		t.Fatal("request over the create_post limit allowed")
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// The denied post did not use up the base policy
+	w = httptest.NewRecorder()
+	if !takeRateLimit(w, httptest.NewRequest(http.MethodGet, "/v1/posts", nil), "user:a", base, userLimit) {
+		t.Fatal("GET limited by the create_post policy")
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "9" {
+		t.Errorf("RateLimit-Remaining = %s, want 9", got)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// Route auth requirements
+const (
+	// RouteAuthRequired verifies the caller's token and forwards a signed identity
+	RouteAuthRequired = "required"
+	// RouteAuthNone forwards requests without authenticating them
+	RouteAuthNone = "none"
+)
+
+const (
+	// maxRouteTimeout is the longest route timeout and the timeout applied to
+	// routes without one
+	maxRouteTimeout = 60 * time.Second
+	// serverWriteTimeout leaves the gateway time to write its 504 after the
+	// longest route timeout, since routes can be reloaded at any time
+	serverWriteTimeout = maxRouteTimeout + 5*time.Second
+)
+
+// RoutesConfig is the route table loaded from GATEWAY_ROUTES_FILE
+type RoutesConfig struct {
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig forwards requests whose path starts with Prefix to Upstreams.
+// Upstream URLs may reference environment variables as ${VAR} or ${VAR:-default},
+// and a comma-separated value adds one upstream per URL.
+type RouteConfig struct {
+	Name        string           `json:"name"`
+	Prefix      string           `json:"prefix"`
+	Upstreams   []string         `json:"upstreams"`
+	Balancer    string           `json:"balancer,omitempty"`
+	StripPrefix bool             `json:"stripPrefix,omitempty"`
+	Auth        string           `json:"auth,omitempty"`
+	Timeout     string           `json:"timeout,omitempty"`
+	RateLimit   *RateLimitConfig `json:"rateLimit,omitempty"`
+}
+
+// RateLimitConfig replaces the default rate limits for a route
+type RateLimitConfig struct {
+	UserPerMinute int `json:"userPerMinute"`
+	IPPerMinute   int `json:"ipPerMinute"`
+}
+
+// defaultRoutesConfig is used when GATEWAY_ROUTES_FILE is not set
+func defaultRoutesConfig() RoutesConfig {
+	profileURLs := getServiceURLs("PROFILE_SERVICE_URL", "http://localhost:8081")
+	feedURLs := getServiceURLs("FEED_SERVICE_URL", "http://localhost:8082")
+	connectionsURLs := getServiceURLs("CONNECTIONS_SERVICE_URL", "http://localhost:8083")
+
+	return RoutesConfig{Routes: []RouteConfig{
+		{Name: "profile", Prefix: "/v1/profile", Upstreams: profileURLs},
+		{Name: "admin", Prefix: "/v1/admin", Upstreams: profileURLs},
+		{Name: "posts", Prefix: "/v1/posts", Upstreams: feedURLs},
+		{Name: "connections", Prefix: "/v1/connections", Upstreams: connectionsURLs},
+	}}
+}
+
+// loadRoutesConfig reads and validates the route table at path, or returns
+// the default table when path is empty
+func loadRoutesConfig(path string) (RoutesConfig, error) {
+	if path == "" {
+		config := defaultRoutesConfig()
+		if err := config.validate(); err != nil {
+			return RoutesConfig{}, fmt.Errorf("invalid default routes: %w", err)
+		}
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RoutesConfig{}, fmt.Errorf("failed to read routes file: %w", err)
+	}
+
+	var config RoutesConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return RoutesConfig{}, fmt.Errorf("failed to parse routes file %s: %w", path, err)
+	}
+
+	for i := range config.Routes {
+		var upstreams []string
+		for _, upstream := range config.Routes[i].Upstreams {
+			upstreams = append(upstreams, splitURLs(expandEnv(upstream))...)
+		}
+		config.Routes[i].Upstreams = upstreams
+	}
+
+	if err := config.validate(); err != nil {
+		return RoutesConfig{}, fmt.Errorf("invalid routes file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in s
+func expandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		name, fallback, _ := strings.Cut(name, ":-")
+		return getEnv(name, fallback)
+	})
+}
+
+// splitURLs splits a comma-separated list of upstream URLs
+func splitURLs(s string) []string {
+	var urls []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// validate reports every problem in the table at once
+func (c RoutesConfig) validate() error {
+	var errs []error
+	if len(c.Routes) == 0 {
+		errs = append(errs, errors.New("no routes defined"))
+	}
+
+	names := map[string]bool{}
+	prefixes := map[string]bool{}
+	for i, route := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if route.Name != "" {
+			field = fmt.Sprintf("route %q", route.Name)
+		}
+
+		if route.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", field))
+		} else if names[route.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", field))
+		}
+		names[route.Name] = true
+
+		if !strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: prefix must start with /", field))
+		} else if route.Prefix != "/" && strings.HasSuffix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: prefix must not end with /", field))
+		} else if prefixes[route.Prefix] {
+			errs = append(errs, fmt.Errorf("%s: duplicate prefix %s", field, route.Prefix))
+		}
+		prefixes[route.Prefix] = true
+
+		if len(route.Upstreams) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one upstream is required", field))
+		}
+		seen := map[string]bool{}
+		for _, upstream := range route.Upstreams {
+			u, err := url.Parse(upstream)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("%s: invalid upstream URL %q", field, upstream))
+			} else if seen[upstream] {
+				errs = append(errs, fmt.Errorf("%s: duplicate upstream %q", field, upstream))
+			}
+			seen[upstream] = true
+		}
+
+		switch route.Balancer {
+		case "", BalancerRoundRobin, BalancerLeastConnections, BalancerConsistentHash:
+		default:
+			errs = append(errs, fmt.Errorf("%s: balancer must be %q, %q or %q",
+				field, BalancerRoundRobin, BalancerLeastConnections, BalancerConsistentHash))
+		}
+
+		if route.Auth != "" && route.Auth != RouteAuthRequired && route.Auth != RouteAuthNone {
+			errs = append(errs, fmt.Errorf("%s: auth must be %q or %q", field, RouteAuthRequired, RouteAuthNone))
+		}
+
+		if route.Timeout != "" {
+			timeout, err := time.ParseDuration(route.Timeout)
+			if err != nil || timeout <= 0 || timeout > maxRouteTimeout {
+				errs = append(errs, fmt.Errorf("%s: timeout must be a duration up to %s", field, maxRouteTimeout))
+			}
+		}
+
+		if rl := route.RateLimit; rl != nil && (rl.UserPerMinute <= 0 || rl.IPPerMinute <= 0) {
+			errs = append(errs, fmt.Errorf("%s: rateLimit userPerMinute and ipPerMinute must be positive", field))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// gatewayRoute is a RouteConfig ready to serve
+type gatewayRoute struct {
+	config  RouteConfig
+	pool    *upstreamPool
+	handler http.Handler
+}
+
+// matches reports whether path is the route's prefix or below it
+func (rt *gatewayRoute) matches(path string) bool {
+	prefix := rt.config.Prefix
+	if prefix == "/" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// routeTable holds routes longest prefix first
+type routeTable struct {
+	routes []*gatewayRoute
+}
+
+func newRouteTable(config RoutesConfig) (*routeTable, error) {
+	table := &routeTable{}
+	for _, rc := range config.Routes {
+		pool, err := newUpstreamPool(rc.Upstreams, rc.Balancer)
+		if err != nil {
+			table.close()
+			return nil, fmt.Errorf("route %q: %w", rc.Name, err)
+		}
+		table.routes = append(table.routes, &gatewayRoute{config: rc, pool: pool, handler: buildRouteHandler(rc, pool)})
+	}
+	sort.SliceStable(table.routes, func(i, j int) bool {
+		return len(table.routes[i].config.Prefix) > len(table.routes[j].config.Prefix)
+	})
+	return table, nil
+}
+
+// close stops the health checks of every route in t
+func (t *routeTable) close() {
+	for _, rt := range t.routes {
+		rt.pool.close()
+	}
+}
+
+func (t *routeTable) match(path string) *gatewayRoute {
+	for _, rt := range t.routes {
+		if rt.matches(path) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// buildRouteHandler chains rate limiting, authentication, timeout and prefix
+// stripping in front of the route's proxy
+func buildRouteHandler(rc RouteConfig, pool *upstreamPool) http.Handler {
+	var h http.Handler = pool
+
+	if rc.StripPrefix {
+		h = stripPrefix(rc.Prefix, h)
+	}
+	if rc.Timeout != "" {
+		timeout, _ := time.ParseDuration(rc.Timeout)
+		h = withTimeout(timeout, h)
+	}
+
+	policy := defaultRateLimitPolicy
+	if rl := rc.RateLimit; rl != nil {
+		policy = rateLimitPolicy{
+			name: rc.Name,
+			user: Limit{Requests: rl.UserPerMinute, Per: time.Minute},
+			ip:   Limit{Requests: rl.IPPerMinute, Per: time.Minute},
+		}
+	}
+
+	if rc.Auth == RouteAuthNone {
+		h = anonymous(h)
+	} else {
+		h = authenticate(limitByUser(policy)(h))
+	}
+	return limitByIP(policy)(h)
+}
+
+// stripPrefix removes prefix from the path forwarded upstream
+func stripPrefix(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// withTimeout bounds how long the upstream may take to respond
+func withTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// gatewayRouter serves the current route table and swaps it on reload
+type gatewayRouter struct {
+	path  string
+	table atomic.Pointer[routeTable]
+}
+
+// newGatewayRouter loads the route table at path, or the default table if path is empty
+func newGatewayRouter(path string) (*gatewayRouter, error) {
+	router := &gatewayRouter{path: path}
+	if err := router.reload(); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// reload replaces the route table, keeping the current one if the new one is invalid
+func (g *gatewayRouter) reload() error {
+	config, err := loadRoutesConfig(g.path)
+	if err != nil {
+		return err
+	}
+
+	table, err := newRouteTable(config)
+	if err != nil {
+		return err
+	}
+	if old := g.table.Swap(table); old != nil {
+		old.close()
+	}
+
+	names := make([]string, len(config.Routes))
+	for i, rc := range config.Routes {
+		names[i] = rc.Name + " " + rc.Prefix
+	}
+	log.Info("Routes loaded", zap.String("file", g.path), zap.Strings("routes", names))
+	return nil
+}
+
+// reloadOnSIGHUP reloads the route table whenever the process receives SIGHUP
+func (g *gatewayRouter) reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := g.reload(); err != nil {
+				log.Error("Failed to reload routes, keeping current routes", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func (g *gatewayRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := g.table.Load().match(r.URL.Path)
+	if rt == nil {
+		httpx.NotFound(w, "Route not found")
+		return
+	}
+	rt.handler.ServeHTTP(w, r)
+}
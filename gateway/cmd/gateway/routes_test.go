@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRoutesFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRoutesConfig(t *testing.T) {
+	t.Setenv("TEST_FEED_URL", "http://feed:8082")
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeRoutesFile(t, path, `{"routes": [
+		{"name": "posts", "prefix": "/v1/posts", "upstreams": ["${TEST_FEED_URL}", "${TEST_UNSET_URL:-http://fallback:8082}"], "timeout": "30s"}
+	]}`)
+
+	config, err := loadRoutesConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := config.Routes[0].Upstreams
+	if len(got) != 2 || got[0] != "http://feed:8082" || got[1] != "http://fallback:8082" {
+		t.Errorf("upstreams = %v, want the variable and the fallback expanded", got)
+	}
+
+	writeRoutesFile(t, path, `{"routes": [{"name": "posts", "prefix": "/v1/posts", "upstreams": ["http://feed"], "retries": 3}]}`)
+	if _, err := loadRoutesConfig(path); err == nil {
+		t.Error("unknown field accepted")
+	}
+
+	if _, err := loadRoutesConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+
+	if _, err := loadRoutesConfig(""); err != nil {
+		t.Errorf("default routes invalid: %v", err)
+	}
+}
+
+func TestRoutesConfigValidate(t *testing.T) {
+	valid := RouteConfig{Name: "posts", Prefix: "/v1/posts", Upstreams: []string{"http://feed:8082"}}
+	with := func(change func(*RouteConfig)) RoutesConfig {
+		rc := valid
+		change(&rc)
+		return RoutesConfig{Routes: []RouteConfig{rc}}
+	}
+
+	tests := []struct {
+		name    string
+		config  RoutesConfig
+		wantErr string
+	}{
+		{"valid", with(func(rc *RouteConfig) {}), ""},
+		{"all options", with(func(rc *RouteConfig) {
+			rc.Auth, rc.Timeout, rc.StripPrefix = RouteAuthNone, "30s", true
+			rc.RateLimit = &RateLimitConfig{UserPerMinute: 10, IPPerMinute: 20}
+		}), ""},
+		{"root prefix", with(func(rc *RouteConfig) { rc.Prefix = "/" }), ""},
+		{"no routes", RoutesConfig{}, "no routes defined"},
+		{"no name", with(func(rc *RouteConfig) { rc.Name = "" }), "name is required"},
+		{"duplicate name", RoutesConfig{Routes: []RouteConfig{valid, {Name: "posts", Prefix: "/v2/posts", Upstreams: valid.Upstreams}}}, "duplicate name"},
+		{"duplicate prefix", RoutesConfig{Routes: []RouteConfig{valid, {Name: "other", Prefix: "/v1/posts", Upstreams: valid.Upstreams}}}, "duplicate prefix"},
+		{"relative prefix", with(func(rc *RouteConfig) { rc.Prefix = "v1/posts" }), "must start with /"},
+		{"trailing slash", with(func(rc *RouteConfig) { rc.Prefix = "/v1/posts/" }), "must not end with /"},
+		{"no upstreams", with(func(rc *RouteConfig) { rc.Upstreams = nil }), "at least one upstream"},
+		{"upstream without scheme", with(func(rc *RouteConfig) { rc.Upstreams = []string{"feed:8082"} }), "invalid upstream URL"},
+		{"duplicate upstream", with(func(rc *RouteConfig) { rc.Upstreams = []string{"http://feed:8082", "http://feed:8082"} }), "duplicate upstream"},
+		{"unknown balancer", with(func(rc *RouteConfig) { rc.Balancer = "random" }), "balancer must be"},
+		{"unknown auth", with(func(rc *RouteConfig) { rc.Auth = "optional" }), "auth must be"},
+		{"bad timeout", with(func(rc *RouteConfig) { rc.Timeout = "soon" }), "timeout must be"},
+		{"timeout too long", with(func(rc *RouteConfig) { rc.Timeout = "2m" }), "timeout must be"},
+		{"zero rate limit", with(func(rc *RouteConfig) { rc.RateLimit = &RateLimitConfig{UserPerMinute: 10} }), "must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	err := RoutesConfig{Routes: []RouteConfig{{Prefix: "v1"}}}.validate()
+	if err == nil || !strings.Contains(err.Error(), "name is required") || !strings.Contains(err.Error(), "at least one upstream") {
+		t.Errorf("validate() = %v, want every problem reported", err)
+	}
+}
+
+func TestRouteTableMatch(t *testing.T) {
+	table, err := newRouteTable(RoutesConfig{Routes: []RouteConfig{
+		{Name: "root", Prefix: "/", Upstreams: []string{"http://root"}},
+		{Name: "profile", Prefix: "/v1/profile", Upstreams: []string{"http://profile"}},
+		{Name: "search", Prefix: "/v1/profile/search", Upstreams: []string{"http://search"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(table.close)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/profile", "profile"},
+		{"/v1/profile/me", "profile"},
+		{"/v1/profile/search", "search"},
+		{"/v1/profile/search/users", "search"},
+		{"/v1/profilex", "root"},
+		{"/healthz", "root"},
+	}
+	for _, tt := range tests {
+		if rt := table.match(tt.path); rt == nil || rt.config.Name != tt.want {
+			t.Errorf("match(%q) = %v, want %s", tt.path, rt, tt.want)
+		}
+	}
+}
+
+// upstreamNamed serves its name and the path it received
+func upstreamNamed(t *testing.T, name string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestGatewayRouterReload(t *testing.T) {
+	t.Setenv("TEST_UPSTREAM_A", upstreamNamed(t, "a"))
+	t.Setenv("TEST_UPSTREAM_B", upstreamNamed(t, "b"))
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeRoutesFile(t, path, `{"routes": [
+		{"name": "posts", "prefix": "/v1/posts", "upstreams": ["${TEST_UPSTREAM_A}"], "auth": "none"}
+	]}`)
+
+	router, err := newGatewayRouter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get(t, router, "/v1/posts/1"); code != http.StatusOK || body != "a /v1/posts/1" {
+		t.Fatalf("before reload: %d %q", code, body)
+	}
+	if code, _ := get(t, router, "/v1/feed"); code != http.StatusNotFound {
+		t.Fatalf("unrouted path status = %d, want 404", code)
+	}
+
+	writeRoutesFile(t, path, `{"routes": [
+		{"name": "posts", "prefix": "/v1/posts", "upstreams": ["${TEST_UPSTREAM_B}"], "auth": "none", "stripPrefix": true},
+		{"name": "feed", "prefix": "/v1/feed", "upstreams": ["${TEST_UPSTREAM_A}"], "auth": "none"}
+	]}`)
+	if err := router.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get(t, router, "/v1/posts/1"); code != http.StatusOK || body != "b /1" {
+		t.Errorf("after reload: %d %q, want the new upstream with the prefix stripped", code, body)
+	}
+	if code, body := get(t, router, "/v1/feed"); code != http.StatusOK || body != "a /v1/feed" {
+		t.Errorf("added route: %d %q", code, body)
+	}
+
+	// An invalid table leaves the current routes in place
+	writeRoutesFile(t, path, `{"routes": [{"name": "posts", "prefix": "/v1/posts", "upstreams": []}]}`)
+	if err := router.reload(); err == nil {
+		t.Fatal("invalid routes reloaded")
+	}
+	if code, body := get(t, router, "/v1/feed"); code != http.StatusOK || body != "a /v1/feed" {
+		t.Errorf("routes after a failed reload: %d %q", code, body)
+	}
+}
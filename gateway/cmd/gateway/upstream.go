@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// healthCheckInterval is how often each upstream's /healthz is polled
+	healthCheckInterval = 10 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+
+	// breakerFailureThreshold consecutive failures open an upstream's circuit
+	breakerFailureThreshold = 5
+	// breakerCooldown is how long an open circuit rejects requests before a trial request
+	breakerCooldown = 30 * time.Second
+
+	// maxProxyAttempts bounds how often an idempotent request is tried
+	maxProxyAttempts = 3
+	retryBackoff     = 50 * time.Millisecond
+)
+
+var (
+	errUpstreamStatus      = errors.New("upstream returned a gateway error")
+	errUpstreamUnavailable = errors.New("no upstream available")
+)
+
+// upstream is one service instance behind a route
+type upstream struct {
+	url     string
+	target  *url.URL
+	proxy   http.Handler
+	healthy atomic.Bool
+	breaker circuitBreaker
+	outlier outlierStats
+	// active counts requests in flight, for least-connections balancing
+	active atomic.Int64
+}
+
+func newUpstream(rawURL string) (*upstream, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL %q: %w", rawURL, err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q", rawURL)
+	}
+
+	u := &upstream{url: rawURL, target: target}
+	u.healthy.Store(true)
+	u.proxy = createProxy(u)
+	return u, nil
+}
+
+// available reports whether u should receive requests, reserving the trial
+// request of a half-open circuit
+func (u *upstream) available(now time.Time) bool {
+	return u.breaker.allow(now)
+}
+
+// checkHealth polls u's /healthz until ctx is cancelled
+func (u *upstream) checkHealth(ctx context.Context) {
+	client := &http.Client{Timeout: healthCheckTimeout}
+	healthURL := u.target.JoinPath("/healthz").String()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		healthy := false
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+		if err == nil {
+			if resp, err := client.Do(req); err == nil {
+				resp.Body.Close()
+				healthy = resp.StatusCode == http.StatusOK
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if u.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Info("Upstream healthy", zap.String("upstream", u.url))
+			} else {
+				log.Warn("Upstream unhealthy", zap.String("upstream", u.url))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// proxyAttempt lets the proxy hand a failure back to upstreamPool for a
+// retry instead of writing an error response
+type proxyAttempt struct {
+	retryable bool
+	err       error
+}
+
+type proxyAttemptKey struct{}
+
+// recordResponse feeds an upstream response to the circuit breaker. Gateway
+// errors from the upstream are turned into retries when the attempt allows it.
+func (u *upstream) recordResponse(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		u.outlier.failure()
+	} else {
+		u.outlier.success()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		u.breaker.failure(time.Now(), u.url)
+		if a, ok := resp.Request.Context().Value(proxyAttemptKey{}).(*proxyAttempt); ok && a.retryable {
+			return errUpstreamStatus
+		}
+	default:
+		u.breaker.success()
+	}
+	return nil
+}
+
+// handleError is the proxy's ErrorHandler
+func (u *upstream) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// The client went away, which says nothing about the upstream
+		u.breaker.abandon()
+		return
+	}
+	if !errors.Is(err, errUpstreamStatus) {
+		u.breaker.failure(time.Now(), u.url)
+		u.outlier.failure()
+	}
+
+	if a, ok := r.Context().Value(proxyAttemptKey{}).(*proxyAttempt); ok && a.retryable {
+		a.err = err
+		return
+	}
+
+	log.Warn("Upstream request failed",
+		zap.String("upstream", u.url),
+		zap.String("path", r.URL.Path),
+		zap.Error(err))
+	writeUpstreamError(w, err)
+}
+
+// writeUpstreamError writes a JSON error in the httpx.ErrorResponse format
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		httpx.WriteError(w, http.StatusGatewayTimeout, "gateway_timeout", "Upstream service timed out")
+	case errors.Is(err, errUpstreamUnavailable):
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(breakerCooldown.Seconds())))
+		httpx.WriteError(w, http.StatusServiceUnavailable, "service_unavailable", "Upstream service unavailable")
+	default:
+		httpx.WriteError(w, http.StatusBadGateway, "bad_gateway", "Upstream service error")
+	}
+}
+
+// Circuit breaker states
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops sending requests to an upstream after repeated
+// failures, then lets a single trial request through after breakerCooldown
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < breakerCooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) stateName() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// abandon gives back a half-open trial whose request never completed
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = breakerClosed
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure(now time.Time, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= breakerFailureThreshold) {
+		b.state = breakerOpen
+		b.openedAt = now
+		b.trial = false
+		log.Warn("Upstream circuit opened", zap.String("upstream", name), zap.Int("failures", b.failures))
+	}
+}
+
+// isIdempotent reports whether a request can be safely sent again. Requests
+// with bodies are never retried so the body does not need to be buffered.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return r.Body == nil || r.Body == http.NoBody
+	}
+	return false
+}
+
+// upstreamPool balances requests over a route's upstreams, skipping
+// unhealthy and ejected upstreams and retrying idempotent requests on
+// another one
+type upstreamPool struct {
+	upstreams []*upstream
+	balancer  balancer
+	cancel    context.CancelFunc
+}
+
+// newUpstreamPool starts health checks and outlier detection for upstreams,
+// which run until close
+func newUpstreamPool(upstreams []string, balancerName string) (*upstreamPool, error) {
+	pool := &upstreamPool{}
+	for _, rawURL := range upstreams {
+		u, err := newUpstream(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		pool.upstreams = append(pool.upstreams, u)
+	}
+
+	pool.balancer = newBalancer(balancerName, pool.upstreams)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.cancel = cancel
+	for _, u := range pool.upstreams {
+		go u.checkHealth(ctx)
+	}
+	go pool.detectOutliers(ctx)
+	return pool, nil
+}
+
+// close stops the pool's health checks
+func (p *upstreamPool) close() {
+	p.cancel()
+}
+
+// pick returns the upstream to try next, preferring healthy, non-ejected
+// upstreams not in tried. It returns nil when every circuit is open.
+func (p *upstreamPool) pick(r *http.Request, tried map[*upstream]bool) *upstream {
+	now := time.Now()
+	eligible := []func(*upstream) bool{
+		func(u *upstream) bool { return u.healthy.Load() && !u.outlier.ejected(now) && !tried[u] },
+		func(u *upstream) bool { return !tried[u] },
+		func(u *upstream) bool { return true },
+	}
+
+	for _, ok := range eligible {
+		var candidates []*upstream
+		for _, u := range p.upstreams {
+			if ok(u) {
+				candidates = append(candidates, u)
+			}
+		}
+
+		for len(candidates) > 0 {
+			u := p.balancer.pick(r, candidates)
+			if u.available(now) {
+				return u
+			}
+			candidates = slices.DeleteFunc(candidates, func(c *upstream) bool { return c == u })
+		}
+	}
+	return nil
+}
+
+// serve proxies r to u, counting it as in flight
+func (u *upstream) serve(w http.ResponseWriter, r *http.Request) {
+	u.active.Add(1)
+	defer u.active.Add(-1)
+	u.proxy.ServeHTTP(w, r)
+}
+
+func (p *upstreamPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	attempts := 1
+	if isIdempotent(r) {
+		attempts = maxProxyAttempts
+	}
+
+	tried := map[*upstream]bool{}
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		u := p.pick(r, tried)
+		if u == nil {
+			if lastErr == nil {
+				lastErr = errUpstreamUnavailable
+			}
+			log.Warn("No upstream available", zap.String("path", r.URL.Path), zap.Error(lastErr))
+			writeUpstreamError(w, lastErr)
+			return
+		}
+		tried[u] = true
+
+		// The last attempt writes its response, or error, to the client
+		if i == attempts-1 {
+			u.serve(w, r)
+			return
+		}
+
+		attempt := &proxyAttempt{retryable: true}
+		u.serve(w, r.WithContext(context.WithValue(r.Context(), proxyAttemptKey{}, attempt)))
+		if attempt.err == nil {
+			return
+		}
+		lastErr = attempt.err
+
+		log.Info("Retrying upstream request",
+			zap.String("upstream", u.url),
+			zap.String("path", r.URL.Path),
+			zap.Int("attempt", i+1),
+			zap.Error(attempt.err))
+
+		select {
+		case <-r.Context().Done():
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				writeUpstreamError(w, r.Context().Err())
+			}
+			return
+		case <-time.After(retryBackoff * time.Duration(i+1)):
+		}
+	}
+}
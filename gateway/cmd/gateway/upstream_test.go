@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openBreaker fails b enough times to open it at now
+func openBreaker(b *circuitBreaker, now time.Time) {
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.failure(now, "test")
+	}
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	var b circuitBreaker
+	now := time.Now()
+
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.failure(now, "test")
+	}
+	if b.stateName() != "closed" || !b.allow(now) {
+		t.Fatalf("breaker %s before the threshold, want closed", b.stateName())
+	}
+
+	b.failure(now, "test")
+	if b.stateName() != "open" {
+		t.Fatalf("breaker %s at the threshold, want open", b.stateName())
+	}
+	if b.allow(now.Add(breakerCooldown - time.Second)) {
+		t.Error("open breaker allowed a request during the cooldown")
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	var b circuitBreaker
+	now := time.Now()
+
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.failure(now, "test")
+	}
+	b.success()
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.failure(now, "test")
+	}
+	if b.stateName() != "closed" {
+		t.Errorf("breaker %s, want failures counted only since the last success", b.stateName())
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	afterCooldown := now.Add(breakerCooldown)
+
+	t.Run("one trial at a time", func(t *testing.T) {
+		var b circuitBreaker
+		openBreaker(&b, now)
+
+		if !b.allow(afterCooldown) {
+			t.Fatal("no trial request after the cooldown")
+		}
+		if b.stateName() != "half_open" {
+			t.Fatalf("breaker %s, want half_open", b.stateName())
+		}
+		if b.allow(afterCooldown) {
+			t.Error("second request allowed while the trial is in flight")
+		}
+	})
+
+	t.Run("trial succeeds", func(t *testing.T) {
+		var b circuitBreaker
+		openBreaker(&b, now)
+		b.allow(afterCooldown)
+		b.success()
+
+		if b.stateName() != "closed" || !b.allow(afterCooldown) || !b.allow(afterCooldown) {
+			t.Errorf("breaker %s after a successful trial, want closed", b.stateName())
+		}
+	})
+
+	t.Run("trial fails", func(t *testing.T) {
+		var b circuitBreaker
+		openBreaker(&b, now)
+		b.allow(afterCooldown)
+		b.failure(afterCooldown, "test")
+
+		if b.stateName() != "open" {
+			t.Fatalf("breaker %s after a failed trial, want open", b.stateName())
+		}
+		if b.allow(afterCooldown.Add(breakerCooldown - time.Second)) {
+			t.Error("reopened breaker allowed a request before a new cooldown")
+		}
+		if !b.allow(afterCooldown.Add(breakerCooldown)) {
+			t.Error("no trial after the new cooldown")
+		}
+	})
+
+	t.Run("trial abandoned", func(t *testing.T) {
+		var b circuitBreaker
+		openBreaker(&b, now)
+		b.allow(afterCooldown)
+		b.abandon()
+
+		if b.stateName() != "half_open" {
+			t.Fatalf("breaker %s after an abandoned trial, want half_open", b.stateName())
+		}
+		if !b.allow(afterCooldown) {
+			t.Error("no new trial after the previous one was abandoned")
+		}
+	})
+}
+
+func TestCircuitBreakerAbandonWhenClosed(t *testing.T) {
+	var b circuitBreaker
+	b.abandon()
+	if b.stateName() != "closed" || !b.allow(time.Now()) {
+		t.Errorf("abandon changed a closed breaker to %s", b.stateName())
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		want   bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodHead, "", true},
+		{http.MethodOptions, "", true},
+		{http.MethodGet, "{}", false},
+		{http.MethodDelete, "", false},
+		{http.MethodPost, "", false},
+		{http.MethodPatch, "{}", false},
+		{http.MethodPut, "{}", false},
+	}
+
+	for _, tt := range tests {
+		var r *http.Request
+		if tt.body == "" {
+			r = httptest.NewRequest(tt.method, "/", nil)
+		} else {
+			r = httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+		}
+		if got := isIdempotent(r); got != tt.want {
+			t.Errorf("isIdempotent(%s) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamPoolRetriesIdempotentRequests(t *testing.T) {
+	// The failing upstream passes health checks so it keeps its turn
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(failing.Close)
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(working.Close)
+
+	pool, err := newUpstreamPool([]string{failing.URL, working.URL}, BalancerRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.close)
+
+	// Requests start on the failing upstream in turn
+	w := httptest.NewRecorder()
+	pool.balancer.(*roundRobin).next.Store(0)
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/posts", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("GET = %d %q, want retried on the working upstream", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	pool.balancer.(*roundRobin).next.Store(0)
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/posts", strings.NewReader("{}")))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("POST = %d, want the failing upstream's 502 without a retry", w.Code)
+	}
+}
+
+func TestUpstreamPoolAllCircuitsOpen(t *testing.T) {
+	pool, err := newUpstreamPool([]string{"http://127.0.0.1:1"}, BalancerRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.close)
+	openBreaker(&pool.upstreams[0].breaker, time.Now())
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+}
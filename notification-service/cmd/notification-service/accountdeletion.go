@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+)
+
+// AccountDeletedEvent from profile service
+type AccountDeletedEvent struct {
+	UID         string    `json:"uid"`
+	RequestedAt time.Time `json:"requestedAt"`
+	DeletedAt   time.Time `json:"deletedAt"`
+}
+
+// AccountPurgedEvent is published to RabbitMQ once this service has deleted a user's data
+type AccountPurgedEvent struct {
+	UID         string    `json:"uid"`
+	Service     string    `json:"service"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// startAccountPurging deletes a user's notifications and device tokens on account.deleted
+func startAccountPurging(ctx context.Context, conn *rabbitmq.Connection) error {
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		QueueName:   "notification-service.account-deletions",
+		RoutingKeys: []string{"account.deleted"},
+		Handler: func(body []byte) error {
+			var event AccountDeletedEvent
+			if err := json.Unmarshal(body, &event); err != nil || event.UID == "" {
+				log.Error("Failed to parse account.deleted event", zap.Error(err))
+				return nil
+			}
+			if err := purgeAccountData(ctx, event.UID); err != nil {
+				return err
+			}
+			return conn.Publish(ctx, "account.purged", AccountPurgedEvent{
+				UID:         event.UID,
+				Service:     "notification",
+				CompletedAt: time.Now(),
+			})
+		},
+	})
+}
+
+func purgeAccountData(ctx context.Context, uid string) error {
+	client := firestoredb.GetClient()
+
+	received, err := firestoredb.DeleteDocuments(ctx, client.Collection("notifications").Where("uid", "==", uid))
+	if err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+
+	// Notifications about uid's activity sent to other users
+	caused, err := firestoredb.DeleteDocuments(ctx, client.Collection("notifications").Where("actorUid", "==", uid))
+	if err != nil {
+		return fmt.Errorf("failed to delete notifications caused by user: %w", err)
+	}
+
+	tokens, err := firestoredb.DeleteDocuments(ctx, client.Collection("deviceTokens").Where("uid", "==", uid))
+	if err != nil {
+		return fmt.Errorf("failed to delete device tokens: %w", err)
+	}
+
+	log.Info("Notification data purged",
+		zap.String("uid", uid),
+		zap.Int("notifications", len(received)+len(caused)),
+		zap.Int("deviceTokens", len(tokens)))
+	return nil
+}
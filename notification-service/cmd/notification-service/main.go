@@ -78,6 +78,16 @@ func main() {
 		log.Fatal("Failed to start consuming", zap.Error(err))
 	}
 
+	// Notify mentioned users
+	if err := startMentionNotifications(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start mention notifications", zap.Error(err))
+	}
+
+	// Delete user data when accounts are deleted
+	if err := startAccountPurging(ctx, rabbitConn); err != nil {
+		log.Fatal("Failed to start account purging", zap.Error(err))
+	}
+
 	log.Info("Notification service started")
 
 	// Wait for interrupt signal
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PostMentionedEvent from feed service
+type PostMentionedEvent struct {
+	PostID        string    `json:"postId"`
+	AuthorUID     string    `json:"authorUid"`
+	MentionedUIDs []string  `json:"mentionedUids"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Notification represents a notification in Firestore
+type Notification struct {
+	UID       string    `firestore:"uid" json:"uid"`
+	Type      string    `firestore:"type" json:"type"`
+	ActorUID  string    `firestore:"actorUid" json:"actorUid"`
+	PostID    string    `firestore:"postId,omitempty" json:"postId,omitempty"`
+	Read      bool      `firestore:"read" json:"read"`
+	CreatedAt time.Time `firestore:"createdAt" json:"createdAt"`
+}
+
+// startMentionNotifications consumes post.mentioned on its own queue, since
+// the shared queue routes any event with a postId to handlePostCreated
+func startMentionNotifications(ctx context.Context, conn *rabbitmq.Connection) error {
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		QueueName:   "notification-service.mentions",
+		RoutingKeys: []string{"post.mentioned"},
+		Handler: func(body []byte) error {
+			return handlePostMentioned(ctx, body)
+		},
+	})
+}
+
+func handlePostMentioned(ctx context.Context, body []byte) error {
+	var event PostMentionedEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Error("Failed to parse post.mentioned event", zap.Error(err))
+		return nil
+	}
+
+	log.Info("Handling post.mentioned event",
+		zap.String("postId", event.PostID),
+		zap.String("authorUid", event.AuthorUID),
+		zap.Int("mentioned", len(event.MentionedUIDs)))
+
+	client := firestoredb.GetClient()
+	doc, err := client.Collection("posts").Doc(event.PostID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			// Deleted before we got to it
+			return nil
+		}
+		return fmt.Errorf("failed to get post %s: %w", event.PostID, err)
+	}
+
+	var post struct {
+		AuthorUID  string `firestore:"authorUid"`
+		Visibility string `firestore:"visibility"`
+		Mentions   []struct {
+			UID string `firestore:"uid"`
+		} `firestore:"mentions"`
+	}
+	if err := doc.DataTo(&post); err != nil {
+		log.Error("Failed to parse post", zap.String("postId", event.PostID), zap.Error(err))
+		return nil
+	}
+
+	// Skip users an edit has since removed
+	stillMentioned := map[string]struct{}{}
+	for _, m := range post.Mentions {
+		stillMentioned[m.UID] = struct{}{}
+	}
+
+	for _, uid := range event.MentionedUIDs {
+		if _, ok := stillMentioned[uid]; !ok || uid == post.AuthorUID {
+			continue
+		}
+
+		allowed, err := canSeePost(ctx, uid, post.AuthorUID, post.Visibility)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			log.Debug("Skipping mention notification for user who cannot see post",
+				zap.String("postId", event.PostID),
+				zap.String("uid", uid))
+			continue
+		}
+
+		notification := Notification{
+			UID:       uid,
+			Type:      "mention",
+			ActorUID:  post.AuthorUID,
+			PostID:    event.PostID,
+			CreatedAt: time.Now(),
+		}
+
+		// Deterministic ID so redelivered events don't notify twice
+		notificationID := "mention_" + event.PostID + "_" + uid
+		_, err = client.Collection("notifications").Doc(notificationID).Create(ctx, notification)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("failed to create notification: %w", err)
+		}
+
+		// TODO: Send push notification via FCM
+	}
+
+	return nil
+}
+
+// canSeePost reports whether viewerUID may see a post by authorUID with the
+// given visibility: never across a block, and only connections for
+// connections-only posts
+func canSeePost(ctx context.Context, viewerUID, authorUID, visibility string) (bool, error) {
+	doc, err := firestoredb.GetClient().Collection("relationships").
+		Doc(createRelationshipID(viewerUID, authorUID)).
+		Get(ctx)
+
+	relStatus := ""
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return false, fmt.Errorf("failed to get relationship: %w", err)
+		}
+	} else {
+		relStatus, _ = doc.Data()["status"].(string)
+	}
+
+	if relStatus == "blocked" {
+		return false, nil
+	}
+	if visibility == "connections" {
+		return relStatus == "accepted", nil
+	}
+	return true, nil
+}
+
+func createRelationshipID(uid1, uid2 string) string {
+	// Same deterministic ID as connections-service
+	uids := []string{uid1, uid2}
+	sort.Strings(uids)
+	return uids[0] + "_" + uids[1]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/firestoredb/firestoretest"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestHandlePostMentioned(t *testing.T) {
+	server, err := firestoretest.Install()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	docs := map[string]map[string]interface{}{
+		"posts/public": {
+			"authorUid":  "author",
+			"visibility": "public",
+			"mentions":   []interface{}{map[string]interface{}{"uid": "friend"}, map[string]interface{}{"uid": "blocked"}, map[string]interface{}{"uid": "author"}},
+		},
+		"posts/private": {
+			"authorUid":  "author",
+			"visibility": "connections",
+			"mentions":   []interface{}{map[string]interface{}{"uid": "friend"}, map[string]interface{}{"uid": "stranger"}},
+		},
+		"relationships/" + createRelationshipID("author", "friend"):  {"status": "accepted"},
+		"relationships/" + createRelationshipID("author", "blocked"): {"status": "blocked"},
+	}
+	for path, data := range docs {
+		if _, err := client.Doc(path).Set(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handle := func(postID string, uids ...string) {
+		t.Helper()
+		body, _ := json.Marshal(PostMentionedEvent{PostID: postID, AuthorUID: "author", MentionedUIDs: uids, CreatedAt: time.Now()})
+		if err := handlePostMentioned(ctx, body); err != nil {
+			t.Fatalf("handlePostMentioned(%s): %v", postID, err)
+		}
+	}
+
+	// removed was mentioned in the event but edited out of the post since
+	handle("public", "friend", "blocked", "author", "removed")
+	handle("public", "friend")
+	handle("private", "friend", "stranger")
+	handle("deleted", "friend")
+
+	all, err := client.Collection("notifications").Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, doc := range all {
+		var n Notification
+		if err := doc.DataTo(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n.Type != "mention" || n.ActorUID != "author" {
+			t.Errorf("notification %s = %+v", doc.Ref.ID, n)
+		}
+		got = append(got, n.PostID+"/"+n.UID)
+	}
+	sort.Strings(got)
+
+	want := []string{"private/friend", "public/friend"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("notifications = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firebaseapp"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// accountDeletionGracePeriod is how long a user can cancel a deletion request
+	accountDeletionGracePeriod = 30 * 24 * time.Hour
+	// accountDeletionSweepInterval is how often due deletions are started
+	accountDeletionSweepInterval = 15 * time.Minute
+	// accountDeletionRetryAfter is how long to wait for all services to purge
+	// before account.deleted is published again
+	accountDeletionRetryAfter = time.Hour
+)
+
+// Account deletion statuses
+const (
+	DeletionScheduled  = "scheduled"
+	DeletionCancelled  = "cancelled"
+	DeletionProcessing = "processing"
+	DeletionCompleted  = "completed"
+)
+
+// accountDeletionServices must each report account.purged before a deletion is completed
+var accountDeletionServices = []string{"profile", "feed", "connections", "notification"}
+
+var (
+	errDeletionNotFound     = errors.New("account deletion not found")
+	errDeletionNotPending   = errors.New("account deletion can no longer be cancelled")
+	errDeletionNotClaimable = errors.New("account deletion already claimed")
+)
+
+// AccountDeletion tracks a deletion request in accountDeletions/{uid}
+type AccountDeletion struct {
+	UID          string               `firestore:"uid" json:"uid"`
+	Status       string               `firestore:"status" json:"status"`
+	RequestedAt  time.Time            `firestore:"requestedAt" json:"requestedAt"`
+	ScheduledFor time.Time            `firestore:"scheduledFor" json:"scheduledFor"`
+	CancelledAt  *time.Time           `firestore:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	StartedAt    *time.Time           `firestore:"startedAt,omitempty" json:"startedAt,omitempty"`
+	PublishedAt  *time.Time           `firestore:"publishedAt,omitempty" json:"-"`
+	CompletedAt  *time.Time           `firestore:"completedAt,omitempty" json:"completedAt,omitempty"`
+	Purged       map[string]time.Time `firestore:"purged,omitempty" json:"purged,omitempty"`
+}
+
+// AccountDeletedEvent is published to RabbitMQ when an account's grace period ends.
+// Every service deletes the user's data and replies with account.purged.
+type AccountDeletedEvent struct {
+	UID         string    `json:"uid"`
+	RequestedAt time.Time `json:"requestedAt"`
+	DeletedAt   time.Time `json:"deletedAt"`
+}
+
+// AccountPurgedEvent is published by a service once it has deleted a user's data
+type AccountPurgedEvent struct {
+	UID         string    `json:"uid"`
+	Service     string    `json:"service"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+func accountDeletionRef(uid string) *firestore.DocumentRef {
+	return firestoredb.GetClient().Collection(authmw.AccountDeletionCollection).Doc(uid)
+}
+
+func requestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	// Without the broker the deletion would stay scheduled forever
+	if rabbitConn == nil {
+		httpx.ServiceUnavailable(w, "Account deletion is temporarily unavailable", 0)
+		return
+	}
+
+	ctx := r.Context()
+	ref := accountDeletionRef(uid)
+
+	var deletion AccountDeletion
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&deletion); err != nil {
+				return err
+			}
+			// Requesting again while a deletion is pending is a no-op
+			if deletion.Status == DeletionScheduled || deletion.Status == DeletionProcessing {
+				return nil
+			}
+		}
+
+		now := time.Now()
+		deletion = AccountDeletion{
+			UID:          uid,
+			Status:       DeletionScheduled,
+			RequestedAt:  now,
+			ScheduledFor: now.Add(accountDeletionGracePeriod),
+		}
+		return tx.Set(ref, deletion)
+	})
+	if err != nil {
+		log.Error("Failed to schedule account deletion", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to schedule account deletion")
+		return
+	}
+
+	authmw.InvalidateAccountDeletion(uid)
+
+	log.Info("Account deletion scheduled",
+		zap.String("uid", uid),
+		zap.Time("scheduledFor", deletion.ScheduledFor))
+
+	httpx.Accepted(w, deletion)
+}
+
+func getAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	doc, err := accountDeletionRef(uid).Get(r.Context())
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "No account deletion requested")
+			return
+		}
+		log.Error("Failed to get account deletion", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get account deletion")
+		return
+	}
+
+	var deletion AccountDeletion
+	if err := doc.DataTo(&deletion); err != nil {
+		log.Error("Failed to parse account deletion", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse account deletion")
+		return
+	}
+
+	httpx.Success(w, deletion)
+}
+
+func cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	ctx := r.Context()
+	ref := accountDeletionRef(uid)
+
+	var deletion AccountDeletion
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errDeletionNotFound
+			}
+			return err
+		}
+		if err := doc.DataTo(&deletion); err != nil {
+			return err
+		}
+		if deletion.Status != DeletionScheduled {
+			return errDeletionNotPending
+		}
+
+		now := time.Now()
+		deletion.Status = DeletionCancelled
+		deletion.CancelledAt = &now
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: DeletionCancelled},
+			{Path: "cancelledAt", Value: now},
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errDeletionNotFound):
+			httpx.NotFound(w, "No account deletion requested")
+		case errors.Is(err, errDeletionNotPending):
+			httpx.Conflict(w, "Account deletion can no longer be cancelled")
+		default:
+			log.Error("Failed to cancel account deletion", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to cancel account deletion")
+		}
+		return
+	}
+
+	authmw.InvalidateAccountDeletion(uid)
+
+	log.Info("Account deletion cancelled", zap.String("uid", uid))
+
+	httpx.Success(w, deletion)
+}
+
+// startAccountDeletions runs the deletion scheduler and handles account.deleted
+// and account.purged. Deletions need the broker, so nothing runs without one.
+func startAccountDeletions(ctx context.Context, conn *rabbitmq.Connection) error {
+	if conn == nil {
+		log.Warn("RabbitMQ unavailable, scheduled account deletions will not be processed")
+		return nil
+	}
+
+	err := conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		QueueName:   "profile-service.account-deletions",
+		RoutingKeys: []string{"account.deleted"},
+		Handler: func(body []byte) error {
+			var event AccountDeletedEvent
+			if err := json.Unmarshal(body, &event); err != nil || event.UID == "" {
+				log.Error("Failed to parse account.deleted event", zap.Error(err))
+				return nil
+			}
+			if err := purgeProfileData(ctx, event.UID); err != nil {
+				return err
+			}
+			return recordAccountPurge(ctx, event.UID, "profile")
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		QueueName:   "profile-service.account-purges",
+		RoutingKeys: []string{"account.purged"},
+		Handler: func(body []byte) error {
+			var event AccountPurgedEvent
+			if err := json.Unmarshal(body, &event); err != nil || event.UID == "" {
+				log.Error("Failed to parse account.purged event", zap.Error(err))
+				return nil
+			}
+			return recordAccountPurge(ctx, event.UID, event.Service)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(accountDeletionSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			processDueAccountDeletions(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// processDueAccountDeletions starts deletions whose grace period has ended and
+// republishes deletions that some service has not yet confirmed
+func processDueAccountDeletions(ctx context.Context) {
+	now := time.Now()
+	collection := firestoredb.GetClient().Collection(authmw.AccountDeletionCollection)
+
+	queries := []firestore.Query{
+		collection.Where("status", "==", DeletionScheduled).Where("scheduledFor", "<=", now),
+		collection.Where("status", "==", DeletionProcessing).Where("publishedAt", "<=", now.Add(-accountDeletionRetryAfter)),
+	}
+
+	for _, q := range queries {
+		iter := q.Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				log.Error("Failed to iterate account deletions", zap.Error(err))
+				break
+			}
+			if err := startAccountDeletion(ctx, doc.Ref.ID); err != nil && !errors.Is(err, errDeletionNotClaimable) {
+				log.Error("Failed to start account deletion", zap.String("uid", doc.Ref.ID), zap.Error(err))
+			}
+		}
+		iter.Stop()
+	}
+}
+
+// startAccountDeletion claims uid's deletion so only one instance publishes it,
+// then publishes account.deleted
+func startAccountDeletion(ctx context.Context, uid string) error {
+	ref := accountDeletionRef(uid)
+
+	var deletion AccountDeletion
+	now := time.Now()
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&deletion); err != nil {
+			return err
+		}
+
+		switch {
+		case deletion.Status == DeletionScheduled && !deletion.ScheduledFor.After(now):
+		case deletion.Status == DeletionProcessing && deletion.PublishedAt != nil &&
+			now.Sub(*deletion.PublishedAt) >= accountDeletionRetryAfter:
+		default:
+			return errDeletionNotClaimable
+		}
+
+		updates := []firestore.Update{
+			{Path: "status", Value: DeletionProcessing},
+			{Path: "publishedAt", Value: now},
+		}
+		if deletion.StartedAt == nil {
+			updates = append(updates, firestore.Update{Path: "startedAt", Value: now})
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Account deletion started", zap.String("uid", uid))
+
+	return rabbitConn.Publish(ctx, "account.deleted", AccountDeletedEvent{
+		UID:         uid,
+		RequestedAt: deletion.RequestedAt,
+		DeletedAt:   now,
+	})
+}
+
+// purgeProfileData deletes the profile, username reservations and Firebase Auth user
+func purgeProfileData(ctx context.Context, uid string) error {
+	client := firestoredb.GetClient()
+
+	if _, err := firestoredb.DeleteDocuments(ctx, client.Collection("usernames").Where("uid", "==", uid)); err != nil {
+		return fmt.Errorf("failed to delete username reservations: %w", err)
+	}
+
+	keyIDs, err := firestoredb.DeleteDocuments(ctx, client.Collection(authmw.APIKeyCollection).Where("uid", "==", uid))
+	for _, id := range keyIDs {
+		authmw.InvalidateAPIKey(id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete API keys: %w", err)
+	}
+
+	if _, err := client.Collection("users").Doc(uid).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", uid, err)
+	}
+
+	searchIndex.Delete(uid)
+	publishProfileUpdated(ctx, uid)
+
+	if err := firebaseapp.GetAuthClient().DeleteUser(ctx, uid); err != nil && !auth.IsUserNotFound(err) {
+		return fmt.Errorf("failed to delete auth user %s: %w", uid, err)
+	}
+
+	log.Info("Profile data purged", zap.String("uid", uid))
+	return nil
+}
+
+// recordAccountPurge marks service as done for uid's deletion, completing it
+// once every service has reported
+func recordAccountPurge(ctx context.Context, uid, service string) error {
+	ref := accountDeletionRef(uid)
+
+	completed := false
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				log.Warn("account.purged for unknown deletion", zap.String("uid", uid))
+				return nil
+			}
+			return err
+		}
+
+		var deletion AccountDeletion
+		if err := doc.DataTo(&deletion); err != nil {
+			return err
+		}
+		if deletion.Status == DeletionCompleted {
+			return nil
+		}
+
+		now := time.Now()
+		updates := []firestore.Update{
+			{FieldPath: firestore.FieldPath{"purged", service}, Value: now},
+		}
+
+		completed = true
+		for _, s := range accountDeletionServices {
+			if _, ok := deletion.Purged[s]; !ok && s != service {
+				completed = false
+				break
+			}
+		}
+		if completed {
+			updates = append(updates,
+				firestore.Update{Path: "status", Value: DeletionCompleted},
+				firestore.Update{Path: "completedAt", Value: now},
+			)
+		}
+
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Account data purged by service", zap.String("uid", uid), zap.String("service", service))
+	if completed {
+		log.Info("Account deletion completed", zap.String("uid", uid))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/rabbitmq"
+)
+
+func accountDeletionOf(t *testing.T, client *firestore.Client, uid string) AccountDeletion {
+	t.Helper()
+	doc, err := client.Collection("accountDeletions").Doc(uid).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deletion AccountDeletion
+	if err := doc.DataTo(&deletion); err != nil {
+		t.Fatal(err)
+	}
+	return deletion
+}
+
+func TestAccountDeletionRequests(t *testing.T) {
+	useFirestore(t)
+
+	deletionRequest := func(method string, handler http.HandlerFunc) (int, AccountDeletion) {
+		t.Helper()
+		w := serve(t, "/me/deletion", handler, "alice", method, "/me/deletion", nil)
+		var deletion AccountDeletion
+		if w.Code < 300 {
+			decode(t, w, &deletion)
+		}
+		return w.Code, deletion
+	}
+
+	// Deletions cannot be scheduled without the broker that processes them
+	if code, _ := deletionRequest(http.MethodDelete, requestAccountDeletion); code != http.StatusServiceUnavailable {
+		t.Fatalf("request without RabbitMQ = %d, want 503", code)
+	}
+	if code, _ := deletionRequest(http.MethodGet, getAccountDeletion); code != http.StatusNotFound {
+		t.Errorf("get after a request without RabbitMQ = %d, want 404", code)
+	}
+	// The handler only checks that a broker is connected
+	rabbitConn = &rabbitmq.Connection{}
+	t.Cleanup(func() { rabbitConn = nil })
+
+	if code, _ := deletionRequest(http.MethodGet, getAccountDeletion); code != http.StatusNotFound {
+		t.Errorf("get before requesting = %d, want 404", code)
+	}
+	if code, _ := deletionRequest(http.MethodDelete, cancelAccountDeletion); code != http.StatusNotFound {
+		t.Errorf("cancel before requesting = %d, want 404", code)
+	}
+
+	code, scheduled := deletionRequest(http.MethodDelete, requestAccountDeletion)
+	if code != http.StatusAccepted || scheduled.Status != DeletionScheduled {
+		t.Fatalf("request = %d %+v, want 202 scheduled", code, scheduled)
+	}
+	if d := scheduled.ScheduledFor.Sub(scheduled.RequestedAt); d != accountDeletionGracePeriod {
+		t.Errorf("scheduled %v after the request, want %v", d, accountDeletionGracePeriod)
+	}
+
+	// Requesting again keeps the original schedule
+	_, again := deletionRequest(http.MethodDelete, requestAccountDeletion)
+	if !again.ScheduledFor.Equal(scheduled.ScheduledFor) {
+		t.Errorf("scheduledFor moved from %v to %v", scheduled.ScheduledFor, again.ScheduledFor)
+	}
+
+	code, cancelled := deletionRequest(http.MethodDelete, cancelAccountDeletion)
+	if code != http.StatusOK || cancelled.Status != DeletionCancelled || cancelled.CancelledAt == nil {
+		t.Errorf("cancel = %d %+v, want 200 cancelled", code, cancelled)
+	}
+	if code, _ := deletionRequest(http.MethodDelete, cancelAccountDeletion); code != http.StatusConflict {
+		t.Errorf("cancel twice = %d, want 409", code)
+	}
+	if code, got := deletionRequest(http.MethodGet, getAccountDeletion); code != http.StatusOK || got.Status != DeletionCancelled {
+		t.Errorf("get = %d %+v, want 200 cancelled", code, got)
+	}
+
+	// A cancelled deletion can be requested again
+	if code, got := deletionRequest(http.MethodDelete, requestAccountDeletion); code != http.StatusAccepted || got.Status != DeletionScheduled {
+		t.Errorf("request after cancelling = %d %+v, want 202 scheduled", code, got)
+	}
+}
+
+func TestStartAccountDeletion(t *testing.T) {
+	client := useFirestore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for uid, scheduledFor := range map[string]time.Time{"due": now.Add(-time.Minute), "later": now.Add(time.Hour)} {
+		deletion := AccountDeletion{UID: uid, Status: DeletionScheduled, RequestedAt: now, ScheduledFor: scheduledFor}
+		if _, err := client.Collection("accountDeletions").Doc(uid).Set(ctx, deletion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := startAccountDeletion(ctx, "later"); !errors.Is(err, errDeletionNotClaimable) {
+		t.Errorf("start before the grace period ended = %v, want errDeletionNotClaimable", err)
+	}
+
+	// The claim is recorded even though there is no broker to publish to
+	if err := startAccountDeletion(ctx, "due"); !errors.Is(err, rabbitmq.ErrNotConnected) {
+		t.Errorf("start = %v, want rabbitmq.ErrNotConnected", err)
+	}
+	deletion := accountDeletionOf(t, client, "due")
+	if deletion.Status != DeletionProcessing || deletion.StartedAt == nil || deletion.PublishedAt == nil {
+		t.Errorf("deletion = %+v, want processing", deletion)
+	}
+	if err := startAccountDeletion(ctx, "due"); !errors.Is(err, errDeletionNotClaimable) {
+		t.Errorf("start again before the retry interval = %v, want errDeletionNotClaimable", err)
+	}
+}
+
+func TestRecordAccountPurge(t *testing.T) {
+	client := useFirestore(t)
+	ctx := context.Background()
+	deletion := AccountDeletion{UID: "alice", Status: DeletionProcessing, RequestedAt: time.Now(), ScheduledFor: time.Now()}
+	if _, err := client.Collection("accountDeletions").Doc("alice").Set(ctx, deletion); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, service := range accountDeletionServices {
+		if err := recordAccountPurge(ctx, "alice", service); err != nil {
+			t.Fatal(err)
+		}
+		got := accountDeletionOf(t, client, "alice")
+		last := i == len(accountDeletionServices)-1
+		if (got.Status == DeletionCompleted) != last {
+			t.Errorf("after %s: status = %s", service, got.Status)
+		}
+		if _, ok := got.Purged[service]; !ok {
+			t.Errorf("after %s: purged = %v", service, got.Purged)
+		}
+	}
+
+	if err := recordAccountPurge(ctx, "nobody", "feed"); err != nil {
+		t.Errorf("purge of an unknown deletion = %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firebaseapp"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxAPIKeyNameLength = 100
+	maxAPIKeyRateLimit  = 10000
+	maxAPIKeyExpiryDays = 3650
+	// defaultRotationGrace is how long a rotated key's previous secret keeps working
+	defaultRotationGrace  = 24 * time.Hour
+	maxRotationGraceHours = 7 * 24
+)
+
+var errAPIKeyRevoked = errors.New("API key has been revoked")
+
+// CreateAPIKeyRequest represents the request body for issuing an API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	UID           string   `json:"uid"`
+	Scopes        []string `json:"scopes"`
+	RateLimit     int      `json:"rateLimit,omitempty"`
+	ExpiresInDays int      `json:"expiresInDays,omitempty"`
+}
+
+// RotateAPIKeyRequest represents the request body for rotating an API key
+type RotateAPIKeyRequest struct {
+	GracePeriodHours *int `json:"gracePeriodHours,omitempty"`
+}
+
+// IssuedAPIKey is returned once when a key is created or rotated
+type IssuedAPIKey struct {
+	APIKey authmw.APIKey `json:"apiKey"`
+	Key    string        `json:"key"`
+}
+
+func apiKeyRef(id string) *firestore.DocumentRef {
+	return firestoredb.GetClient().Collection(authmw.APIKeyCollection).Doc(id)
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminUID, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpx.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	v := httpx.NewValidator()
+	if v.Required("name", req.Name) {
+		v.MaxLength("name", req.Name, maxAPIKeyNameLength)
+	}
+	v.Required("uid", req.UID)
+	if v.Check(len(req.Scopes) > 0, "scopes", httpx.CodeRequired, "At least one scope is required") {
+		for i, scope := range req.Scopes {
+			v.OneOf(fmt.Sprintf("scopes[%d]", i), scope, authmw.Scopes...)
+		}
+	}
+	v.Check(req.RateLimit >= 0 && req.RateLimit <= maxAPIKeyRateLimit, "rateLimit", httpx.CodeInvalidValue,
+		fmt.Sprintf("Must be between 0 and %d requests per minute", maxAPIKeyRateLimit))
+	v.Check(req.ExpiresInDays >= 0 && req.ExpiresInDays <= maxAPIKeyExpiryDays, "expiresInDays", httpx.CodeInvalidValue,
+		fmt.Sprintf("Must be between 0 and %d days", maxAPIKeyExpiryDays))
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := firebaseapp.GetAuthClient().GetUser(ctx, req.UID); err != nil {
+		if auth.IsUserNotFound(err) {
+			httpx.NotFound(w, "User not found")
+			return
+		}
+		log.Error("Failed to get auth user", zap.String("uid", req.UID), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create API key")
+		return
+	}
+
+	id, err := authmw.NewAPIKeyID()
+	if err != nil {
+		log.Error("Failed to generate API key ID", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create API key")
+		return
+	}
+	key, hash, err := authmw.NewAPIKeySecret(id)
+	if err != nil {
+		log.Error("Failed to generate API key", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create API key")
+		return
+	}
+
+	now := time.Now()
+	apiKey := authmw.APIKey{
+		ID:        id,
+		Name:      req.Name,
+		UID:       req.UID,
+		Scopes:    req.Scopes,
+		RateLimit: req.RateLimit,
+		Hash:      hash,
+		CreatedBy: adminUID,
+		CreatedAt: now,
+	}
+	if apiKey.RateLimit == 0 {
+		apiKey.RateLimit = authmw.DefaultAPIKeyRateLimit
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if _, err := apiKeyRef(id).Create(ctx, apiKey); err != nil {
+		log.Error("Failed to create API key", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to create API key")
+		return
+	}
+
+	log.Info("API key created",
+		zap.String("keyId", id),
+		zap.String("uid", req.UID),
+		zap.Strings("scopes", req.Scopes),
+		zap.String("by", adminUID))
+
+	httpx.Created(w, IssuedAPIKey{APIKey: apiKey, Key: key})
+}
+
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	query := firestoredb.GetClient().Collection(authmw.APIKeyCollection).Query
+	if uid := r.URL.Query().Get("uid"); uid != "" {
+		query = query.Where("uid", "==", uid)
+	}
+
+	iter := query.Documents(r.Context())
+	defer iter.Stop()
+
+	keys := []authmw.APIKey{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Error("Failed to list API keys", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to list API keys")
+			return
+		}
+
+		var apiKey authmw.APIKey
+		if err := doc.DataTo(&apiKey); err != nil {
+			log.Error("Failed to parse API key", zap.String("keyId", doc.Ref.ID), zap.Error(err))
+			continue
+		}
+		apiKey.ID = doc.Ref.ID
+		keys = append(keys, apiKey)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	httpx.Success(w, map[string]interface{}{"apiKeys": keys})
+}
+
+func getAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	doc, err := apiKeyRef(id).Get(r.Context())
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "API key not found")
+			return
+		}
+		log.Error("Failed to get API key", zap.String("keyId", id), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get API key")
+		return
+	}
+
+	var apiKey authmw.APIKey
+	if err := doc.DataTo(&apiKey); err != nil {
+		log.Error("Failed to parse API key", zap.String("keyId", id), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get API key")
+		return
+	}
+	apiKey.ID = id
+
+	httpx.Success(w, apiKey)
+}
+
+// rotateAPIKey issues a new secret for {id}. The previous secret keeps
+// working for the grace period so partners can deploy the new one.
+func rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminUID, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	var req RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpx.BadRequest(w, "Invalid request body")
+			return
+		}
+	}
+
+	grace := defaultRotationGrace
+	if req.GracePeriodHours != nil {
+		v := httpx.NewValidator()
+		v.Check(*req.GracePeriodHours >= 0 && *req.GracePeriodHours <= maxRotationGraceHours, "gracePeriodHours", httpx.CodeInvalidValue,
+			fmt.Sprintf("Must be between 0 and %d hours", maxRotationGraceHours))
+		if !v.Valid() {
+			httpx.ValidationFailed(w, v.Errors())
+			return
+		}
+		grace = time.Duration(*req.GracePeriodHours) * time.Hour
+	}
+
+	id := chi.URLParam(r, "id")
+	key, hash, err := authmw.NewAPIKeySecret(id)
+	if err != nil {
+		log.Error("Failed to generate API key", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to rotate API key")
+		return
+	}
+
+	apiKey, err := rotateAPIKeyHash(r.Context(), id, hash, grace)
+	if err != nil {
+		switch {
+		case status.Code(err) == codes.NotFound:
+			httpx.NotFound(w, "API key not found")
+		case errors.Is(err, errAPIKeyRevoked):
+			httpx.Conflict(w, "API key has been revoked")
+		default:
+			log.Error("Failed to rotate API key", zap.String("keyId", id), zap.Error(err))
+			httpx.InternalServerError(w, "Failed to rotate API key")
+		}
+		return
+	}
+	authmw.InvalidateAPIKey(id)
+
+	log.Info("API key rotated", zap.String("keyId", id), zap.Duration("grace", grace), zap.String("by", adminUID))
+	httpx.Success(w, IssuedAPIKey{APIKey: apiKey, Key: key})
+}
+
+func rotateAPIKeyHash(ctx context.Context, id, hash string, grace time.Duration) (authmw.APIKey, error) {
+	ref := apiKeyRef(id)
+	var apiKey authmw.APIKey
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&apiKey); err != nil {
+			return err
+		}
+		apiKey.ID = id
+		if apiKey.RevokedAt != nil {
+			return errAPIKeyRevoked
+		}
+
+		now := time.Now()
+		previousExpiresAt := now.Add(grace)
+		apiKey.PreviousHash = apiKey.Hash
+		apiKey.PreviousExpiresAt = &previousExpiresAt
+		apiKey.Hash = hash
+		apiKey.RotatedAt = &now
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "hash", Value: apiKey.Hash},
+			{Path: "previousHash", Value: apiKey.PreviousHash},
+			{Path: "previousExpiresAt", Value: previousExpiresAt},
+			{Path: "rotatedAt", Value: now},
+		})
+	})
+	return apiKey, err
+}
+
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminUID, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	_, err := apiKeyRef(id).Update(r.Context(), []firestore.Update{
+		{Path: "revokedAt", Value: time.Now()},
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "API key not found")
+			return
+		}
+		log.Error("Failed to revoke API key", zap.String("keyId", id), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to revoke API key")
+		return
+	}
+	authmw.InvalidateAPIKey(id)
+
+	log.Info("API key revoked", zap.String("keyId", id), zap.String("by", adminUID))
+	w.WriteHeader(http.StatusNoContent)
+}
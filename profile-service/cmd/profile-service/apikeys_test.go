@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/authmw/authtest"
+)
+
+// useAuthUsers looks up API key owners in a test issuer, where every user is active
+func useAuthUsers(t *testing.T) {
+	t.Helper()
+	issuer, err := authtest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authmw.SetTokenVerifier(&authmw.FirebaseVerifier{Client: issuer})
+	t.Cleanup(func() { authmw.SetTokenVerifier(nil) })
+}
+
+// issueAPIKey stores a key for alice the way createAPIKey does and returns it
+func issueAPIKey(t *testing.T, id string) string {
+	t.Helper()
+	key, hash, err := authmw.NewAPIKeySecret(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := authmw.APIKey{
+		Name:      "partner",
+		UID:       "alice",
+		Scopes:    []string{authmw.ScopeProfileRead},
+		RateLimit: authmw.DefaultAPIKeyRateLimit,
+		Hash:      hash,
+		CreatedBy: "admin",
+		CreatedAt: time.Now(),
+	}
+	if _, err := apiKeyRef(id).Create(context.Background(), apiKey); err != nil {
+		t.Fatal(err)
+	}
+	// Drop anything cached under the same ID by an earlier test
+	authmw.InvalidateAPIKey(id)
+	return key
+}
+
+func verifies(key string) bool {
+	_, authErr := authmw.VerifyAPIKey(context.Background(), key, time.Now())
+	return authErr == nil
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	useFirestore(t)
+	useAuthUsers(t)
+	oldKey := issueAPIKey(t, "k1")
+
+	rotate := func(id string, body interface{}) (int, IssuedAPIKey) {
+		t.Helper()
+		w := serve(t, "/api-keys/{id}/rotate", rotateAPIKey, "admin", http.MethodPost, "/api-keys/"+id+"/rotate", body)
+		var issued IssuedAPIKey
+		if w.Code == http.StatusOK {
+			decode(t, w, &issued)
+		}
+		return w.Code, issued
+	}
+
+	code, issued := rotate("k1", nil)
+	if code != http.StatusOK || issued.Key == "" || issued.Key == oldKey {
+		t.Fatalf("rotate = %d %+v", code, issued)
+	}
+	if issued.APIKey.PreviousExpiresAt == nil || time.Until(*issued.APIKey.PreviousExpiresAt) < defaultRotationGrace-time.Minute {
+		t.Errorf("previousExpiresAt = %v, want the default grace period", issued.APIKey.PreviousExpiresAt)
+	}
+	if !verifies(issued.Key) || !verifies(oldKey) {
+		t.Error("new and previous keys should both work during the grace period")
+	}
+
+	// Rotating without a grace period retires the previous key immediately
+	noGrace := 0
+	code, second := rotate("k1", RotateAPIKeyRequest{GracePeriodHours: &noGrace})
+	if code != http.StatusOK {
+		t.Fatalf("rotate without grace = %d", code)
+	}
+	if !verifies(second.Key) || verifies(issued.Key) || verifies(oldKey) {
+		t.Error("only the newest key should work after rotating without grace")
+	}
+
+	tooLong := maxRotationGraceHours + 1
+	if code, _ := rotate("k1", RotateAPIKeyRequest{GracePeriodHours: &tooLong}); code != http.StatusBadRequest {
+		t.Errorf("rotate with too long a grace period = %d, want 400", code)
+	}
+	if code, _ := rotate("missing", nil); code != http.StatusNotFound {
+		t.Errorf("rotate missing key = %d, want 404", code)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	useFirestore(t)
+	useAuthUsers(t)
+	key := issueAPIKey(t, "k1")
+	if !verifies(key) {
+		t.Fatal("new key does not verify")
+	}
+
+	revoke := func(id string) int {
+		return serve(t, "/api-keys/{id}", revokeAPIKey, "admin", http.MethodDelete, "/api-keys/"+id, nil).Code
+	}
+	if code := revoke("k1"); code != http.StatusNoContent {
+		t.Fatalf("revoke = %d, want 204", code)
+	}
+	if verifies(key) {
+		t.Error("revoked key still verifies")
+	}
+	if code := revoke("missing"); code != http.StatusNotFound {
+		t.Errorf("revoke missing key = %d, want 404", code)
+	}
+
+	w := serve(t, "/api-keys/{id}/rotate", rotateAPIKey, "admin", http.MethodPost, "/api-keys/k1/rotate", nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("rotate revoked key = %d, want 409", w.Code)
+	}
+}
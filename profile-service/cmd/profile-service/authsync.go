@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firebaseapp"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// authSyncThrottle is the minimum time between Auth lookups for one user on login
+	authSyncThrottle = 5 * time.Minute
+	// authSyncInterval is how often every Auth user is reconciled with its profile
+	authSyncInterval = time.Hour
+	// authSyncBatchSize is how many profiles are read at once during a full sync
+	authSyncBatchSize = 100
+)
+
+// AuthStateChangedEvent is published to RabbitMQ when a user's Firebase Auth
+// email, verification or disabled state changes
+type AuthStateChangedEvent struct {
+	UID           string    `json:"uid"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"emailVerified"`
+	Disabled      bool      `json:"disabled"`
+	ChangedAt     time.Time `json:"changedAt"`
+}
+
+// authState is the part of the Firebase Auth user record mirrored on users
+type authState struct {
+	Email         string
+	EmailVerified bool
+	Disabled      bool
+}
+
+func authStateOf(record *auth.UserRecord) authState {
+	state := authState{
+		EmailVerified: record.EmailVerified,
+		Disabled:      record.Disabled,
+	}
+	if record.UserInfo != nil {
+		state.Email = record.Email
+	}
+	return state
+}
+
+func (u User) authState() authState {
+	return authState{Email: u.Email, EmailVerified: u.EmailVerified, Disabled: u.Disabled}
+}
+
+// syncAuthOnLogin refreshes user from Firebase Auth unless it was synced recently
+func syncAuthOnLogin(ctx context.Context, user *User) error {
+	if time.Since(user.AuthSyncedAt) < authSyncThrottle {
+		return nil
+	}
+
+	record, err := firebaseapp.GetAuthClient().GetUser(ctx, user.UID)
+	if err != nil {
+		return fmt.Errorf("failed to get auth user %s: %w", user.UID, err)
+	}
+	return reconcileAuthState(ctx, user, authStateOf(record))
+}
+
+// reconcileAuthState writes state to user's document, publishing events if
+// anything changed, and updates user in place
+func reconcileAuthState(ctx context.Context, user *User, state authState) error {
+	now := time.Now()
+	changed := user.authState() != state
+
+	updates := []firestore.Update{
+		{Path: "authSyncedAt", Value: now},
+	}
+	if changed {
+		updates = append(updates,
+			firestore.Update{Path: "email", Value: state.Email},
+			firestore.Update{Path: "emailVerified", Value: state.EmailVerified},
+			firestore.Update{Path: "disabled", Value: state.Disabled},
+			firestore.Update{Path: "updatedAt", Value: now},
+		)
+	}
+
+	docRef := firestoredb.GetClient().Collection("users").Doc(user.UID)
+	if _, err := docRef.Update(ctx, updates); err != nil {
+		return fmt.Errorf("failed to update auth state of %s: %w", user.UID, err)
+	}
+
+	user.AuthSyncedAt = now
+	if !changed {
+		return nil
+	}
+
+	log.Info("Auth state changed",
+		zap.String("uid", user.UID),
+		zap.Bool("emailVerified", state.EmailVerified),
+		zap.Bool("disabled", state.Disabled),
+		zap.Bool("emailChanged", user.Email != state.Email))
+
+	if user.Disabled != state.Disabled {
+		authmw.InvalidateRevocationCache(user.UID)
+	}
+
+	user.Email = state.Email
+	user.EmailVerified = state.EmailVerified
+	user.Disabled = state.Disabled
+	user.UpdatedAt = now
+
+	indexProfile(*user)
+	publishProfileUpdated(ctx, user.UID)
+	if err := trackOnboarding(ctx, user); err != nil {
+		log.Warn("Failed to track onboarding", zap.String("uid", user.UID), zap.Error(err))
+	}
+	publishEvent(ctx, "profile.auth_changed", AuthStateChangedEvent{
+		UID:           user.UID,
+		Email:         state.Email,
+		EmailVerified: state.EmailVerified,
+		Disabled:      state.Disabled,
+		ChangedAt:     now,
+	})
+	return nil
+}
+
+// startAuthSync periodically reconciles every Firebase Auth user with its profile,
+// catching changes made while the user is not logging in
+func startAuthSync(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(authSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := syncAllAuthUsers(ctx); err != nil {
+					log.Error("Failed to sync auth users", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+func syncAllAuthUsers(ctx context.Context) error {
+	iter := firebaseapp.GetAuthClient().Users(ctx, "")
+	var batch []*auth.UserRecord
+	synced := 0
+
+	for {
+		record, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate auth users: %w", err)
+		}
+
+		batch = append(batch, record.UserRecord)
+		if len(batch) == authSyncBatchSize {
+			n, err := syncAuthBatch(ctx, batch)
+			if err != nil {
+				return err
+			}
+			synced += n
+			batch = batch[:0]
+		}
+	}
+
+	n, err := syncAuthBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+	synced += n
+
+	log.Info("Auth users synced", zap.Int("changed", synced))
+	return nil
+}
+
+// syncAuthBatch reconciles records whose profile is out of date and returns how many changed
+func syncAuthBatch(ctx context.Context, records []*auth.UserRecord) (int, error) {
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	client := firestoredb.GetClient()
+	refs := make([]*firestore.DocumentRef, len(records))
+	for i, record := range records {
+		refs[i] = client.Collection("users").Doc(record.UID)
+	}
+
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	changed := 0
+	for i, doc := range docs {
+		// Users who have never opened the app have no profile yet
+		if !doc.Exists() {
+			continue
+		}
+
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			log.Error("Failed to parse user document", zap.String("uid", doc.Ref.ID), zap.Error(err))
+			continue
+		}
+		user.UID = doc.Ref.ID
+
+		state := authStateOf(records[i])
+		if user.authState() == state {
+			continue
+		}
+		if err := reconcileAuthState(ctx, &user, state); err != nil {
+			log.Error("Failed to reconcile auth state", zap.String("uid", user.UID), zap.Error(err))
+			continue
+		}
+		changed++
+	}
+
+	return changed, nil
+}
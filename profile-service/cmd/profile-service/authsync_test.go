@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/trustlink/common/firestoredb"
+)
+
+// useSearchIndex gives the test an empty search index
+func useSearchIndex(t *testing.T) SearchIndex {
+	t.Helper()
+	old := searchIndex
+	searchIndex = newMemoryIndex()
+	t.Cleanup(func() { searchIndex = old })
+	return searchIndex
+}
+
+func authRecord(uid, email string, verified, disabled bool) *auth.UserRecord {
+	return &auth.UserRecord{
+		UserInfo:      &auth.UserInfo{UID: uid, Email: email},
+		EmailVerified: verified,
+		Disabled:      disabled,
+	}
+}
+
+func userOf(t *testing.T, uid string) User {
+	t.Helper()
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		t.Fatal(err)
+	}
+	user.UID = uid
+	return user
+}
+
+func TestSyncAuthBatch(t *testing.T) {
+	client := useFirestore(t)
+	index := useSearchIndex(t)
+	synced := time.Now().Add(-time.Hour)
+	for _, user := range []User{
+		{UID: "same", DisplayName: "Same", Email: "same@example.com", EmailVerified: true, AuthSyncedAt: synced},
+		{UID: "verified", DisplayName: "Verified", Email: "old@example.com", AuthSyncedAt: synced},
+		{UID: "disabled", DisplayName: "Disabled", Email: "disabled@example.com", AuthSyncedAt: synced},
+	} {
+		addUser(t, client, user)
+		indexProfile(user)
+	}
+
+	changed, err := syncAuthBatch(context.Background(), []*auth.UserRecord{
+		authRecord("same", "same@example.com", true, false),
+		authRecord("verified", "new@example.com", true, false),
+		authRecord("disabled", "disabled@example.com", false, true),
+		authRecord("no-profile", "new-user@example.com", false, false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 2 {
+		t.Errorf("changed = %d, want 2", changed)
+	}
+
+	if user := userOf(t, "same"); !user.AuthSyncedAt.Equal(synced) {
+		t.Errorf("unchanged user was written: authSyncedAt = %v", user.AuthSyncedAt)
+	}
+	if user := userOf(t, "verified"); user.Email != "new@example.com" || !user.EmailVerified || user.AuthSyncedAt.Equal(synced) {
+		t.Errorf("verified user = %+v", user)
+	}
+	if user := userOf(t, "disabled"); !user.Disabled {
+		t.Errorf("disabled user = %+v", user)
+	}
+	if _, err := client.Collection("users").Doc("no-profile").Get(context.Background()); err == nil {
+		t.Error("profile created for an Auth user without one")
+	}
+
+	if index.Len() != 2 {
+		t.Errorf("search index has %d profiles, want the disabled one removed", index.Len())
+	}
+}
+
+func TestSyncAuthOnLoginThrottle(t *testing.T) {
+	useFirestore(t)
+
+	// Synced recently, so Firebase Auth is not consulted
+	user := User{UID: "alice", AuthSyncedAt: time.Now().Add(-authSyncThrottle / 2)}
+	if err := syncAuthOnLogin(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisabledProfileHidden(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{UID: "alice", DisplayName: "Alice", Disabled: true})
+
+	if w := serve(t, "/{uid}", getPublicProfile, "bob", http.MethodGet, "/alice", nil); w.Code != http.StatusNotFound {
+		t.Errorf("viewed by another user: status = %d, want 404", w.Code)
+	}
+	if w := serve(t, "/{uid}", getPublicProfile, "alice", http.MethodGet, "/alice", nil); w.Code != http.StatusOK {
+		t.Errorf("viewed by the owner: status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// profileCompleteThreshold is the completeness percentage at which onboarding is complete
+const profileCompleteThreshold = 80
+
+// onboardingStep is one thing a user can do to complete their profile
+type onboardingStep struct {
+	field  string
+	step   string
+	weight int
+	done   func(User) bool
+}
+
+// onboardingSteps are in the order the client should suggest them. Weights add up to 100.
+var onboardingSteps = []onboardingStep{
+	{field: "displayName", step: "add_display_name", weight: 15, done: func(u User) bool { return u.DisplayName != "" }},
+	{field: "username", step: "choose_username", weight: 15, done: func(u User) bool { return u.Username != "" }},
+	{field: "photoUrl", step: "add_photo", weight: 15, done: func(u User) bool { return u.PhotoURL != "" }},
+	{field: "emailVerified", step: "verify_email", weight: 10, done: func(u User) bool { return u.EmailVerified }},
+	{field: "profession", step: "add_profession", weight: 15, done: func(u User) bool { return u.Profession != "" }},
+	{field: "location", step: "add_location", weight: 10, done: func(u User) bool { return u.Location != "" }},
+	{field: "bio", step: "write_bio", weight: 10, done: func(u User) bool { return u.Bio != "" }},
+	{field: "birthday", step: "add_birthday", weight: 5, done: func(u User) bool { return u.Birthday != "" }},
+	{field: "gender", step: "add_gender", weight: 5, done: func(u User) bool { return u.Gender != "" }},
+}
+
+// OnboardingState is stored on users and records when each step was first done
+type OnboardingState struct {
+	Milestones  map[string]time.Time `firestore:"milestones,omitempty" json:"milestones,omitempty"`
+	CompletedAt *time.Time           `firestore:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// ProfileCompleteness summarizes which onboarding steps are left
+type ProfileCompleteness struct {
+	Percentage    int      `json:"percentage"`
+	Complete      bool     `json:"complete"`
+	MissingFields []string `json:"missingFields"`
+	NextStep      string   `json:"nextStep,omitempty"`
+}
+
+// ProfileCompletedEvent is published to RabbitMQ the first time a profile reaches the completeness threshold
+type ProfileCompletedEvent struct {
+	UID         string    `json:"uid"`
+	Percentage  int       `json:"percentage"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// completeness computes how complete u's profile is
+func (u User) completeness() ProfileCompleteness {
+	c := ProfileCompleteness{MissingFields: []string{}}
+	for _, step := range onboardingSteps {
+		if step.done(u) {
+			c.Percentage += step.weight
+			continue
+		}
+		c.MissingFields = append(c.MissingFields, step.field)
+		if c.NextStep == "" {
+			c.NextStep = step.step
+		}
+	}
+	c.Complete = c.Percentage >= profileCompleteThreshold
+	return c
+}
+
+// onboardingUpdates returns the updates recording milestones u has newly
+// reached, and whether this is the first time the profile is complete
+func onboardingUpdates(u User, now time.Time) ([]firestore.Update, bool) {
+	var updates []firestore.Update
+	var milestones map[string]time.Time
+	if u.Onboarding != nil {
+		milestones = u.Onboarding.Milestones
+	}
+
+	for _, step := range onboardingSteps {
+		if _, ok := milestones[step.step]; !ok && step.done(u) {
+			updates = append(updates, firestore.Update{
+				FieldPath: firestore.FieldPath{"onboarding", "milestones", step.step},
+				Value:     now,
+			})
+		}
+	}
+
+	completed := u.completeness().Complete && (u.Onboarding == nil || u.Onboarding.CompletedAt == nil)
+	if completed {
+		updates = append(updates, firestore.Update{
+			FieldPath: firestore.FieldPath{"onboarding", "completedAt"},
+			Value:     now,
+		})
+	}
+
+	return updates, completed
+}
+
+// trackOnboarding records newly reached milestones on user's document,
+// publishes profile.completed the first time the threshold is reached, and
+// sets user.Completeness
+func trackOnboarding(ctx context.Context, user *User) error {
+	c := user.completeness()
+	user.Completeness = &c
+
+	now := time.Now()
+	if updates, _ := onboardingUpdates(*user, now); len(updates) == 0 {
+		return nil
+	}
+
+	// Re-check inside a transaction so concurrent requests publish profile.completed once
+	docRef := firestoredb.GetClient().Collection("users").Doc(user.UID)
+	var onboarding *OnboardingState
+	completed := false
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+
+		var current User
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+
+		var updates []firestore.Update
+		updates, completed = onboardingUpdates(current, now)
+		onboarding = withMilestones(current.Onboarding, current, now, completed)
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Update(docRef, updates)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record onboarding milestones for %s: %w", user.UID, err)
+	}
+
+	user.Onboarding = onboarding
+	if completed {
+		log.Info("Profile completed", zap.String("uid", user.UID), zap.Int("percentage", user.Completeness.Percentage))
+		publishEvent(ctx, "profile.completed", ProfileCompletedEvent{
+			UID:         user.UID,
+			Percentage:  user.Completeness.Percentage,
+			CompletedAt: now,
+		})
+	}
+	return nil
+}
+
+// withMilestones returns a copy of state with the milestones u has reached filled in
+func withMilestones(state *OnboardingState, u User, now time.Time, completed bool) *OnboardingState {
+	next := &OnboardingState{Milestones: map[string]time.Time{}}
+	if state != nil {
+		for step, at := range state.Milestones {
+			next.Milestones[step] = at
+		}
+		next.CompletedAt = state.CompletedAt
+	}
+
+	for _, step := range onboardingSteps {
+		if _, ok := next.Milestones[step.step]; !ok && step.done(u) {
+			next.Milestones[step.step] = now
+		}
+	}
+	if completed {
+		next.CompletedAt = &now
+	}
+	return next
+}
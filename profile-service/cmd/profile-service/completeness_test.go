@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOnboardingStepWeights(t *testing.T) {
+	total := 0
+	for _, step := range onboardingSteps {
+		total += step.weight
+	}
+	if total != 100 {
+		t.Errorf("onboarding step weights add up to %d, want 100", total)
+	}
+}
+
+func TestCompleteness(t *testing.T) {
+	tests := []struct {
+		name string
+		user User
+		want ProfileCompleteness
+	}{
+		{
+			name: "empty",
+			user: User{},
+			want: ProfileCompleteness{
+				MissingFields: []string{"displayName", "username", "photoUrl", "emailVerified", "profession", "location", "bio", "birthday", "gender"},
+				NextStep:      "add_display_name",
+			},
+		},
+		{
+			name: "partial",
+			user: User{DisplayName: "Alice", Username: "alice", EmailVerified: true},
+			want: ProfileCompleteness{
+				Percentage:    40,
+				MissingFields: []string{"photoUrl", "profession", "location", "bio", "birthday", "gender"},
+				NextStep:      "add_photo",
+			},
+		},
+		{
+			name: "at the threshold",
+			user: User{DisplayName: "Alice", Username: "alice", PhotoURL: "https://example.com/a.png", EmailVerified: true, Profession: "engineer", Location: "berlin"},
+			want: ProfileCompleteness{
+				Percentage:    80,
+				Complete:      true,
+				MissingFields: []string{"bio", "birthday", "gender"},
+				NextStep:      "write_bio",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.completeness(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("completeness = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackOnboarding(t *testing.T) {
+	client := useFirestore(t)
+	ctx := context.Background()
+	user := User{UID: "alice", DisplayName: "Alice", Username: "alice"}
+	addUser(t, client, user)
+
+	if err := trackOnboarding(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Completeness == nil || user.Completeness.Percentage != 30 {
+		t.Fatalf("completeness = %+v, want 30%%", user.Completeness)
+	}
+	stored := userOf(t, "alice")
+	if stored.Onboarding == nil || len(stored.Onboarding.Milestones) != 2 || stored.Onboarding.CompletedAt != nil {
+		t.Fatalf("onboarding = %+v, want two milestones", stored.Onboarding)
+	}
+	first := stored.Onboarding.Milestones["add_display_name"]
+
+	// Completing the profile records the new milestones and completedAt once
+	user = stored
+	user.PhotoURL = "https://example.com/a.png"
+	user.EmailVerified = true
+	user.Profession = "engineer"
+	user.Location = "berlin"
+	if _, err := client.Collection("users").Doc("alice").Set(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := trackOnboarding(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	stored = userOf(t, "alice")
+	if len(stored.Onboarding.Milestones) != 6 || stored.Onboarding.CompletedAt == nil {
+		t.Fatalf("onboarding = %+v, want six milestones and completedAt", stored.Onboarding)
+	}
+	if !stored.Onboarding.Milestones["add_display_name"].Equal(first) {
+		t.Error("existing milestone overwritten")
+	}
+	completedAt := *stored.Onboarding.CompletedAt
+
+	// Tracking again records nothing new and keeps the first completedAt
+	if updates, completed := onboardingUpdates(stored, time.Now()); len(updates) != 0 || completed {
+		t.Errorf("onboardingUpdates for a tracked profile = %v, %v", updates, completed)
+	}
+	if err := trackOnboarding(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if got := userOf(t, "alice").Onboarding.CompletedAt; !got.Equal(completedAt) {
+		t.Errorf("completedAt moved from %v to %v", completedAt, got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportSection is one JSON file of a data export, read from a single
+// document or from every document matched by one or more queries
+type exportSection struct {
+	file    string
+	doc     *firestore.DocumentRef
+	queries []firestore.Query
+}
+
+// exportSections lists everything a user owns across services
+func exportSections(uid string) []exportSection {
+	client := firestoredb.GetClient()
+	return []exportSection{
+		{file: "profile.json", doc: client.Collection("users").Doc(uid)},
+		{file: "usernames.json", queries: []firestore.Query{
+			client.Collection("usernames").Where("uid", "==", uid),
+		}},
+		{file: "posts.json", queries: []firestore.Query{
+			client.Collection("posts").Where("authorUid", "==", uid),
+		}},
+		{file: "comments.json", queries: []firestore.Query{
+			client.Collection("comments").Where("authorUid", "==", uid),
+		}},
+		// Inbound blocks are left out so the export does not reveal who blocked the user
+		{file: "relationships.json", queries: []firestore.Query{
+			client.Collection("relationships").Where("fromUid", "==", uid),
+			client.Collection("relationships").Where("toUid", "==", uid).
+				Where("status", "in", []string{relationshipRequested, relationshipAccepted, relationshipRejected}),
+		}},
+		{file: "endorsements_given.json", queries: []firestore.Query{
+			client.Collection("endorsements").Where("fromUid", "==", uid),
+		}},
+		{file: "endorsements_received.json", queries: []firestore.Query{
+			client.Collection("endorsements").Where("toUid", "==", uid),
+		}},
+		{file: "trust_score.json", doc: client.Collection("trustScores").Doc(uid)},
+		{file: "notifications.json", queries: []firestore.Query{
+			client.Collection("notifications").Where("uid", "==", uid),
+		}},
+		{file: "device_tokens.json", queries: []firestore.Query{
+			client.Collection("deviceTokens").Where("uid", "==", uid),
+		}},
+		{file: "account_deletion.json", doc: accountDeletionRef(uid)},
+	}
+}
+
+func exportAccountData(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now().UTC()
+
+	// Build the whole archive before writing so failures can still return an error
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := []string{}
+	for _, section := range exportSections(uid) {
+		data, err := readExportSection(ctx, section)
+		if err != nil {
+			log.Error("Failed to read export section", zap.String("file", section.file), zap.Error(err))
+			httpx.InternalServerError(w, "Failed to export account data")
+			return
+		}
+		if err := writeExportFile(archive, section.file, now, data); err != nil {
+			log.Error("Failed to write export file", zap.String("file", section.file), zap.Error(err))
+			httpx.InternalServerError(w, "Failed to export account data")
+			return
+		}
+		files = append(files, section.file)
+	}
+
+	manifest := map[string]interface{}{
+		"uid":        uid,
+		"exportedAt": now,
+		"files":      files,
+	}
+	if err := writeExportFile(archive, "manifest.json", now, manifest); err != nil {
+		log.Error("Failed to write export manifest", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to export account data")
+		return
+	}
+	if err := archive.Close(); err != nil {
+		log.Error("Failed to finish export archive", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to export account data")
+		return
+	}
+
+	log.Info("Account data exported", zap.String("uid", uid), zap.Int("bytes", buf.Len()))
+
+	filename := fmt.Sprintf("trustlink-export-%s.zip", now.Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// readExportSection returns the section's document (nil if missing) or the
+// documents matched by its queries, each with its ID
+func readExportSection(ctx context.Context, section exportSection) (interface{}, error) {
+	if section.doc != nil {
+		doc, err := section.doc.Get(ctx)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return exportDocument(doc), nil
+	}
+
+	docs := []map[string]interface{}{}
+	for _, q := range section.queries {
+		iter := q.Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, err
+			}
+			docs = append(docs, exportDocument(doc))
+		}
+		iter.Stop()
+	}
+	return docs, nil
+}
+
+func exportDocument(doc *firestore.DocumentSnapshot) map[string]interface{} {
+	data := doc.Data()
+	data["id"] = doc.Ref.ID
+	return data
+}
+
+func writeExportFile(archive *zip.Writer, name string, modified time.Time, data interface{}) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// exportFiles downloads uid's export and returns its files by name
+func exportFiles(t *testing.T, uid string) map[string][]byte {
+	t.Helper()
+	w := serve(t, "/me/export", exportAccountData, uid, http.MethodGet, "/me/export", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		buf.ReadFrom(rc)
+		rc.Close()
+		files[f.Name] = buf.Bytes()
+	}
+	return files
+}
+
+func TestExportAccountData(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{UID: "alice", DisplayName: "Alice"})
+	addRelationship(t, client, "alice", "bob", relationshipAccepted)
+	addRelationship(t, client, "carol", "alice", relationshipRequested)
+	addRelationship(t, client, "dave", "alice", relationshipBlocked)
+	addRelationship(t, client, "alice", "erin", relationshipBlocked)
+
+	files := exportFiles(t, "alice")
+	for _, section := range exportSections("alice") {
+		if _, ok := files[section.file]; !ok {
+			t.Errorf("export has no %s", section.file)
+		}
+	}
+
+	var profile map[string]interface{}
+	if err := json.Unmarshal(files["profile.json"], &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile["displayName"] != "Alice" || profile["id"] != "alice" {
+		t.Errorf("profile.json = %v", profile)
+	}
+
+	// Blocks made by others stay out of the export
+	var relationships []map[string]interface{}
+	if err := json.Unmarshal(files["relationships.json"], &relationships); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, rel := range relationships {
+		ids = append(ids, rel["id"].(string))
+	}
+	sort.Strings(ids)
+	want := []string{createRelationshipID("alice", "bob"), createRelationshipID("alice", "carol"), createRelationshipID("alice", "erin")}
+	sort.Strings(want)
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("relationships = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/svcauth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ProfileSummary is the part of a profile other services denormalize
+type ProfileSummary struct {
+	UID         string `json:"uid"`
+	DisplayName string `json:"displayName"`
+	Username    string `json:"username,omitempty"`
+	PhotoURL    string `json:"photoUrl,omitempty"`
+	Profession  string `json:"profession,omitempty"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// getProfileSummary serves /internal/profiles/{uid}/summary to trusted services
+func getProfileSummary(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+	caller, _ := svcauth.GetService(r.Context())
+
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(r.Context())
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "Profile not found")
+			return
+		}
+		log.Error("Failed to get user document", zap.String("uid", uid), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get profile")
+		return
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		log.Error("Failed to parse user document", zap.String("uid", uid), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse profile")
+		return
+	}
+
+	log.Debug("Served profile summary", zap.String("uid", uid), zap.String("service", caller))
+	httpx.Success(w, ProfileSummary{
+		UID:         uid,
+		DisplayName: user.DisplayName,
+		Username:    user.Username,
+		PhotoURL:    user.PhotoURL,
+		Profession:  user.Profession,
+		Disabled:    user.Disabled,
+	})
+}
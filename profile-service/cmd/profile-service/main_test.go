@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb/firestoretest"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// useFirestore serves an empty in-memory Firestore for the test
+func useFirestore(t *testing.T) *firestore.Client {
+	t.Helper()
+	server, err := firestoretest.Install()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	usernamesBackfilled.Store(true)
+	t.Cleanup(func() { usernamesBackfilled.Store(false) })
+	return server.Client()
+}
+
+// addUser stores user under its UID
+func addUser(t *testing.T, client *firestore.Client, user User) {
+	t.Helper()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+		user.UpdatedAt = user.CreatedAt
+	}
+	if _, err := client.Collection("users").Doc(user.UID).Set(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addRelationship stores a relationship the way connections-service does
+func addRelationship(t *testing.T, client *firestore.Client, from, to, status string) {
+	t.Helper()
+	rel := map[string]interface{}{"fromUid": from, "toUid": to, "status": status}
+	if _, err := client.Collection("relationships").Doc(createRelationshipID(from, to)).Set(context.Background(), rel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve routes a request from uid to handler mounted at pattern and returns the recorded response
+func serve(t *testing.T, pattern string, handler http.HandlerFunc, uid, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var r *http.Request
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = httptest.NewRequest(method, target, strings.NewReader(string(encoded)))
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if uid != "" {
+		r = r.WithContext(context.WithValue(r.Context(), authmw.UserIDKey, uid))
+	}
+
+	router := chi.NewRouter()
+	router.Method(method, pattern, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// decode unmarshals a JSON response body into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+}
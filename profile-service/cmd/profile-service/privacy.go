@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// Visibility controls who can see a profile field
+type Visibility string
+
+const (
+	VisibilityEveryone    Visibility = "everyone"
+	VisibilityConnections Visibility = "connections"
+	VisibilityOnlyMe      Visibility = "only_me"
+)
+
+// PrivacySettings holds per-field visibility for sensitive profile fields
+type PrivacySettings struct {
+	Email    Visibility `firestore:"email,omitempty" json:"email"`
+	Birthday Visibility `firestore:"birthday,omitempty" json:"birthday"`
+	Gender   Visibility `firestore:"gender,omitempty" json:"gender"`
+	Location Visibility `firestore:"location,omitempty" json:"location"`
+}
+
+// UpdatePrivacyRequest represents the request body for privacy updates
+type UpdatePrivacyRequest struct {
+	Email    *Visibility `json:"email,omitempty"`
+	Birthday *Visibility `json:"birthday,omitempty"`
+	Gender   *Visibility `json:"gender,omitempty"`
+	Location *Visibility `json:"location,omitempty"`
+}
+
+// defaultPrivacySettings are applied to new profiles and to fields never set
+func defaultPrivacySettings() PrivacySettings {
+	return PrivacySettings{
+		Email:    VisibilityOnlyMe,
+		Birthday: VisibilityConnections,
+		Gender:   VisibilityConnections,
+		Location: VisibilityEveryone,
+	}
+}
+
+// withDefaults fills unset fields from the defaults
+func (p *PrivacySettings) withDefaults() PrivacySettings {
+	settings := defaultPrivacySettings()
+	if p == nil {
+		return settings
+	}
+	if p.Email != "" {
+		settings.Email = p.Email
+	}
+	if p.Birthday != "" {
+		settings.Birthday = p.Birthday
+	}
+	if p.Gender != "" {
+		settings.Gender = p.Gender
+	}
+	if p.Location != "" {
+		settings.Location = p.Location
+	}
+	return settings
+}
+
+// allows reports whether a viewer with relationship rel can see a field with this visibility
+func (v Visibility) allows(rel RelationshipState) bool {
+	if rel.Status == relationshipSelf {
+		return true
+	}
+	switch v {
+	case VisibilityEveryone:
+		return true
+	case VisibilityConnections:
+		return rel.Status == relationshipAccepted
+	default:
+		return false
+	}
+}
+
+func getPrivacySettings(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(r.Context())
+	if err != nil {
+		log.Error("Failed to get user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get privacy settings")
+		return
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		log.Error("Failed to parse user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse privacy settings")
+		return
+	}
+
+	httpx.Success(w, user.Privacy.withDefaults())
+}
+
+func updatePrivacySettings(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	var req UpdatePrivacyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpx.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	updates := []firestore.Update{
+		{Path: "updatedAt", Value: time.Now()},
+	}
+
+	v := httpx.NewValidator()
+	fields := []struct {
+		name  string
+		value *Visibility
+	}{
+		{"email", req.Email},
+		{"birthday", req.Birthday},
+		{"gender", req.Gender},
+		{"location", req.Location},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if v.OneOf(f.name, string(*f.value), string(VisibilityEveryone), string(VisibilityConnections), string(VisibilityOnlyMe)) {
+			updates = append(updates, firestore.Update{
+				FieldPath: firestore.FieldPath{"privacy", f.name},
+				Value:     string(*f.value),
+			})
+		}
+	}
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	ctx := r.Context()
+	docRef := firestoredb.GetClient().Collection("users").Doc(uid)
+
+	if _, err := docRef.Update(ctx, updates); err != nil {
+		log.Error("Failed to update privacy settings", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to update privacy settings")
+		return
+	}
+
+	log.Info("Privacy settings updated", zap.String("uid", uid))
+
+	doc, err := docRef.Get(ctx)
+	if err != nil {
+		log.Error("Failed to get updated user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get updated privacy settings")
+		return
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		log.Error("Failed to parse user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse privacy settings")
+		return
+	}
+
+	// Location visibility affects what is searchable
+	user.UID = uid
+	indexProfile(user)
+	publishProfileUpdated(ctx, uid)
+
+	httpx.Success(w, user.Privacy.withDefaults())
+}
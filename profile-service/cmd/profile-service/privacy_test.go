@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestToPublicProfile(t *testing.T) {
+	user := User{
+		UID:      "alice",
+		Email:    "alice@example.com",
+		Birthday: "1990-01-01",
+		Gender:   "female",
+		Location: "berlin",
+	}
+	custom := &PrivacySettings{
+		Email:    VisibilityConnections,
+		Birthday: VisibilityOnlyMe,
+		Location: VisibilityConnections,
+	}
+
+	self := RelationshipState{Status: relationshipSelf}
+	connection := RelationshipState{Status: relationshipAccepted, Direction: "incoming"}
+	pending := RelationshipState{Status: "requested", Direction: "outgoing"}
+	stranger := RelationshipState{Status: relationshipNone}
+
+	tests := []struct {
+		name    string
+		privacy *PrivacySettings
+		rel     RelationshipState
+		want    PublicProfile
+	}{
+		{"defaults for self", nil, self, PublicProfile{Email: "alice@example.com", Birthday: "1990-01-01", Gender: "female", Location: "berlin"}},
+		{"defaults for a connection", nil, connection, PublicProfile{Birthday: "1990-01-01", Gender: "female", Location: "berlin"}},
+		{"defaults for a pending request", nil, pending, PublicProfile{Location: "berlin"}},
+		{"defaults for a stranger", nil, stranger, PublicProfile{Location: "berlin"}},
+		{"custom for self", custom, self, PublicProfile{Email: "alice@example.com", Birthday: "1990-01-01", Gender: "female", Location: "berlin"}},
+		{"custom for a connection", custom, connection, PublicProfile{Email: "alice@example.com", Gender: "female", Location: "berlin"}},
+		{"custom for a stranger", custom, stranger, PublicProfile{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := user
+			user.Privacy = tt.privacy
+			got := toPublicProfile(user, tt.rel)
+			if got.UID != "alice" || got.Relationship != tt.rel {
+				t.Errorf("profile = %+v", got)
+			}
+			if got.Email != tt.want.Email || got.Birthday != tt.want.Birthday ||
+				got.Gender != tt.want.Gender || got.Location != tt.want.Location {
+				t.Errorf("email, birthday, gender, location = %q, %q, %q, %q, want %q, %q, %q, %q",
+					got.Email, got.Birthday, got.Gender, got.Location,
+					tt.want.Email, tt.want.Birthday, tt.want.Gender, tt.want.Location)
+			}
+		})
+	}
+}
+
+func TestUpdatePrivacySettings(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{UID: "alice"})
+
+	var settings PrivacySettings
+	w := serve(t, "/me/privacy", getPrivacySettings, "alice", http.MethodGet, "/me/privacy", nil)
+	decode(t, w, &settings)
+	if settings != defaultPrivacySettings() {
+		t.Errorf("settings before any update = %+v, want the defaults", settings)
+	}
+
+	hidden := VisibilityOnlyMe
+	w = serve(t, "/me/privacy", updatePrivacySettings, "alice", http.MethodPatch, "/me/privacy", UpdatePrivacyRequest{Location: &hidden})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	decode(t, w, &settings)
+	want := defaultPrivacySettings()
+	want.Location = VisibilityOnlyMe
+	if settings != want {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+
+	invalid := Visibility("friends_of_friends")
+	w = serve(t, "/me/privacy", updatePrivacySettings, "alice", http.MethodPatch, "/me/privacy", UpdatePrivacyRequest{Email: &invalid})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid visibility: status = %d, want 400", w.Code)
+	}
+}
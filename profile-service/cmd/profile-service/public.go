@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Relationship status values as stored by connections-service, plus the
+// synthetic values used when there is no relationship document
+const (
+	relationshipNone      = "none"
+	relationshipSelf      = "self"
+	relationshipRequested = "requested"
+	relationshipAccepted  = "accepted"
+	relationshipRejected  = "rejected"
+	relationshipBlocked   = "blocked"
+)
+
+// PublicProfile is the projection of User returned to other users
+type PublicProfile struct {
+	UID               string            `json:"uid"`
+	DisplayName       string            `json:"displayName"`
+	Username          string            `json:"username"`
+	Email             string            `json:"email,omitempty"`
+	EmailVerified     bool              `json:"emailVerified"`
+	PhotoURL          string            `json:"photoUrl,omitempty"`
+	Profession        string            `json:"profession,omitempty"`
+	Birthday          string            `json:"birthday,omitempty"`
+	Gender            string            `json:"gender,omitempty"`
+	Location          string            `json:"location,omitempty"`
+	Bio               string            `json:"bio,omitempty"`
+	TrustScore        float64           `json:"trustScore,omitempty"`
+	EndorsementCounts map[string]int64  `json:"endorsementCounts,omitempty"`
+	CreatedAt         time.Time         `json:"createdAt"`
+	Relationship      RelationshipState `json:"relationship"`
+}
+
+// RelationshipState describes how the viewer relates to the profile owner
+type RelationshipState struct {
+	Status    string `json:"status"`
+	Direction string `json:"direction,omitempty"`
+}
+
+func getPublicProfile(w http.ResponseWriter, r *http.Request) {
+	viewerUID, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		httpx.BadRequest(w, "uid is required")
+		return
+	}
+
+	ctx := r.Context()
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "Profile not found")
+			return
+		}
+		log.Error("Failed to get user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get profile")
+		return
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		log.Error("Failed to parse user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse profile")
+		return
+	}
+	user.UID = uid
+
+	writePublicProfile(ctx, w, viewerUID, user)
+}
+
+func getPublicProfileByUsername(w http.ResponseWriter, r *http.Request) {
+	viewerUID, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		httpx.BadRequest(w, "username is required")
+		return
+	}
+
+	ctx := r.Context()
+	uid, found, err := lookupUsername(ctx, username)
+	if err != nil {
+		log.Error("Failed to look up username", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get profile")
+		return
+	}
+	if !found {
+		httpx.NotFound(w, "Profile not found")
+		return
+	}
+
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			httpx.NotFound(w, "Profile not found")
+			return
+		}
+		log.Error("Failed to get user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get profile")
+		return
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		log.Error("Failed to parse user document", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to parse profile")
+		return
+	}
+	user.UID = uid
+
+	writePublicProfile(ctx, w, viewerUID, user)
+}
+
+// writePublicProfile resolves the viewer's relationship with user and writes
+// the public projection, hiding profiles whose owner has blocked the viewer
+func writePublicProfile(ctx context.Context, w http.ResponseWriter, viewerUID string, user User) {
+	rel, err := getRelationshipState(ctx, viewerUID, user.UID)
+	if err != nil {
+		log.Error("Failed to get relationship", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to get profile")
+		return
+	}
+
+	if rel.Status == relationshipBlocked && rel.Direction == "incoming" {
+		httpx.NotFound(w, "Profile not found")
+		return
+	}
+
+	// Disabled accounts are hidden from everyone but their owner
+	if user.Disabled && rel.Status != relationshipSelf {
+		httpx.NotFound(w, "Profile not found")
+		return
+	}
+
+	httpx.Success(w, toPublicProfile(user, rel))
+}
+
+// toPublicProfile projects user for a viewer with relationship rel, dropping
+// sensitive fields the owner's privacy settings hide from that viewer
+func toPublicProfile(user User, rel RelationshipState) PublicProfile {
+	profile := PublicProfile{
+		UID:               user.UID,
+		DisplayName:       user.DisplayName,
+		Username:          user.Username,
+		EmailVerified:     user.EmailVerified,
+		PhotoURL:          user.PhotoURL,
+		Profession:        user.Profession,
+		Bio:               user.Bio,
+		TrustScore:        user.TrustScore,
+		EndorsementCounts: user.EndorsementCounts,
+		CreatedAt:         user.CreatedAt,
+		Relationship:      rel,
+	}
+
+	privacy := user.Privacy.withDefaults()
+	if privacy.Email.allows(rel) {
+		profile.Email = user.Email
+	}
+	if privacy.Birthday.allows(rel) {
+		profile.Birthday = user.Birthday
+	}
+	if privacy.Gender.allows(rel) {
+		profile.Gender = user.Gender
+	}
+	if privacy.Location.allows(rel) {
+		profile.Location = user.Location
+	}
+
+	return profile
+}
+
+// getRelationshipState reads the relationship between viewer and owner from
+// the relationships collection. Direction is "outgoing" when the viewer
+// initiated it and "incoming" when the owner did.
+func getRelationshipState(ctx context.Context, viewerUID, ownerUID string) (RelationshipState, error) {
+	if viewerUID == ownerUID {
+		return RelationshipState{Status: relationshipSelf}, nil
+	}
+
+	relationshipID := createRelationshipID(viewerUID, ownerUID)
+	doc, err := firestoredb.GetClient().Collection("relationships").Doc(relationshipID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return RelationshipState{Status: relationshipNone}, nil
+		}
+		return RelationshipState{}, err
+	}
+
+	data := doc.Data()
+	state := RelationshipState{Status: relationshipNone}
+	if s, ok := data["status"].(string); ok && s != "" {
+		state.Status = s
+	}
+
+	if fromUID, _ := data["fromUid"].(string); fromUID == viewerUID {
+		state.Direction = "outgoing"
+	} else {
+		state.Direction = "incoming"
+	}
+
+	return state, nil
+}
+
+func createRelationshipID(uid1, uid2 string) string {
+	// Same deterministic ID as connections-service
+	uids := []string{uid1, uid2}
+	sort.Strings(uids)
+	return uids[0] + "_" + uids[1]
+}
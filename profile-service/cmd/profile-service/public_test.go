@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetPublicProfile(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{
+		UID:         "alice",
+		DisplayName: "Alice",
+		Username:    "alice",
+		Email:       "alice@example.com",
+		Birthday:    "1990-01-01",
+		Profession:  "engineer",
+	})
+	addRelationship(t, client, "bob", "alice", relationshipAccepted)
+	addRelationship(t, client, "alice", "mallory", relationshipBlocked)
+	addRelationship(t, client, "eve", "alice", relationshipBlocked)
+
+	tests := []struct {
+		name          string
+		viewer        string
+		wantStatus    int
+		wantRelStatus string
+		wantDirection string
+	}{
+		{"self", "alice", http.StatusOK, relationshipSelf, ""},
+		{"connection", "bob", http.StatusOK, relationshipAccepted, "outgoing"},
+		{"stranger", "carol", http.StatusOK, relationshipNone, ""},
+		{"blocked by the owner", "mallory", http.StatusNotFound, "", ""},
+		{"blocked the owner", "eve", http.StatusOK, relationshipBlocked, "outgoing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, "/{uid}", getPublicProfile, tt.viewer, http.MethodGet, "/alice", nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			// The default privacy settings keep email and birthday from strangers
+			private := tt.wantRelStatus != relationshipSelf && tt.wantRelStatus != relationshipAccepted
+			if body := w.Body.String(); private && (strings.Contains(body, "alice@example.com") || strings.Contains(body, "1990-01-01")) {
+				t.Errorf("private fields in %s", body)
+			}
+			var profile PublicProfile
+			decode(t, w, &profile)
+			if profile.UID != "alice" || profile.Profession != "engineer" {
+				t.Errorf("profile = %+v", profile)
+			}
+			want := RelationshipState{Status: tt.wantRelStatus, Direction: tt.wantDirection}
+			if profile.Relationship != want {
+				t.Errorf("relationship = %+v, want %+v", profile.Relationship, want)
+			}
+		})
+	}
+
+	if w := serve(t, "/{uid}", getPublicProfile, "bob", http.MethodGet, "/nobody", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing profile: status = %d, want 404", w.Code)
+	}
+}
+
+func TestGetPublicProfileByUsername(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{UID: "u1", DisplayName: "Alice", Username: "alice"})
+	_, err := client.Collection("usernames").Doc("alice").Set(context.Background(), UsernameReservation{UID: "u1", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addRelationship(t, client, "u1", "mallory", relationshipBlocked)
+
+	w := serve(t, "/by-username/{username}", getPublicProfileByUsername, "bob", http.MethodGet, "/by-username/alice", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var profile PublicProfile
+	decode(t, w, &profile)
+	if profile.UID != "u1" || profile.Relationship.Status != relationshipNone {
+		t.Errorf("profile = %+v", profile)
+	}
+
+	for _, tt := range []struct{ viewer, username string }{
+		{"bob", "nobody"},
+		{"mallory", "alice"},
+	} {
+		w := serve(t, "/by-username/{username}", getPublicProfileByUsername, tt.viewer, http.MethodGet, "/by-username/"+tt.username, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s looking up %s: status = %d, want 404", tt.viewer, tt.username, w.Code)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firebaseapp"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// UserRoles is the response for the admin role endpoints
+type UserRoles struct {
+	UID   string   `json:"uid"`
+	Roles []string `json:"roles"`
+}
+
+// rolesOf returns the roles granted in a user's custom claims
+func rolesOf(claims map[string]interface{}) []string {
+	roles := []string{}
+	for _, role := range authmw.Roles {
+		if granted, _ := claims[role].(bool); granted {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func getUserRoles(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "uid")
+
+	record, err := firebaseapp.GetAuthClient().GetUser(r.Context(), uid)
+	if err != nil {
+		writeAuthUserError(w, uid, "Failed to get user roles", err)
+		return
+	}
+
+	httpx.Success(w, UserRoles{UID: uid, Roles: rolesOf(record.CustomClaims)})
+}
+
+func grantUserRole(w http.ResponseWriter, r *http.Request) {
+	setUserRole(w, r, true)
+}
+
+func revokeUserRole(w http.ResponseWriter, r *http.Request) {
+	setUserRole(w, r, false)
+}
+
+// setUserRole grants or revokes the {role} custom claim of {uid}. The change
+// reaches the user's ID token the next time it is refreshed.
+func setUserRole(w http.ResponseWriter, r *http.Request, granted bool) {
+	adminUID, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	uid := chi.URLParam(r, "uid")
+	role := chi.URLParam(r, "role")
+	if !authmw.IsRole(role) {
+		httpx.NotFound(w, "Unknown role")
+		return
+	}
+	if !granted && uid == adminUID && role == authmw.RoleAdmin {
+		httpx.Conflict(w, "You cannot revoke your own admin role")
+		return
+	}
+
+	roles, err := updateRoleClaim(r.Context(), uid, role, granted)
+	if err != nil {
+		writeAuthUserError(w, uid, "Failed to update user roles", err)
+		return
+	}
+
+	log.Info("User role changed",
+		zap.String("uid", uid),
+		zap.String("role", role),
+		zap.Bool("granted", granted),
+		zap.String("by", adminUID))
+
+	httpx.Success(w, UserRoles{UID: uid, Roles: roles})
+}
+
+// updateRoleClaim sets role on uid's custom claims, preserving every other claim
+func updateRoleClaim(ctx context.Context, uid, role string, granted bool) ([]string, error) {
+	authClient := firebaseapp.GetAuthClient()
+	record, err := authClient.GetUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{}, len(record.CustomClaims)+1)
+	for k, v := range record.CustomClaims {
+		claims[k] = v
+	}
+	if granted {
+		claims[role] = true
+	} else {
+		delete(claims, role)
+	}
+
+	if err := authClient.SetCustomUserClaims(ctx, uid, claims); err != nil {
+		return nil, fmt.Errorf("failed to set custom claims of %s: %w", uid, err)
+	}
+	return rolesOf(claims), nil
+}
+
+func writeAuthUserError(w http.ResponseWriter, uid, message string, err error) {
+	if auth.IsUserNotFound(err) {
+		httpx.NotFound(w, "User not found")
+		return
+	}
+	log.Error(message, zap.String("uid", uid), zap.Error(err))
+	httpx.InternalServerError(w, message)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRolesOf(t *testing.T) {
+	tests := []struct {
+		claims map[string]interface{}
+		want   []string
+	}{
+		{nil, []string{}},
+		{map[string]interface{}{"verified": true, "admin": true, "tier": "pro"}, []string{"admin", "verified"}},
+		{map[string]interface{}{"moderator": false, "admin": "true"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := rolesOf(tt.claims); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rolesOf(%v) = %v, want %v", tt.claims, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"github.com/trustlink/common/rabbitmq"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	// maxProximityFanout caps how many connections are expanded to find second-degree users
+	maxProximityFanout = 50
+)
+
+// ProfileUpdatedEvent is published to RabbitMQ when a profile changes
+type ProfileUpdatedEvent struct {
+	UID       string    `json:"uid"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SearchResult is a search hit with the caller's network distance to it
+type SearchResult struct {
+	SearchDocument
+	// Degree is 1 for connections, 2 for connections of connections and 0 otherwise
+	Degree int `json:"degree"`
+}
+
+var searchIndex SearchIndex = newMemoryIndex()
+
+func searchProfiles(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	query := r.URL.Query()
+	q := SearchQuery{
+		Prefix:     strings.TrimPrefix(strings.TrimSpace(query.Get("q")), "@"),
+		Profession: query.Get("profession"),
+		Location:   query.Get("location"),
+	}
+
+	v := httpx.NewValidator()
+	v.Check(q.Prefix != "" || q.Profession != "" || q.Location != "", "q", httpx.CodeRequired,
+		"q, profession or location is required")
+	v.MaxLength("q", q.Prefix, maxDisplayNameLength)
+	if !v.Valid() {
+		httpx.ValidationFailed(w, v.Errors())
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= maxSearchLimit {
+			limit = l
+		}
+	}
+
+	ctx := r.Context()
+	degrees, blocked, err := networkProximity(ctx, uid)
+	if err != nil {
+		log.Error("Failed to compute network proximity", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to search profiles")
+		return
+	}
+
+	prefix := normalizeSearchText(q.Prefix)
+	results := []SearchResult{}
+	for _, doc := range searchIndex.Search(q) {
+		if doc.UID == uid {
+			continue
+		}
+		if _, ok := blocked[doc.UID]; ok {
+			continue
+		}
+		results = append(results, SearchResult{SearchDocument: doc, Degree: degrees[doc.UID]})
+	}
+
+	// Closer users first (1st, then 2nd degree, then everyone else), then exact username matches
+	rank := func(degree int) int {
+		if degree == 0 {
+			return 3
+		}
+		return degree
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if ri, rj := rank(results[i].Degree), rank(results[j].Degree); ri != rj {
+			return ri < rj
+		}
+		ei := strings.ToLower(results[i].Username) == prefix
+		ej := strings.ToLower(results[j].Username) == prefix
+		return ei && !ej
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	httpx.Success(w, map[string]interface{}{
+		"results": results,
+		"count":   len(results),
+	})
+}
+
+// networkProximity returns the degree (1 or 2) of users near uid in the
+// accepted connection graph, and everyone with a block in either direction
+func networkProximity(ctx context.Context, uid string) (map[string]int, map[string]struct{}, error) {
+	degrees := map[string]int{}
+	blocked := map[string]struct{}{}
+
+	first, err := relatedUIDs(ctx, uid, relationshipAccepted)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range first {
+		degrees[id] = 1
+	}
+
+	for i, friend := range first {
+		if i >= maxProximityFanout {
+			break
+		}
+		second, err := relatedUIDs(ctx, friend, relationshipAccepted)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, id := range second {
+			if _, ok := degrees[id]; !ok && id != uid {
+				degrees[id] = 2
+			}
+		}
+	}
+
+	blockedUIDs, err := relatedUIDs(ctx, uid, relationshipBlocked)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range blockedUIDs {
+		blocked[id] = struct{}{}
+	}
+
+	return degrees, blocked, nil
+}
+
+// relatedUIDs returns the other party of every relationship of uid with the given status
+func relatedUIDs(ctx context.Context, uid, relStatus string) ([]string, error) {
+	client := firestoredb.GetClient()
+	var uids []string
+
+	for _, field := range []string{"fromUid", "toUid"} {
+		other := "toUid"
+		if field == "toUid" {
+			other = "fromUid"
+		}
+
+		iter := client.Collection("relationships").
+			Where(field, "==", uid).
+			Where("status", "==", relStatus).
+			Documents(ctx)
+
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, fmt.Errorf("failed to iterate relationships: %w", err)
+			}
+			if id, ok := doc.Data()[other].(string); ok {
+				uids = append(uids, id)
+			}
+		}
+		iter.Stop()
+	}
+
+	return uids, nil
+}
+
+// startSearchIndexing loads every profile into the search index and keeps it
+// in sync from profile.updated events when RabbitMQ is available. The index
+// is per process, so every instance consumes every event.
+func startSearchIndexing(ctx context.Context, conn *rabbitmq.Connection) error {
+	go func() {
+		if err := loadSearchIndex(ctx); err != nil {
+			log.Error("Failed to load search index", zap.Error(err))
+			return
+		}
+		log.Info("Search index loaded", zap.Int("profiles", searchIndex.Len()))
+	}()
+
+	if conn == nil {
+		return nil
+	}
+
+	return conn.Consume(ctx, rabbitmq.ConsumeOptions{
+		PerInstance: true,
+		RoutingKeys: []string{"profile.updated"},
+		Handler: func(body []byte) error {
+			var event ProfileUpdatedEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				log.Error("Failed to parse profile.updated event", zap.Error(err))
+				return nil
+			}
+			return reindexProfile(ctx, event.UID)
+		},
+	})
+}
+
+func loadSearchIndex(ctx context.Context) error {
+	iter := firestoredb.GetClient().Collection("users").Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate users: %w", err)
+		}
+
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			log.Error("Failed to parse user document", zap.Error(err))
+			continue
+		}
+		user.UID = doc.Ref.ID
+		indexProfile(user)
+	}
+}
+
+// reindexProfile reloads uid from Firestore into the search index
+func reindexProfile(ctx context.Context, uid string) error {
+	doc, err := firestoredb.GetClient().Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			searchIndex.Delete(uid)
+			return nil
+		}
+		return fmt.Errorf("failed to get user %s: %w", uid, err)
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		return fmt.Errorf("failed to parse user %s: %w", uid, err)
+	}
+	user.UID = uid
+	indexProfile(user)
+	return nil
+}
+
+// indexProfile adds user to the search index, or removes them if their account is disabled
+func indexProfile(user User) {
+	if user.Disabled {
+		searchIndex.Delete(user.UID)
+		return
+	}
+	searchIndex.Upsert(toSearchDocument(user))
+}
+
+// toSearchDocument projects user for search, only exposing location when it is public
+func toSearchDocument(user User) SearchDocument {
+	doc := SearchDocument{
+		UID:         user.UID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		PhotoURL:    user.PhotoURL,
+		Profession:  user.Profession,
+	}
+	if user.Privacy.withDefaults().Location == VisibilityEveryone {
+		doc.Location = user.Location
+	}
+	return doc
+}
+
+// publishProfileUpdated notifies the search index of every instance, and
+// other services, that uid's profile changed
+func publishProfileUpdated(ctx context.Context, uid string) {
+	publishEvent(ctx, "profile.updated", ProfileUpdatedEvent{
+		UID:       uid,
+		UpdatedAt: time.Now(),
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// SearchDocument is the indexed, publicly searchable view of a profile
+type SearchDocument struct {
+	UID         string `json:"uid"`
+	Username    string `json:"username,omitempty"`
+	DisplayName string `json:"displayName"`
+	PhotoURL    string `json:"photoUrl,omitempty"`
+	Profession  string `json:"profession,omitempty"`
+	Location    string `json:"location,omitempty"`
+}
+
+// SearchQuery filters documents by name prefix and profile fields. Empty
+// fields are not filtered on.
+type SearchQuery struct {
+	Prefix     string
+	Profession string
+	Location   string
+}
+
+// SearchIndex is a profile search index kept in sync with the users collection
+type SearchIndex interface {
+	Upsert(doc SearchDocument)
+	Delete(uid string)
+	Search(q SearchQuery) []SearchDocument
+	Len() int
+}
+
+// memoryIndex is an in-process SearchIndex using trigram posting lists over
+// username and displayName tokens. Each token is prefixed with '^' so that
+// token prefixes produce their own trigrams.
+type memoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]SearchDocument
+	trigrams map[string]map[string]struct{}
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{
+		docs:     make(map[string]SearchDocument),
+		trigrams: make(map[string]map[string]struct{}),
+	}
+}
+
+// Upsert adds or replaces doc in the index
+func (idx *memoryIndex) Upsert(doc SearchDocument) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if old, ok := idx.docs[doc.UID]; ok {
+		idx.unindex(old)
+	}
+	idx.docs[doc.UID] = doc
+
+	for _, token := range searchTokens(doc) {
+		for _, tri := range trigramsOf("^" + token) {
+			postings, ok := idx.trigrams[tri]
+			if !ok {
+				postings = make(map[string]struct{})
+				idx.trigrams[tri] = postings
+			}
+			postings[doc.UID] = struct{}{}
+		}
+	}
+}
+
+// Delete removes uid from the index
+func (idx *memoryIndex) Delete(uid string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if old, ok := idx.docs[uid]; ok {
+		idx.unindex(old)
+		delete(idx.docs, uid)
+	}
+}
+
+// Search returns documents matching q, ordered by display name
+func (idx *memoryIndex) Search(q SearchQuery) []SearchDocument {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	prefix := normalizeSearchText(q.Prefix)
+	profession := normalizeSearchText(q.Profession)
+	location := normalizeSearchText(q.Location)
+
+	var candidates map[string]struct{}
+	if utf8.RuneCountInString(prefix) >= 2 {
+		candidates = idx.candidates(prefix)
+	}
+
+	var results []SearchDocument
+	match := func(doc SearchDocument) {
+		if profession != "" && normalizeSearchText(doc.Profession) != profession {
+			return
+		}
+		if location != "" && normalizeSearchText(doc.Location) != location {
+			return
+		}
+		if prefix != "" && !hasTokenPrefix(doc, prefix) {
+			return
+		}
+		results = append(results, doc)
+	}
+
+	if candidates != nil {
+		for uid := range candidates {
+			match(idx.docs[uid])
+		}
+	} else {
+		for _, doc := range idx.docs {
+			match(doc)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return strings.ToLower(results[i].DisplayName) < strings.ToLower(results[j].DisplayName)
+	})
+	return results
+}
+
+// Len returns the number of indexed documents
+func (idx *memoryIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// candidates intersects the posting lists of the prefix's trigrams. Caller must hold idx.mu.
+func (idx *memoryIndex) candidates(prefix string) map[string]struct{} {
+	result := map[string]struct{}{}
+	for i, tri := range trigramsOf("^" + prefix) {
+		postings := idx.trigrams[tri]
+		if i == 0 {
+			for uid := range postings {
+				result[uid] = struct{}{}
+			}
+			continue
+		}
+		for uid := range result {
+			if _, ok := postings[uid]; !ok {
+				delete(result, uid)
+			}
+		}
+	}
+	return result
+}
+
+// unindex removes doc's trigram postings. Caller must hold idx.mu.
+func (idx *memoryIndex) unindex(doc SearchDocument) {
+	for _, token := range searchTokens(doc) {
+		for _, tri := range trigramsOf("^" + token) {
+			if postings, ok := idx.trigrams[tri]; ok {
+				delete(postings, doc.UID)
+				if len(postings) == 0 {
+					delete(idx.trigrams, tri)
+				}
+			}
+		}
+	}
+}
+
+// searchTokens returns the strings whose prefixes match doc: the username,
+// the full display name and each word of the display name
+func searchTokens(doc SearchDocument) []string {
+	var tokens []string
+	if username := normalizeSearchText(doc.Username); username != "" {
+		tokens = append(tokens, username)
+	}
+	if name := normalizeSearchText(doc.DisplayName); name != "" {
+		tokens = append(tokens, name)
+		words := strings.Fields(name)
+		if len(words) > 1 {
+			tokens = append(tokens, words...)
+		}
+	}
+	return tokens
+}
+
+func hasTokenPrefix(doc SearchDocument, prefix string) bool {
+	for _, token := range searchTokens(doc) {
+		if strings.HasPrefix(token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// trigramsOf returns the distinct 3-rune substrings of s
+func trigramsOf(s string) []string {
+	runes := []rune(s)
+	if len(runes) < 3 {
+		return []string{s}
+	}
+
+	seen := make(map[string]struct{}, len(runes))
+	trigrams := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		tri := string(runes[i : i+3])
+		if _, ok := seen[tri]; !ok {
+			seen[tri] = struct{}{}
+			trigrams = append(trigrams, tri)
+		}
+	}
+	return trigrams
+}
+
+func normalizeSearchText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
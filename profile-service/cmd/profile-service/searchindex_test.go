@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestIndex() *memoryIndex {
+	idx := newMemoryIndex()
+	idx.Upsert(SearchDocument{UID: "1", Username: "ada", DisplayName: "Ada Lovelace", Profession: "Engineer", Location: "London"})
+	idx.Upsert(SearchDocument{UID: "2", Username: "grace", DisplayName: "Grace Hopper", Profession: "engineer", Location: "New York"})
+	idx.Upsert(SearchDocument{UID: "3", Username: "alan_t", DisplayName: "Alan Turing", Profession: "Mathematician", Location: "London"})
+	idx.Upsert(SearchDocument{UID: "4", DisplayName: "Émilie du Châtelet", Profession: "Mathematician", Location: "Paris"})
+	return idx
+}
+
+func searchUIDs(idx *memoryIndex, q SearchQuery) []string {
+	uids := []string{}
+	for _, doc := range idx.Search(q) {
+		uids = append(uids, doc.UID)
+	}
+	return uids
+}
+
+func TestMemoryIndexSearch(t *testing.T) {
+	idx := newTestIndex()
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string
+	}{
+		{"everything, by display name", SearchQuery{}, []string{"1", "3", "2", "4"}},
+		{"one rune prefix", SearchQuery{Prefix: "a"}, []string{"1", "3"}},
+		{"two rune prefix", SearchQuery{Prefix: "al"}, []string{"3"}},
+		{"username prefix", SearchQuery{Prefix: "alan_"}, []string{"3"}},
+		{"later word of display name", SearchQuery{Prefix: "hop"}, []string{"2"}},
+		{"full display name", SearchQuery{Prefix: "Ada  Love"}, []string{"1"}},
+		{"case insensitive", SearchQuery{Prefix: "GRACE"}, []string{"2"}},
+		{"unicode", SearchQuery{Prefix: "châ"}, []string{"4"}},
+		{"one multibyte rune prefix", SearchQuery{Prefix: "É"}, []string{"4"}},
+		{"not a prefix", SearchQuery{Prefix: "ring"}, []string{}},
+		{"no match", SearchQuery{Prefix: "zzz"}, []string{}},
+		{"profession", SearchQuery{Profession: "ENGINEER"}, []string{"1", "2"}},
+		{"location and prefix", SearchQuery{Prefix: "a", Location: "london"}, []string{"1", "3"}},
+		{"all filters", SearchQuery{Prefix: "al", Profession: "mathematician", Location: "London"}, []string{"3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchUIDs(idx, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%+v) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryIndexUpsertReplaces(t *testing.T) {
+	idx := newTestIndex()
+	idx.Upsert(SearchDocument{UID: "1", Username: "countess", DisplayName: "Augusta King"})
+
+	if got := searchUIDs(idx, SearchQuery{Prefix: "lovelace"}); len(got) != 0 {
+		t.Errorf("old name still matches: %v", got)
+	}
+	if got := searchUIDs(idx, SearchQuery{Prefix: "aug"}); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("new name = %v, want [1]", got)
+	}
+	if idx.Len() != 4 {
+		t.Errorf("Len = %d, want 4", idx.Len())
+	}
+}
+
+func TestMemoryIndexDelete(t *testing.T) {
+	idx := newMemoryIndex()
+	idx.Upsert(SearchDocument{UID: "1", Username: "ada", DisplayName: "Ada Lovelace"})
+	idx.Delete("1")
+	idx.Delete("missing")
+
+	if idx.Len() != 0 {
+		t.Errorf("Len = %d, want 0", idx.Len())
+	}
+	if got := searchUIDs(idx, SearchQuery{Prefix: "ada"}); len(got) != 0 {
+		t.Errorf("deleted doc still matches: %v", got)
+	}
+	if len(idx.trigrams) != 0 {
+		t.Errorf("%d trigram posting lists left after delete", len(idx.trigrams))
+	}
+}
+
+func TestTrigramsOf(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"ab", []string{"ab"}},
+		{"abc", []string{"abc"}},
+		{"^ada", []string{"^ad", "ada"}},
+		{"aaaa", []string{"aaa"}},
+		{"çàé!", []string{"çàé", "àé!"}},
+	}
+
+	for _, tt := range tests {
+		if got := trigramsOf(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("trigramsOf(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firebaseapp"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+)
+
+// SessionsRevokedEvent is published to RabbitMQ when a user signs out everywhere
+type SessionsRevokedEvent struct {
+	UID       string    `json:"uid"`
+	RevokedAt time.Time `json:"revokedAt"`
+}
+
+// signOutEverywhere revokes every refresh token of the caller. ID tokens
+// already issued are rejected once AUTH_CHECK_REVOKED is enabled.
+func signOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	ctx := r.Context()
+	if err := firebaseapp.GetAuthClient().RevokeRefreshTokens(ctx, uid); err != nil {
+		log.Error("Failed to revoke refresh tokens", zap.String("uid", uid), zap.Error(err))
+		httpx.InternalServerError(w, "Failed to sign out")
+		return
+	}
+	authmw.InvalidateRevocationCache(uid)
+
+	log.Info("Signed out everywhere", zap.String("uid", uid))
+	publishEvent(ctx, "profile.sessions_revoked", SessionsRevokedEvent{
+		UID:       uid,
+		RevokedAt: time.Now(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/authmw"
+	"github.com/trustlink/common/firestoredb"
+	"github.com/trustlink/common/httpx"
+	"github.com/trustlink/common/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 30
+	// usernameHoldPeriod is how long a released username stays reserved for its previous owner
+	usernameHoldPeriod = 14 * 24 * time.Hour
+	// usernameBackfillRetry spaces out attempts to finish an interrupted backfill
+	usernameBackfillRetry = time.Minute
+)
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+	// reservedUsernames cannot be claimed by anyone
+	reservedUsernames = map[string]struct{}{
+		"admin": {}, "administrator": {}, "api": {}, "help": {}, "me": {},
+		"moderator": {}, "null": {}, "official": {}, "root": {}, "settings": {},
+		"staff": {}, "support": {}, "system": {}, "trustlink": {}, "undefined": {},
+	}
+
+	errUsernameTaken           = errors.New("username is taken")
+	errUsernameBackfillPending = errors.New("username reservations are still being backfilled")
+
+	// usernamesBackfilled is set once every legacy profile has a reservation.
+	// Until then an unreserved username cannot be claimed, since a legacy
+	// profile may hold it in a different case and Firestore cannot query
+	// case-insensitively.
+	usernamesBackfilled atomic.Bool
+)
+
+// UsernameReservation is stored in usernames/{lowercased username}
+type UsernameReservation struct {
+	UID       string    `firestore:"uid" json:"uid"`
+	Username  string    `firestore:"username" json:"username"`
+	CreatedAt time.Time `firestore:"createdAt" json:"createdAt"`
+	Released  bool      `firestore:"released" json:"released"`
+	HoldUntil time.Time `firestore:"holdUntil,omitempty" json:"holdUntil,omitempty"`
+}
+
+// claimableBy reports whether uid may claim this reservation at now
+func (res UsernameReservation) claimableBy(uid string, now time.Time) bool {
+	if res.UID == uid {
+		return true
+	}
+	return res.Released && now.After(res.HoldUntil)
+}
+
+func checkUsernameAvailability(w http.ResponseWriter, r *http.Request) {
+	uid, ok := authmw.GetUserID(r.Context())
+	if !ok {
+		httpx.Unauthorized(w, "User ID not found in context")
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	result := map[string]interface{}{
+		"username":  username,
+		"available": false,
+	}
+
+	if v := httpx.NewValidator(); !validateUsername(v, "username", username) {
+		result["reason"] = v.Errors()[0].Message
+		httpx.Success(w, result)
+		return
+	}
+
+	doc, err := usernameRef(username).Get(r.Context())
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Error("Failed to get username reservation", zap.Error(err))
+		httpx.InternalServerError(w, "Failed to check username")
+		return
+	}
+
+	if doc != nil && doc.Exists() {
+		var res UsernameReservation
+		if err := doc.DataTo(&res); err != nil {
+			log.Error("Failed to parse username reservation", zap.Error(err))
+			httpx.InternalServerError(w, "Failed to check username")
+			return
+		}
+		if !res.claimableBy(uid, time.Now()) {
+			result["reason"] = "username is taken"
+			httpx.Success(w, result)
+			return
+		}
+	}
+
+	result["available"] = true
+	httpx.Success(w, result)
+}
+
+// validateUsername checks length, charset and reserved words
+func validateUsername(v *httpx.Validator, field, username string) bool {
+	if !v.MinLength(field, username, minUsernameLength) || !v.MaxLength(field, username, maxUsernameLength) {
+		return false
+	}
+	if !v.Check(usernamePattern.MatchString(username), field, httpx.CodeInvalidFormat,
+		"username may only contain letters, numbers and underscores") {
+		return false
+	}
+	_, reserved := reservedUsernames[strings.ToLower(username)]
+	return v.Check(!reserved, field, "reserved", "username is reserved")
+}
+
+// reserveUsername claims newUsername for uid inside tx and releases
+// oldUsername with a hold period. All reads happen before any writes, so it
+// must be called before other writes in the transaction.
+func reserveUsername(tx *firestore.Transaction, uid, oldUsername, newUsername string) error {
+	now := time.Now()
+	newRef := usernameRef(newUsername)
+
+	doc, err := tx.Get(newRef)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	// Legacy usernames may have been set before reservations existed
+	releaseOld := false
+	if oldUsername != "" && !strings.EqualFold(oldUsername, newUsername) {
+		oldDoc, err := tx.Get(usernameRef(oldUsername))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil && oldDoc.Exists() {
+			owner, _ := oldDoc.Data()["uid"].(string)
+			releaseOld = owner == uid
+		}
+	}
+
+	createdAt := now
+	if doc != nil && doc.Exists() {
+		var res UsernameReservation
+		if err := doc.DataTo(&res); err != nil {
+			return err
+		}
+		if !res.claimableBy(uid, now) {
+			return errUsernameTaken
+		}
+		if res.UID == uid && !res.Released {
+			createdAt = res.CreatedAt
+		}
+	} else if !usernamesBackfilled.Load() {
+		return errUsernameBackfillPending
+	}
+
+	if err := tx.Set(newRef, UsernameReservation{
+		UID:       uid,
+		Username:  newUsername,
+		CreatedAt: createdAt,
+	}); err != nil {
+		return err
+	}
+
+	if releaseOld {
+		return tx.Update(usernameRef(oldUsername), []firestore.Update{
+			{Path: "released", Value: true},
+			{Path: "holdUntil", Value: now.Add(usernameHoldPeriod)},
+		})
+	}
+	return nil
+}
+
+// lookupUsername resolves an active username reservation to a uid, falling
+// back to the profile itself for usernames that have not been backfilled
+func lookupUsername(ctx context.Context, username string) (string, bool, error) {
+	doc, err := usernameRef(username).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return "", false, err
+		}
+
+		docs, err := legacyUsernameQuery(username).Documents(ctx).GetAll()
+		if err != nil || len(docs) == 0 {
+			return "", false, err
+		}
+		return docs[0].Ref.ID, true, nil
+	}
+
+	var res UsernameReservation
+	if err := doc.DataTo(&res); err != nil {
+		return "", false, err
+	}
+	if res.Released {
+		return "", false, nil
+	}
+	return res.UID, true, nil
+}
+
+// legacyUsernameQuery finds the profile holding username, for usernames set
+// before reservations existed
+func legacyUsernameQuery(username string) firestore.Query {
+	return firestoredb.GetClient().Collection("users").Where("username", "==", username).Limit(1)
+}
+
+// startUsernameBackfill reserves the usernames of profiles created before
+// reservations existed, in the background, retrying until every profile is
+// reserved. Completion is recorded so later starts skip it.
+func startUsernameBackfill(ctx context.Context) {
+	go func() {
+		for {
+			err := runUsernameBackfill(ctx)
+			if err == nil {
+				return
+			}
+			log.Error("Failed to backfill username reservations", zap.Error(err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(usernameBackfillRetry):
+			}
+		}
+	}()
+}
+
+// runUsernameBackfill backfills reservations unless a previous run completed,
+// then allows unreserved usernames to be claimed
+func runUsernameBackfill(ctx context.Context) error {
+	ref := usernameBackfillRef()
+	if _, err := ref.Get(ctx); err != nil {
+		if status.Code(err) != codes.NotFound {
+			return fmt.Errorf("failed to get backfill status: %w", err)
+		}
+
+		if err := backfillUsernames(ctx); err != nil {
+			return err
+		}
+		if _, err := ref.Set(ctx, map[string]interface{}{"completedAt": time.Now()}); err != nil {
+			return fmt.Errorf("failed to record backfill completion: %w", err)
+		}
+	}
+
+	usernamesBackfilled.Store(true)
+	return nil
+}
+
+// backfillUsernames reserves every legacy username. Usernames already held by
+// another profile in a different case are skipped; any other failure is
+// returned once every profile has been tried.
+func backfillUsernames(ctx context.Context) error {
+	client := firestoredb.GetClient()
+	iter := client.Collection("users").Where("username", ">", "").Documents(ctx)
+	defer iter.Stop()
+
+	reserved, failed := 0, 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate users: %w", err)
+		}
+
+		username, _ := doc.Data()["username"].(string)
+		created, err := backfillUsername(ctx, doc.Ref.ID, username)
+		if err != nil {
+			log.Warn("Failed to backfill username",
+				zap.String("uid", doc.Ref.ID),
+				zap.String("username", username),
+				zap.Error(err))
+			if !errors.Is(err, errUsernameTaken) {
+				failed++
+			}
+			continue
+		}
+		if created {
+			reserved++
+		}
+	}
+
+	log.Info("Username reservations backfilled", zap.Int("reserved", reserved), zap.Int("failed", failed))
+	if failed > 0 {
+		return fmt.Errorf("failed to reserve %d usernames", failed)
+	}
+	return nil
+}
+
+// backfillUsername reserves username for uid unless a reservation exists.
+// It reports whether one was created.
+func backfillUsername(ctx context.Context, uid, username string) (bool, error) {
+	ref := usernameRef(username)
+	created := false
+	err := firestoredb.GetClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		created = false
+		doc, err := tx.Get(ref)
+		if err == nil {
+			if owner, _ := doc.Data()["uid"].(string); owner != uid {
+				// Legacy usernames were not unique case-insensitively; the first one keeps it
+				return fmt.Errorf("already reserved by %s: %w", owner, errUsernameTaken)
+			}
+			return nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		created = true
+		return tx.Create(ref, UsernameReservation{
+			UID:       uid,
+			Username:  username,
+			CreatedAt: time.Now(),
+		})
+	})
+	return created, err
+}
+
+// usernameBackfillRef records that the username backfill has completed
+func usernameBackfillRef() *firestore.DocumentRef {
+	return firestoredb.GetClient().Collection("migrations").Doc("usernameReservations")
+}
+
+func usernameRef(username string) *firestore.DocumentRef {
+	return firestoredb.GetClient().Collection("usernames").Doc(strings.ToLower(username))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/trustlink/common/httpx"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		wantErr  bool
+	}{
+		{"alice", false},
+		{"Alice_99", false},
+		{strings.Repeat("a", maxUsernameLength), false},
+		{"al", true},
+		{strings.Repeat("a", maxUsernameLength+1), true},
+		{"alice smith", true},
+		{"alice-smith", true},
+		{"élise", true},
+		{"Admin", true},
+		{"support", true},
+	}
+	for _, tt := range tests {
+		v := httpx.NewValidator()
+		if ok := validateUsername(v, "username", tt.username); ok == tt.wantErr {
+			t.Errorf("validateUsername(%q) = %v (%v), wantErr %v", tt.username, ok, v.Errors(), tt.wantErr)
+		}
+	}
+}
+
+func setUsername(t *testing.T, uid, username string) int {
+	t.Helper()
+	return serve(t, "/me", updateProfile, uid, http.MethodPatch, "/me", UpdateProfileRequest{Username: &username}).Code
+}
+
+func reservation(t *testing.T, client *firestore.Client, username string) UsernameReservation {
+	t.Helper()
+	doc, err := client.Collection("usernames").Doc(username).Get(context.Background())
+	if err != nil {
+		t.Fatalf("reservation %q: %v", username, err)
+	}
+	var res UsernameReservation
+	if err := doc.DataTo(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestReserveUsername(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{UID: "alice"})
+	addUser(t, client, User{UID: "bob"})
+
+	steps := []struct {
+		name       string
+		uid        string
+		username   string
+		wantStatus int
+	}{
+		{"invalid", "alice", "a!", http.StatusBadRequest},
+		{"reserved", "alice", "admin", http.StatusBadRequest},
+		{"claim", "alice", "Alice", http.StatusOK},
+		{"claim again", "alice", "alice", http.StatusOK},
+		{"taken", "bob", "alice", http.StatusConflict},
+		{"taken in another case", "bob", "ALICE", http.StatusConflict},
+		{"rename", "alice", "alice2", http.StatusOK},
+		{"held for the previous owner", "bob", "alice", http.StatusConflict},
+		{"reclaimed by the previous owner", "alice", "alice", http.StatusOK},
+	}
+	for _, step := range steps {
+		if got := setUsername(t, step.uid, step.username); got != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", step.name, got, step.wantStatus)
+		}
+	}
+
+	if res := reservation(t, client, "alice"); res.UID != "alice" || res.Username != "alice" || res.Released {
+		t.Errorf("alice reservation = %+v", res)
+	}
+	res := reservation(t, client, "alice2")
+	if !res.Released || res.HoldUntil.Before(time.Now().Add(usernameHoldPeriod-time.Minute)) {
+		t.Errorf("alice2 reservation = %+v, want released with a hold period", res)
+	}
+
+	// Once the hold period is over anyone may claim it
+	_, err := client.Collection("usernames").Doc("alice2").Update(context.Background(), []firestore.Update{
+		{Path: "holdUntil", Value: time.Now().Add(-time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := setUsername(t, "bob", "alice2"); got != http.StatusOK {
+		t.Fatalf("claim after the hold period: status = %d, want 200", got)
+	}
+	if res := reservation(t, client, "alice2"); res.UID != "bob" || res.Released {
+		t.Errorf("alice2 reservation = %+v, want bob's", res)
+	}
+}
+
+func TestReserveUsernameConcurrently(t *testing.T) {
+	client := useFirestore(t)
+	uids := []string{"u1", "u2", "u3", "u4"}
+	for _, uid := range uids {
+		addUser(t, client, User{UID: uid})
+	}
+
+	statuses := make([]int, len(uids))
+	var wg sync.WaitGroup
+	for i, uid := range uids {
+		wg.Add(1)
+		go func(i int, uid string) {
+			defer wg.Done()
+			statuses[i] = setUsername(t, uid, "popular")
+		}(i, uid)
+	}
+	wg.Wait()
+
+	winners := 0
+	for i, code := range statuses {
+		switch code {
+		case http.StatusOK:
+			winners++
+			if res := reservation(t, client, "popular"); res.UID != uids[i] {
+				t.Errorf("reservation owner = %s, want the winner %s", res.UID, uids[i])
+			}
+		case http.StatusConflict:
+		default:
+			t.Errorf("%s: status = %d", uids[i], code)
+		}
+	}
+	if winners != 1 {
+		t.Errorf("%d users claimed the same username, want 1", winners)
+	}
+}
+
+func TestCheckUsernameAvailability(t *testing.T) {
+	client := useFirestore(t)
+	addUser(t, client, User{UID: "alice"})
+	if got := setUsername(t, "alice", "alice"); got != http.StatusOK {
+		t.Fatalf("claim status = %d", got)
+	}
+
+	tests := []struct {
+		uid, username string
+		want          bool
+	}{
+		{"bob", "alice", false},
+		{"bob", "Alice", false},
+		{"alice", "alice", true},
+		{"bob", "bob", true},
+		{"bob", "root", false},
+		{"bob", "x", false},
+	}
+	for _, tt := range tests {
+		w := serve(t, "/username/availability", checkUsernameAvailability, tt.uid, http.MethodGet, "/username/availability?username="+tt.username, nil)
+		var resp struct {
+			Available bool   `json:"available"`
+			Reason    string `json:"reason"`
+		}
+		decode(t, w, &resp)
+		if resp.Available != tt.want {
+			t.Errorf("%s checking %q: available = %v (%s), want %v", tt.uid, tt.username, resp.Available, resp.Reason, tt.want)
+		}
+	}
+}
+
+func TestLegacyUsernames(t *testing.T) {
+	client := useFirestore(t)
+	usernamesBackfilled.Store(false)
+	addUser(t, client, User{UID: "carol", Username: "carol"})
+	addUser(t, client, User{UID: "alice", Username: "Alice"})
+	addUser(t, client, User{UID: "bob"})
+
+	// Before the backfill the profile still holds the username
+	if uid, ok, err := lookupUsername(context.Background(), "carol"); err != nil || !ok || uid != "carol" {
+		t.Fatalf("lookupUsername before the backfill = %q, %v, %v", uid, ok, err)
+	}
+	// and no unreserved username can be claimed, whatever its case
+	for _, username := range []string{"carol", "alice", "dave"} {
+		if got := setUsername(t, "bob", username); got != http.StatusServiceUnavailable {
+			t.Fatalf("claiming %q before the backfill: status = %d, want 503", username, got)
+		}
+	}
+
+	if err := runUsernameBackfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res := reservation(t, client, "carol"); res.UID != "carol" {
+		t.Errorf("backfilled reservation owner = %q", res.UID)
+	}
+	// The backfill is idempotent
+	if created, err := backfillUsername(context.Background(), "carol", "carol"); err != nil || created {
+		t.Errorf("second backfill = %v, %v, want nothing created", created, err)
+	}
+	for _, username := range []string{"carol", "alice"} {
+		if got := setUsername(t, "bob", username); got != http.StatusConflict {
+			t.Errorf("claiming backfilled %q: status = %d, want 409", username, got)
+		}
+	}
+	if got := setUsername(t, "bob", "dave"); got != http.StatusOK {
+		t.Errorf("claiming a free username after the backfill: status = %d, want 200", got)
+	}
+
+	// A later start sees the recorded completion and skips the backfill
+	usernamesBackfilled.Store(false)
+	addUser(t, client, User{UID: "erin", Username: "erin"})
+	if err := runUsernameBackfill(context.Background()); err != nil || !usernamesBackfilled.Load() {
+		t.Fatalf("second run = %v, backfilled = %v", err, usernamesBackfilled.Load())
+	}
+	if doc, err := client.Collection("usernames").Doc("erin").Get(context.Background()); err == nil && doc.Exists() {
+		t.Error("backfill ran again after completing")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/trustlink/common/httpx"
+)
+
+const (
+	maxDisplayNameLength = 100
+	maxProfessionLength  = 100
+	maxLocationLength    = 100
+	maxBioLength         = 500
+	maxPhotoURLLength    = 2048
+	// minimumAge is the youngest age allowed by the terms of service
+	minimumAge = 13
+	// birthdayLayout is the accepted birthday format (ISO 8601 date)
+	birthdayLayout = "2006-01-02"
+)
+
+// genders are the accepted values for the gender field
+var genders = []string{"female", "male", "non_binary", "other", "prefer_not_to_say"}
+
+// validateProfileUpdate checks every field present in req. Optional fields
+// may be set to "" to clear them.
+func validateProfileUpdate(req UpdateProfileRequest) *httpx.Validator {
+	v := httpx.NewValidator()
+
+	if req.DisplayName != nil {
+		if v.Required("displayName", *req.DisplayName) {
+			v.MaxLength("displayName", *req.DisplayName, maxDisplayNameLength)
+		}
+	}
+	if req.Username != nil {
+		validateUsername(v, "username", *req.Username)
+	}
+	if req.PhotoURL != nil && *req.PhotoURL != "" {
+		if v.MaxLength("photoUrl", *req.PhotoURL, maxPhotoURLLength) {
+			v.URL("photoUrl", *req.PhotoURL, "https")
+		}
+	}
+	if req.Profession != nil {
+		v.MaxLength("profession", *req.Profession, maxProfessionLength)
+	}
+	if req.Birthday != nil && *req.Birthday != "" {
+		if birthday, ok := v.Date("birthday", *req.Birthday, birthdayLayout); ok {
+			v.MinAge("birthday", birthday, minimumAge)
+		}
+	}
+	if req.Gender != nil && *req.Gender != "" {
+		v.OneOf("gender", *req.Gender, genders...)
+	}
+	if req.Location != nil {
+		v.MaxLength("location", *req.Location, maxLocationLength)
+	}
+	if req.Bio != nil {
+		v.MaxLength("bio", *req.Bio, maxBioLength)
+	}
+
+	return v
+}